@@ -2,22 +2,35 @@ package main
 
 import (
 	"context"
+	"crypto/hkdf"
+	"crypto/sha256"
 	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
+	_ "time/tzdata"
 
 	"github.com/gin-contrib/sessions"
-	"github.com/gin-contrib/sessions/cookie"
+	"github.com/gin-gonic/gin"
 
+	"github.com/mine/shorturl/internal/audit"
+	"github.com/mine/shorturl/internal/auth"
 	"github.com/mine/shorturl/internal/config"
+	"github.com/mine/shorturl/internal/geoip"
 	"github.com/mine/shorturl/internal/httpapi"
 	"github.com/mine/shorturl/internal/links"
+	"github.com/mine/shorturl/internal/live"
+	"github.com/mine/shorturl/internal/metrics"
 	"github.com/mine/shorturl/internal/shortcode"
 	sqlitestore "github.com/mine/shorturl/internal/store/sqlite"
+	"github.com/mine/shorturl/internal/tracing"
+	"github.com/mine/shorturl/internal/useragent"
+	"github.com/mine/shorturl/internal/webhooks"
 )
 
 func main() {
@@ -27,20 +40,34 @@ func main() {
 
 	cfg := config.FromEnv()
 
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Config{
+		Exporter:    cfg.TracingExporter,
+		Endpoint:    cfg.TracingEndpoint,
+		ServiceName: "shorturl",
+		SampleRatio: cfg.TracingSampleRatio,
+	})
+	if err != nil {
+		logger.Error("init tracing failed", "error", err)
+		os.Exit(1)
+	}
+
 	storeKey := cfg.SessionSecret
 	if storeKey == "" {
 		storeKey = shortcode.MustRandomString(32)
 		logger.Warn("SESSION_SECRET 未设置，已生成临时密钥（重启会使登录失效）")
 	}
 
-	database, err := sqlitestore.Open(cfg.DBPath)
+	serverMetrics := metrics.New()
+	database, err := sqlitestore.Open(cfg.DBPath, sqlitestore.WithQueryObserver(serverMetrics.ObserveQuery))
 	if err != nil {
 		logger.Error("open database failed", "error", err)
 		os.Exit(1)
 	}
 	defer database.Close()
 
-	ctx := context.Background()
+	// ctx is cancelled on SIGINT / SIGTERM and stops the background loops.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 	if err := sqlitestore.Init(ctx, database); err != nil {
 		logger.Error("init database failed", "error", err)
 		os.Exit(1)
@@ -52,7 +79,7 @@ func main() {
 		os.Exit(1)
 	}
 
-	sessionStore := cookie.NewStore([]byte(storeKey))
+	sessionStore := sqlitestore.NewSessionStore(database, httpapi.SessionUserKey, []byte(storeKey))
 	sessionStore.Options(sessions.Options{
 		Path:     "/",
 		MaxAge:   int((14 * 24 * time.Hour).Seconds()),
@@ -61,15 +88,130 @@ func main() {
 		Secure:   cfg.CookieSecure,
 	})
 
+	throttlePolicy := auth.DefaultThrottlePolicy()
+	throttlePolicy.MaxUsernameFailures = cfg.LoginMaxAttempts
+	throttlePolicy.MaxIPFailures = cfg.LoginMaxAttemptsPerIP
+	throttlePolicy.BaseLockout = cfg.LoginLockoutBase
+	throttlePolicy.MaxLockout = cfg.LoginLockoutMax
+	throttle := auth.NewThrottle(sqlitestore.NewLoginAttemptRepository(database), throttlePolicy)
+
+	var oidcLogin *auth.OIDC
+	if cfg.OIDCEnabled() {
+		oidcSettings, err := oidcConfig(cfg)
+		if err != nil {
+			logger.Error("invalid oidc config", "error", err)
+			os.Exit(1)
+		}
+		oidcLogin, err = auth.NewOIDC(ctx, oidcSettings)
+		if err != nil {
+			logger.Error("init oidc failed", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	authChain, err := buildAuthChain(cfg, userRepo)
+	if err != nil {
+		logger.Error("init auth providers failed", "error", err)
+		os.Exit(1)
+	}
+	logger.Info("auth providers enabled", "providers", authChain.Names())
+
 	linkRepo := sqlitestore.NewLinkRepository(database)
-	linkService := links.NewService(linkRepo)
-	router := httpapi.NewRouter(logger, sessionStore, cfg.AdminStaticDir, linkService, userRepo)
+	analyticsLocation, err := time.LoadLocation(cfg.AnalyticsTimezone)
+	if err != nil {
+		logger.Error("load analytics timezone failed", "error", err, "timezone", cfg.AnalyticsTimezone)
+		os.Exit(1)
+	}
+	agents, err := useragent.Load(cfg.UserAgentRulesFile)
+	if err != nil {
+		logger.Error("load user agent rules failed", "error", err, "path", cfg.UserAgentRulesFile)
+		os.Exit(1)
+	}
+	ipMode, err := links.ParseIPMode(cfg.VisitIPMode)
+	if err != nil {
+		logger.Error("invalid visit ip mode", "error", err, "mode", cfg.VisitIPMode)
+		os.Exit(1)
+	}
+	visitStream := live.NewHub()
+	hooks := webhooks.NewService(
+		sqlitestore.NewWebhookRepository(database),
+		logger,
+		webhooks.WithHTTPClient(&http.Client{Timeout: cfg.WebhookTimeout}),
+		webhooks.WithRetryPolicy(cfg.WebhookMaxAttempts, 0, 0),
+	)
+	linkOptions := []links.ServiceOption{
+		links.WithDefaultLocation(analyticsLocation),
+		links.WithTrackedParams(cfg.VisitTrackedParams...),
+		links.WithVisitorSecret(subkey(storeKey, "shorturl visitor hash")),
+		links.WithVisitPublisher(visitStream),
+		links.WithUserAgentParser(agents),
+		links.WithIPMode(ipMode),
+		links.WithClickIDParam(cfg.ClickIDParam),
+		links.WithPostbackToken(cfg.ConversionToken),
+		links.WithLinkObserver(hooks),
+	}
+	if cfg.GeoIPEnabled() {
+		locator, err := geoip.Open(logger, cfg.GeoIPCityDB, cfg.GeoIPASNDB, cfg.GeoIPLanguage)
+		if err != nil {
+			logger.Error("open geoip database failed", "error", err)
+			os.Exit(1)
+		}
+		defer locator.Close()
+		go locator.Watch(ctx, cfg.GeoIPReloadInterval)
+		linkOptions = append(linkOptions, links.WithGeoLocator(locator))
+	}
+	linkService := links.NewService(linkRepo, linkOptions...)
+	go func() {
+		reparsed, err := linkService.ReparseVisits(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				logger.Error("reparse visit user agents failed", "error", err)
+			}
+			return
+		}
+		if reparsed > 0 {
+			logger.Info("visit user agents reparsed", "visits", reparsed, "rules_version", agents.Version())
+		}
+	}()
+	// The dispatcher outlives the HTTP server so events from requests still
+	// draining during shutdown are queued.
+	hooksCtx, stopHooks := context.WithCancel(context.WithoutCancel(ctx))
+	hooksDone := make(chan struct{})
+	go func() {
+		defer close(hooksDone)
+		hooks.Run(hooksCtx)
+	}()
+	go expireLinks(ctx, logger, linkService)
+	auditLog := audit.NewService(sqlitestore.NewAuditRepository(database))
+	router := httpapi.NewRouter(logger, sessionStore, cfg.AdminStaticDir, linkService, userRepo, authChain, throttle, oidcLogin, auditLog, visitStream, hooks, serverMetrics)
+	var metricsServer *http.Server
+	if cfg.MetricsAddr == "" {
+		router.GET("/metrics", gin.WrapH(serverMetrics.Handler(cfg.MetricsToken)))
+	} else {
+		mux := http.NewServeMux()
+		mux.Handle("GET /metrics", serverMetrics.Handler(cfg.MetricsToken))
+		metricsServer = &http.Server{
+			Addr:              cfg.MetricsAddr,
+			Handler:           mux,
+			ReadHeaderTimeout: 5 * time.Second,
+		}
+		go func() {
+			logger.Info("metrics server started", "addr", cfg.MetricsAddr)
+			if err := metricsServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logger.Error("listen metrics server failed", "error", err)
+				os.Exit(1)
+			}
+		}()
+	}
 
 	srv := &http.Server{
 		Addr:              cfg.Addr(),
 		Handler:           router,
 		ReadHeaderTimeout: 5 * time.Second,
 	}
+	// Shutdown does not wait for hijacked or streaming responses to notice;
+	// closing the hub ends open visit streams.
+	srv.RegisterOnShutdown(visitStream.Close)
 
 	go func() {
 		logger.Info("shorturl server started", "addr", cfg.Addr(), "database", cfg.DBPath)
@@ -79,11 +221,158 @@ func main() {
 		}
 	}()
 
-	stop := make(chan os.Signal, 1)
-	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
-	<-stop
+	<-ctx.Done()
+	stop()
+	logger.Info("shutting down")
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
-	_ = srv.Shutdown(ctx)
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		logger.Error("shutdown server failed", "error", err)
+	}
+	if metricsServer != nil {
+		_ = metricsServer.Shutdown(shutdownCtx)
+	}
+	stopHooks()
+	select {
+	case <-hooksDone:
+	case <-shutdownCtx.Done():
+	}
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		logger.Error("flush traces failed", "error", err)
+	}
+}
+
+// buildAuthChain builds the password providers in the order listed in AUTH_PROVIDERS.
+func buildAuthChain(cfg *config.Config, userRepo *sqlitestore.UserRepository) (*auth.Chain, error) {
+	providers := make([]auth.Provider, 0, len(cfg.AuthProviders))
+	for _, name := range cfg.AuthProviders {
+		switch strings.ToLower(name) {
+		case auth.SourceLocal:
+			providers = append(providers, auth.NewLocalProvider(userRepo))
+		case auth.SourceLDAP:
+			mapping, err := roleMapping("LDAP_ROLE_MAPPING", cfg.LDAPRoleMapping)
+			if err != nil {
+				return nil, err
+			}
+			defaultRole, err := optionalRole("LDAP_DEFAULT_ROLE", cfg.LDAPDefaultRole)
+			if err != nil {
+				return nil, err
+			}
+			provider, err := auth.NewLDAPProvider(auth.LDAPConfig{
+				URL:                cfg.LDAPURL,
+				StartTLS:           cfg.LDAPStartTLS,
+				InsecureSkipVerify: cfg.LDAPInsecureSkipVerify,
+				BindDN:             cfg.LDAPBindDN,
+				BindPassword:       cfg.LDAPBindPassword,
+				BaseDN:             cfg.LDAPBaseDN,
+				UserFilter:         cfg.LDAPUserFilter,
+				EmailAttribute:     cfg.LDAPEmailAttribute,
+				GroupAttribute:     cfg.LDAPGroupAttribute,
+				RoleMapping:        mapping,
+				DefaultRole:        defaultRole,
+				Timeout:            cfg.LDAPTimeout,
+			})
+			if err != nil {
+				return nil, err
+			}
+			providers = append(providers, provider)
+		case auth.SourceHtpasswd:
+			role, ok := auth.ParseRole(cfg.HtpasswdRole)
+			if !ok {
+				return nil, fmt.Errorf("invalid HTPASSWD_ROLE %q", cfg.HtpasswdRole)
+			}
+			provider, err := auth.NewHtpasswdProvider(cfg.HtpasswdFile, role)
+			if err != nil {
+				return nil, err
+			}
+			providers = append(providers, provider)
+		default:
+			return nil, fmt.Errorf("unknown auth provider %q", name)
+		}
+	}
+	if len(providers) == 0 {
+		return nil, errors.New("AUTH_PROVIDERS is empty")
+	}
+
+	return auth.NewChain(providers...), nil
+}
+
+// subkey derives an independent key from secret so that one secret can serve
+// several purposes without a leak in one exposing the others.
+func subkey(secret string, label string) []byte {
+	key, err := hkdf.Key(sha256.New, []byte(secret), nil, label, sha256.Size)
+	if err != nil {
+		panic(err)
+	}
+	return key
+}
+
+func roleMapping(name string, raw map[string]string) (map[string]auth.Role, error) {
+	mapping := make(map[string]auth.Role, len(raw))
+	for group, rawRole := range raw {
+		role, ok := auth.ParseRole(rawRole)
+		if !ok {
+			return nil, fmt.Errorf("invalid %s role %q for %q", name, rawRole, group)
+		}
+		mapping[group] = role
+	}
+	return mapping, nil
+}
+
+// optionalRole parses a default role setting. Empty means unmapped users are
+// refused, so only a misspelled role is an error.
+func optionalRole(name string, raw string) (auth.Role, error) {
+	if strings.TrimSpace(raw) == "" {
+		return "", nil
+	}
+	role, ok := auth.ParseRole(raw)
+	if !ok {
+		return "", fmt.Errorf("invalid %s %q", name, raw)
+	}
+	return role, nil
+}
+
+func oidcConfig(cfg *config.Config) (auth.OIDCConfig, error) {
+	mapping, err := roleMapping("OIDC_ROLE_MAPPING", cfg.OIDCRoleMapping)
+	if err != nil {
+		return auth.OIDCConfig{}, err
+	}
+	defaultRole, err := optionalRole("OIDC_DEFAULT_ROLE", cfg.OIDCDefaultRole)
+	if err != nil {
+		return auth.OIDCConfig{}, err
+	}
+
+	return auth.OIDCConfig{
+		IssuerURL:      cfg.OIDCIssuer,
+		ClientID:       cfg.OIDCClientID,
+		ClientSecret:   cfg.OIDCClientSecret,
+		RedirectURL:    cfg.OIDCRedirectURL,
+		Scopes:         cfg.OIDCScopes,
+		AllowedDomains: cfg.OIDCAllowedDomains,
+		RoleClaim:      cfg.OIDCRoleClaim,
+		RoleMapping:    mapping,
+		DefaultRole:    defaultRole,
+	}, nil
+}
+
+// expireLinks sweeps for links past their expires_at so link.expired is
+// reported shortly after it happens.
+func expireLinks(ctx context.Context, logger *slog.Logger, linkService *links.Service) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		expired, err := linkService.ExpireLinks(ctx)
+		if err != nil {
+			logger.Error("expire links failed", "error", err)
+		} else if expired > 0 {
+			logger.Info("links expired", "links", expired)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
 }
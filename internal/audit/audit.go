@@ -0,0 +1,176 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+const (
+	ActionLogin           = "auth.login"
+	ActionLoginFailed     = "auth.login_failed"
+	ActionLoginLocked     = "auth.login_locked"
+	ActionLogout          = "auth.logout"
+	ActionSSOLogin        = "auth.sso_login"
+	ActionPasswordChanged = "auth.password_changed"
+	ActionSessionRevoked  = "auth.session_revoked"
+	ActionLockoutCleared  = "auth.lockout_cleared"
+	ActionUserUpdated     = "user.updated"
+	ActionLinkCreated     = "link.created"
+	ActionLinkUpdated     = "link.updated"
+	ActionLinkEnabled     = "link.enabled"
+	ActionLinkDisabled    = "link.disabled"
+	ActionLinkDeleted     = "link.deleted"
+	ActionVisitsErased    = "visits.erased"
+	ActionWebhookCreated  = "webhook.created"
+	ActionWebhookUpdated  = "webhook.updated"
+	ActionWebhookDeleted  = "webhook.deleted"
+
+	TargetLink    = "link"
+	TargetUser    = "user"
+	TargetSession = "session"
+	TargetLockout = "lockout"
+	TargetVisits  = "visits"
+	TargetWebhook = "webhook"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
+type Entry struct {
+	ID         int64           `json:"id"`
+	Actor      string          `json:"actor"`
+	Action     string          `json:"action"`
+	TargetType string          `json:"target_type"`
+	TargetID   string          `json:"target_id"`
+	Before     json.RawMessage `json:"before,omitempty"`
+	After      json.RawMessage `json:"after,omitempty"`
+	IP         string          `json:"ip"`
+	UserAgent  string          `json:"user_agent"`
+	CreatedAt  time.Time       `json:"created_at"`
+}
+
+type Filter struct {
+	Actor      string
+	Action     string
+	TargetType string
+	TargetID   string
+	From       time.Time
+	To         time.Time
+	Page       int
+	PageSize   int
+}
+
+type Page struct {
+	Items    []Entry `json:"items"`
+	Total    int64   `json:"total"`
+	Page     int     `json:"page"`
+	PageSize int     `json:"page_size"`
+}
+
+// Repository is append-only: there is deliberately no update or delete.
+type Repository interface {
+	AppendAuditEntry(ctx context.Context, entry Entry) error
+	ListAuditEntries(ctx context.Context, filter Filter) ([]Entry, int64, error)
+	StreamAuditEntries(ctx context.Context, filter Filter, fn func(Entry) error) error
+}
+
+type Service struct {
+	repo Repository
+	now  func() time.Time
+}
+
+func NewService(repo Repository) *Service {
+	return &Service{repo: repo, now: time.Now}
+}
+
+func (s *Service) Record(ctx context.Context, entry Entry) error {
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = s.now().UTC()
+	}
+	return s.repo.AppendAuditEntry(ctx, entry)
+}
+
+func (s *Service) List(ctx context.Context, filter Filter) (Page, error) {
+	filter.Page = max(filter.Page, 1)
+	switch {
+	case filter.PageSize <= 0:
+		filter.PageSize = defaultPageSize
+	case filter.PageSize > maxPageSize:
+		filter.PageSize = maxPageSize
+	}
+
+	items, total, err := s.repo.ListAuditEntries(ctx, filter)
+	if err != nil {
+		return Page{}, err
+	}
+
+	return Page{Items: items, Total: total, Page: filter.Page, PageSize: filter.PageSize}, nil
+}
+
+// Export streams every entry matching filter, oldest first, ignoring paging.
+func (s *Service) Export(ctx context.Context, filter Filter, fn func(Entry) error) error {
+	filter.Page = 0
+	filter.PageSize = 0
+	return s.repo.StreamAuditEntries(ctx, filter, fn)
+}
+
+// Diff returns the JSON representations of before and after reduced to the
+// top-level fields that differ. Either side may be nil for creates and deletes.
+func Diff(before any, after any) (json.RawMessage, json.RawMessage, error) {
+	beforeFields, err := toFields(before)
+	if err != nil {
+		return nil, nil, err
+	}
+	afterFields, err := toFields(after)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if beforeFields != nil && afterFields != nil {
+		for key, value := range beforeFields {
+			if other, ok := afterFields[key]; ok && reflect.DeepEqual(value, other) {
+				delete(beforeFields, key)
+				delete(afterFields, key)
+			}
+		}
+	}
+
+	beforeJSON, err := marshalFields(beforeFields)
+	if err != nil {
+		return nil, nil, err
+	}
+	afterJSON, err := marshalFields(afterFields)
+	if err != nil {
+		return nil, nil, err
+	}
+	return beforeJSON, afterJSON, nil
+}
+
+func toFields(value any) (map[string]any, error) {
+	if value == nil {
+		return nil, nil
+	}
+
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("marshal audit snapshot: %w", err)
+	}
+
+	var fields map[string]any
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, fmt.Errorf("audit snapshot must be an object: %w", err)
+	}
+	return fields, nil
+}
+
+func marshalFields(fields map[string]any) (json.RawMessage, error) {
+	if fields == nil {
+		return nil, nil
+	}
+	return json.Marshal(fields)
+}
@@ -0,0 +1,127 @@
+package auth
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const SourceHtpasswd = "htpasswd"
+
+// HtpasswdProvider checks credentials against an Apache htpasswd file. Only
+// bcrypt and {SHA} entries are accepted; the file is re-read when it changes.
+type HtpasswdProvider struct {
+	path string
+	role Role
+
+	mu      sync.Mutex
+	modTime time.Time
+	entries map[string]string
+}
+
+func NewHtpasswdProvider(path string, role Role) (*HtpasswdProvider, error) {
+	provider := &HtpasswdProvider{path: path, role: role}
+	if err := provider.reload(); err != nil {
+		return nil, err
+	}
+	return provider, nil
+}
+
+func (p *HtpasswdProvider) Name() string {
+	return SourceHtpasswd
+}
+
+func (p *HtpasswdProvider) Authenticate(_ context.Context, username string, password string) (ExternalIdentity, error) {
+	if err := p.reload(); err != nil {
+		return ExternalIdentity{}, err
+	}
+
+	p.mu.Lock()
+	hash, ok := p.entries[username]
+	p.mu.Unlock()
+	if !ok || !checkHtpasswdHash(hash, password) {
+		return ExternalIdentity{}, ErrInvalidCredentials
+	}
+
+	return ExternalIdentity{
+		Source:   SourceHtpasswd,
+		Subject:  username,
+		Username: username,
+		Role:     p.role,
+	}, nil
+}
+
+func (p *HtpasswdProvider) reload() error {
+	info, err := os.Stat(p.path)
+	if err != nil {
+		return fmt.Errorf("stat htpasswd file: %w", err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.entries != nil && info.ModTime().Equal(p.modTime) {
+		return nil
+	}
+
+	entries, err := parseHtpasswd(p.path)
+	if err != nil {
+		return err
+	}
+	p.entries = entries
+	p.modTime = info.ModTime()
+	return nil
+}
+
+func parseHtpasswd(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open htpasswd file: %w", err)
+	}
+	defer file.Close()
+
+	entries := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		username, hash, ok := strings.Cut(line, ":")
+		if !ok || username == "" {
+			return nil, fmt.Errorf("htpasswd line %d: malformed entry", lineNo)
+		}
+		if !isBcryptHash(hash) && !strings.HasPrefix(hash, "{SHA}") {
+			return nil, fmt.Errorf("htpasswd line %d: unsupported hash for %q, use bcrypt", lineNo, username)
+		}
+		entries[username] = hash
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read htpasswd file: %w", err)
+	}
+
+	return entries, nil
+}
+
+func checkHtpasswdHash(hash string, password string) bool {
+	if isBcryptHash(hash) {
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	}
+
+	sum := sha1.Sum([]byte(password))
+	expected := "{SHA}" + base64.StdEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(hash), []byte(expected)) == 1
+}
+
+func isBcryptHash(hash string) bool {
+	return strings.HasPrefix(hash, "$2y$") || strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$")
+}
@@ -0,0 +1,169 @@
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+const SourceLDAP = "ldap"
+
+type LDAPConfig struct {
+	URL                string
+	StartTLS           bool
+	InsecureSkipVerify bool
+	BindDN             string
+	BindPassword       string
+	BaseDN             string
+	UserFilter         string
+	EmailAttribute     string
+	GroupAttribute     string
+	RoleMapping        map[string]Role
+	DefaultRole        Role
+	Timeout            time.Duration
+}
+
+// LDAPProvider finds the user with a search (optionally as a service account)
+// and then binds as that entry to check the password. Groups are matched
+// against RoleMapping by full DN or by their CN, case-insensitively.
+type LDAPProvider struct {
+	config      LDAPConfig
+	roleMapping map[string]Role
+}
+
+func NewLDAPProvider(cfg LDAPConfig) (*LDAPProvider, error) {
+	if cfg.URL == "" || cfg.BaseDN == "" {
+		return nil, errors.New("ldap url and base dn are required")
+	}
+	if cfg.UserFilter == "" {
+		cfg.UserFilter = "(uid=%s)"
+	}
+	if !strings.Contains(cfg.UserFilter, "%s") {
+		return nil, errors.New("ldap user filter must contain %s")
+	}
+	if cfg.EmailAttribute == "" {
+		cfg.EmailAttribute = "mail"
+	}
+	if cfg.GroupAttribute == "" {
+		cfg.GroupAttribute = "memberOf"
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 5 * time.Second
+	}
+
+	roleMapping := make(map[string]Role, len(cfg.RoleMapping))
+	for group, role := range cfg.RoleMapping {
+		roleMapping[strings.ToLower(strings.TrimSpace(group))] = role
+	}
+
+	return &LDAPProvider{config: cfg, roleMapping: roleMapping}, nil
+}
+
+func (p *LDAPProvider) Name() string {
+	return SourceLDAP
+}
+
+func (p *LDAPProvider) Authenticate(ctx context.Context, username string, password string) (ExternalIdentity, error) {
+	// An empty password would turn the user bind into an unauthenticated bind,
+	// which many servers accept.
+	if password == "" {
+		return ExternalIdentity{}, ErrInvalidCredentials
+	}
+
+	conn, err := p.dial(ctx)
+	if err != nil {
+		return ExternalIdentity{}, err
+	}
+	defer conn.Close()
+
+	if p.config.BindDN != "" {
+		if err := conn.Bind(p.config.BindDN, p.config.BindPassword); err != nil {
+			return ExternalIdentity{}, fmt.Errorf("ldap service bind: %w", err)
+		}
+	}
+
+	result, err := conn.Search(ldap.NewSearchRequest(
+		p.config.BaseDN,
+		ldap.ScopeWholeSubtree,
+		ldap.NeverDerefAliases,
+		2,
+		int(p.config.Timeout.Seconds()),
+		false,
+		fmt.Sprintf(p.config.UserFilter, ldap.EscapeFilter(username)),
+		[]string{p.config.EmailAttribute, p.config.GroupAttribute},
+		nil,
+	))
+	if err != nil {
+		return ExternalIdentity{}, fmt.Errorf("ldap search: %w", err)
+	}
+	if len(result.Entries) != 1 {
+		return ExternalIdentity{}, ErrInvalidCredentials
+	}
+	entry := result.Entries[0]
+
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return ExternalIdentity{}, ErrInvalidCredentials
+		}
+		return ExternalIdentity{}, fmt.Errorf("ldap user bind: %w", err)
+	}
+
+	role, ok := ResolveRole(groupKeys(entry.GetAttributeValues(p.config.GroupAttribute)), p.roleMapping, p.config.DefaultRole)
+	if !ok {
+		return ExternalIdentity{}, fmt.Errorf("%w: no role mapped for %s", ErrAccessDenied, username)
+	}
+
+	return ExternalIdentity{
+		Source:   SourceLDAP,
+		Subject:  entry.DN,
+		Username: username,
+		Email:    entry.GetAttributeValue(p.config.EmailAttribute),
+		Role:     role,
+	}, nil
+}
+
+func (p *LDAPProvider) dial(ctx context.Context) (*ldap.Conn, error) {
+	dialer := &net.Dialer{Timeout: p.config.Timeout}
+	if deadline, ok := ctx.Deadline(); ok {
+		dialer.Deadline = deadline
+	}
+	tlsConfig := &tls.Config{InsecureSkipVerify: p.config.InsecureSkipVerify}
+
+	conn, err := ldap.DialURL(p.config.URL, ldap.DialWithTLSDialer(tlsConfig, dialer))
+	if err != nil {
+		return nil, fmt.Errorf("ldap dial: %w", err)
+	}
+	conn.SetTimeout(p.config.Timeout)
+
+	if p.config.StartTLS {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("ldap starttls: %w", err)
+		}
+	}
+
+	return conn, nil
+}
+
+func groupKeys(groups []string) []string {
+	keys := make([]string, 0, len(groups)*2)
+	for _, group := range groups {
+		keys = append(keys, strings.ToLower(group))
+		dn, err := ldap.ParseDN(group)
+		if err != nil || len(dn.RDNs) == 0 {
+			continue
+		}
+		for _, attribute := range dn.RDNs[0].Attributes {
+			if strings.EqualFold(attribute.Type, "cn") {
+				keys = append(keys, strings.ToLower(attribute.Value))
+			}
+		}
+	}
+	return keys
+}
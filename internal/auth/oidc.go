@@ -0,0 +1,185 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+const SourceOIDC = "oidc"
+
+type OIDCConfig struct {
+	IssuerURL      string
+	ClientID       string
+	ClientSecret   string
+	RedirectURL    string
+	Scopes         []string
+	AllowedDomains []string
+	RoleClaim      string
+	RoleMapping    map[string]Role
+	DefaultRole    Role
+}
+
+type OIDC struct {
+	config   OIDCConfig
+	oauth    oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+func NewOIDC(ctx context.Context, cfg OIDCConfig) (*OIDC, error) {
+	provider, err := oidc.NewProvider(ctx, cfg.IssuerURL)
+	if err != nil {
+		return nil, fmt.Errorf("discover oidc provider: %w", err)
+	}
+
+	scopes := cfg.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"email", "profile"}
+	}
+	if !slices.Contains(scopes, oidc.ScopeOpenID) {
+		scopes = append([]string{oidc.ScopeOpenID}, scopes...)
+	}
+
+	return &OIDC{
+		config: cfg,
+		oauth: oauth2.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			RedirectURL:  cfg.RedirectURL,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       scopes,
+		},
+		verifier: provider.Verifier(&oidc.Config{ClientID: cfg.ClientID}),
+	}, nil
+}
+
+func GenerateVerifier() string {
+	return oauth2.GenerateVerifier()
+}
+
+func (o *OIDC) AuthCodeURL(state string, nonce string, verifier string) string {
+	return o.oauth.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier))
+}
+
+// Exchange redeems an authorization code, verifies the ID token and maps its
+// claims onto a local role.
+func (o *OIDC) Exchange(ctx context.Context, code string, verifier string, nonce string) (ExternalIdentity, error) {
+	token, err := o.oauth.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return ExternalIdentity{}, fmt.Errorf("exchange oidc code: %w", err)
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok || rawIDToken == "" {
+		return ExternalIdentity{}, errors.New("oidc token response has no id_token")
+	}
+
+	idToken, err := o.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return ExternalIdentity{}, fmt.Errorf("verify id_token: %w", err)
+	}
+	if idToken.Nonce != nonce {
+		return ExternalIdentity{}, errors.New("oidc nonce mismatch")
+	}
+
+	var claims map[string]any
+	if err := idToken.Claims(&claims); err != nil {
+		return ExternalIdentity{}, fmt.Errorf("decode id_token claims: %w", err)
+	}
+
+	return o.identityFromClaims(idToken.Subject, claims)
+}
+
+func (o *OIDC) identityFromClaims(subject string, claims map[string]any) (ExternalIdentity, error) {
+	email, _ := claims["email"].(string)
+	email = strings.ToLower(strings.TrimSpace(email))
+	if verified, ok := claims["email_verified"].(bool); ok && !verified {
+		email = ""
+	}
+	if email == "" {
+		return ExternalIdentity{}, fmt.Errorf("%w: verified email claim required", ErrAccessDenied)
+	}
+	if !o.domainAllowed(email) {
+		return ExternalIdentity{}, fmt.Errorf("%w: email domain not allowed", ErrAccessDenied)
+	}
+
+	role, ok := ResolveRole(claimStrings(claims[o.roleClaim()]), o.config.RoleMapping, o.config.DefaultRole)
+	if !ok {
+		return ExternalIdentity{}, fmt.Errorf("%w: no role mapped", ErrAccessDenied)
+	}
+
+	return ExternalIdentity{
+		Source:   SourceOIDC,
+		Subject:  subject,
+		Username: email,
+		Email:    email,
+		Role:     role,
+	}, nil
+}
+
+func (o *OIDC) domainAllowed(email string) bool {
+	if len(o.config.AllowedDomains) == 0 {
+		return true
+	}
+	_, domain, ok := strings.Cut(email, "@")
+	if !ok {
+		return false
+	}
+	for _, allowed := range o.config.AllowedDomains {
+		if strings.EqualFold(strings.TrimSpace(allowed), domain) {
+			return true
+		}
+	}
+	return false
+}
+
+func (o *OIDC) roleClaim() string {
+	if o.config.RoleClaim == "" {
+		return "groups"
+	}
+	return o.config.RoleClaim
+}
+
+// ResolveRole picks the most privileged role granted by any of groups and falls
+// back to fallback when nothing matches.
+func ResolveRole(groups []string, mapping map[string]Role, fallback Role) (Role, bool) {
+	var best Role
+	for _, group := range groups {
+		role, ok := mapping[group]
+		if !ok {
+			continue
+		}
+		if roleRanks[role] > roleRanks[best] {
+			best = role
+		}
+	}
+	if best != "" {
+		return best, true
+	}
+	if _, ok := roleRanks[fallback]; ok {
+		return fallback, true
+	}
+	return "", false
+}
+
+func claimStrings(value any) []string {
+	switch typed := value.(type) {
+	case string:
+		return []string{typed}
+	case []any:
+		result := make([]string, 0, len(typed))
+		for _, item := range typed {
+			if text, ok := item.(string); ok {
+				result = append(result, text)
+			}
+		}
+		return result
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Provider verifies a username and password against one identity source. It
+// returns ErrInvalidCredentials when it does not know the user or the password
+// is wrong, which lets the next provider in a Chain have a go.
+type Provider interface {
+	Name() string
+	Authenticate(ctx context.Context, username string, password string) (ExternalIdentity, error)
+}
+
+type PasswordChecker interface {
+	CheckPassword(ctx context.Context, username string, password string) (bool, error)
+}
+
+type Chain struct {
+	providers []Provider
+}
+
+func NewChain(providers ...Provider) *Chain {
+	return &Chain{providers: providers}
+}
+
+func (c *Chain) Names() []string {
+	names := make([]string, 0, len(c.providers))
+	for _, provider := range c.providers {
+		names = append(names, provider.Name())
+	}
+	return names
+}
+
+// Authenticate asks each provider in order. An unreachable directory must not
+// lock out local accounts, so provider errors are remembered and the chain
+// moves on; ErrAccessDenied stops it because the user did prove who they are.
+func (c *Chain) Authenticate(ctx context.Context, username string, password string) (ExternalIdentity, error) {
+	username = strings.TrimSpace(username)
+	if username == "" || password == "" {
+		return ExternalIdentity{}, ErrInvalidCredentials
+	}
+
+	var firstErr error
+	for _, provider := range c.providers {
+		identity, err := provider.Authenticate(ctx, username, password)
+		switch {
+		case err == nil:
+			return identity, nil
+		case errors.Is(err, ErrInvalidCredentials):
+			continue
+		case errors.Is(err, ErrAccessDenied):
+			return ExternalIdentity{}, err
+		case firstErr == nil:
+			firstErr = fmt.Errorf("%s provider: %w", provider.Name(), err)
+		}
+	}
+	if firstErr != nil {
+		return ExternalIdentity{}, firstErr
+	}
+
+	return ExternalIdentity{}, ErrInvalidCredentials
+}
+
+type localProvider struct {
+	checker PasswordChecker
+}
+
+// NewLocalProvider authenticates against password hashes stored in the users table.
+func NewLocalProvider(checker PasswordChecker) Provider {
+	return localProvider{checker: checker}
+}
+
+func (p localProvider) Name() string {
+	return SourceLocal
+}
+
+func (p localProvider) Authenticate(ctx context.Context, username string, password string) (ExternalIdentity, error) {
+	ok, err := p.checker.CheckPassword(ctx, username, password)
+	if err != nil {
+		return ExternalIdentity{}, err
+	}
+	if !ok {
+		return ExternalIdentity{}, ErrInvalidCredentials
+	}
+
+	return ExternalIdentity{Source: SourceLocal, Subject: username, Username: username}, nil
+}
@@ -0,0 +1,35 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var ErrSessionNotFound = errors.New("session not found")
+
+type Session struct {
+	ID         int64     `json:"id"`
+	Token      string    `json:"-"`
+	Username   string    `json:"username"`
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"user_agent"`
+	Device     string    `json:"device"`
+	Current    bool      `json:"current"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+type clientIPKey struct{}
+
+// WithClientIP records the proxy-aware client IP so that stores which only see
+// the *http.Request can attribute sessions correctly.
+func WithClientIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, clientIPKey{}, ip)
+}
+
+func ClientIP(ctx context.Context) string {
+	ip, _ := ctx.Value(clientIPKey{}).(string)
+	return ip
+}
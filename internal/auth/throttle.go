@@ -0,0 +1,173 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	ScopeUsername = "username"
+	ScopeIP       = "ip"
+)
+
+var ErrTooManyAttempts = errors.New("too many login attempts")
+
+type LockedError struct {
+	Scope      string
+	RetryAfter time.Duration
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("%s: %s locked for %s", ErrTooManyAttempts, e.Scope, e.RetryAfter)
+}
+
+func (e *LockedError) Unwrap() error {
+	return ErrTooManyAttempts
+}
+
+type LoginAttempt struct {
+	Scope        string     `json:"scope"`
+	Key          string     `json:"key"`
+	Failures     int        `json:"failures"`
+	LastFailedAt time.Time  `json:"last_failed_at"`
+	LockedUntil  *time.Time `json:"locked_until,omitempty"`
+}
+
+// AttemptRepository persists failed-attempt counters so lockouts survive restarts.
+// GetLoginAttempt returns a zero LoginAttempt when nothing has been recorded.
+// IncrementLoginAttempt must count atomically, so parallel guesses cannot
+// overwrite each other's failures; it starts over at 1 when the previous
+// failure was before resetBefore and returns the new count. LockLoginAttempt
+// never shortens a lockout that is already longer.
+type AttemptRepository interface {
+	GetLoginAttempt(ctx context.Context, scope string, key string) (LoginAttempt, error)
+	IncrementLoginAttempt(ctx context.Context, scope string, key string, now time.Time, resetBefore time.Time) (int, error)
+	LockLoginAttempt(ctx context.Context, scope string, key string, until time.Time) error
+	DeleteLoginAttempt(ctx context.Context, scope string, key string) error
+	ListLockedAttempts(ctx context.Context, now time.Time) ([]LoginAttempt, error)
+}
+
+type ThrottlePolicy struct {
+	MaxUsernameFailures int
+	MaxIPFailures       int
+	BaseLockout         time.Duration
+	MaxLockout          time.Duration
+	ResetAfter          time.Duration
+}
+
+func DefaultThrottlePolicy() ThrottlePolicy {
+	return ThrottlePolicy{
+		MaxUsernameFailures: 5,
+		MaxIPFailures:       20,
+		BaseLockout:         time.Minute,
+		MaxLockout:          time.Hour,
+		ResetAfter:          24 * time.Hour,
+	}
+}
+
+type Throttle struct {
+	repo   AttemptRepository
+	policy ThrottlePolicy
+	now    func() time.Time
+}
+
+func NewThrottle(repo AttemptRepository, policy ThrottlePolicy) *Throttle {
+	return &Throttle{repo: repo, policy: policy, now: time.Now}
+}
+
+// Check returns a *LockedError when either the username or the client IP is
+// currently locked out.
+func (t *Throttle) Check(ctx context.Context, username string, ip string) error {
+	now := t.now().UTC()
+
+	var longest *LockedError
+	for _, target := range t.targets(username, ip) {
+		attempt, err := t.repo.GetLoginAttempt(ctx, target.scope, target.key)
+		if err != nil {
+			return err
+		}
+		if attempt.LockedUntil == nil || !attempt.LockedUntil.After(now) {
+			continue
+		}
+
+		retryAfter := attempt.LockedUntil.Sub(now)
+		if longest == nil || retryAfter > longest.RetryAfter {
+			longest = &LockedError{Scope: target.scope, RetryAfter: retryAfter}
+		}
+	}
+	if longest != nil {
+		return longest
+	}
+
+	return nil
+}
+
+func (t *Throttle) RecordFailure(ctx context.Context, username string, ip string) error {
+	now := t.now().UTC()
+
+	for _, target := range t.targets(username, ip) {
+		failures, err := t.repo.IncrementLoginAttempt(ctx, target.scope, target.key, now, now.Add(-t.policy.ResetAfter))
+		if err != nil {
+			return err
+		}
+		if excess := failures - target.limit; target.limit > 0 && excess >= 0 {
+			if err := t.repo.LockLoginAttempt(ctx, target.scope, target.key, now.Add(t.lockoutFor(excess))); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// RecordSuccess clears the username counter only; clearing the IP counter would
+// let one valid account reset the budget for guessing others from the same IP.
+func (t *Throttle) RecordSuccess(ctx context.Context, username string) error {
+	username = strings.TrimSpace(username)
+	if username == "" {
+		return nil
+	}
+	return t.repo.DeleteLoginAttempt(ctx, ScopeUsername, username)
+}
+
+func (t *Throttle) ListLocked(ctx context.Context) ([]LoginAttempt, error) {
+	return t.repo.ListLockedAttempts(ctx, t.now().UTC())
+}
+
+func (t *Throttle) Unlock(ctx context.Context, scope string, key string) error {
+	if scope != ScopeUsername && scope != ScopeIP {
+		return fmt.Errorf("unknown lockout scope %q", scope)
+	}
+	return t.repo.DeleteLoginAttempt(ctx, scope, strings.TrimSpace(key))
+}
+
+func (t *Throttle) lockoutFor(excess int) time.Duration {
+	lockout := t.policy.BaseLockout
+	for range excess {
+		lockout *= 2
+		if lockout >= t.policy.MaxLockout {
+			return t.policy.MaxLockout
+		}
+	}
+	return min(lockout, t.policy.MaxLockout)
+}
+
+type throttleTarget struct {
+	scope string
+	key   string
+	limit int
+}
+
+func (t *Throttle) targets(username string, ip string) []throttleTarget {
+	targets := make([]throttleTarget, 0, 2)
+	if username = strings.TrimSpace(username); username != "" {
+		targets = append(targets, throttleTarget{scope: ScopeUsername, key: username, limit: t.policy.MaxUsernameFailures})
+	}
+	if ip = strings.TrimSpace(ip); ip != "" {
+		targets = append(targets, throttleTarget{scope: ScopeIP, key: ip, limit: t.policy.MaxIPFailures})
+	}
+	return targets
+}
@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	ErrUserNotFound = errors.New("user not found")
+	ErrUserConflict = errors.New("user belongs to another identity source")
+	ErrAccessDenied = errors.New("access denied")
+
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+type Role string
+
+const (
+	RoleViewer Role = "viewer"
+	RoleEditor Role = "editor"
+	RoleAdmin  Role = "admin"
+)
+
+const SourceLocal = "local"
+
+var roleRanks = map[Role]int{
+	RoleViewer: 1,
+	RoleEditor: 2,
+	RoleAdmin:  3,
+}
+
+func ParseRole(raw string) (Role, bool) {
+	role := Role(strings.ToLower(strings.TrimSpace(raw)))
+	_, ok := roleRanks[role]
+	return role, ok
+}
+
+// Allows reports whether r grants at least the privileges of required.
+func (r Role) Allows(required Role) bool {
+	return roleRanks[r] >= roleRanks[required] && roleRanks[r] > 0
+}
+
+type User struct {
+	ID        int64     `json:"id"`
+	Username  string    `json:"username"`
+	Email     string    `json:"email"`
+	Role      Role      `json:"role"`
+	Source    string    `json:"source"`
+	Disabled  bool      `json:"disabled"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ExternalIdentity is a user asserted by an outside identity provider. It is
+// provisioned into the users table on first login.
+type ExternalIdentity struct {
+	Source   string
+	Subject  string
+	Username string
+	Email    string
+	Role     Role
+}
@@ -3,6 +3,8 @@ package config
 import (
 	"os"
 	"strconv"
+	"strings"
+	"time"
 )
 
 type Config struct {
@@ -15,6 +17,61 @@ type Config struct {
 	AdminPassword  string
 	SessionSecret  string
 	CookieSecure   bool
+
+	AnalyticsTimezone  string
+	VisitTrackedParams []string
+	UserAgentRulesFile string
+	VisitIPMode        string
+	ClickIDParam       string
+	ConversionToken    string
+
+	WebhookMaxAttempts int
+	WebhookTimeout     time.Duration
+
+	MetricsAddr  string
+	MetricsToken string
+
+	TracingExporter    string
+	TracingEndpoint    string
+	TracingSampleRatio float64
+
+	GeoIPCityDB         string
+	GeoIPASNDB          string
+	GeoIPLanguage       string
+	GeoIPReloadInterval time.Duration
+
+	LoginMaxAttempts      int
+	LoginMaxAttemptsPerIP int
+	LoginLockoutBase      time.Duration
+	LoginLockoutMax       time.Duration
+
+	OIDCIssuer         string
+	OIDCClientID       string
+	OIDCClientSecret   string
+	OIDCRedirectURL    string
+	OIDCScopes         []string
+	OIDCAllowedDomains []string
+	OIDCRoleClaim      string
+	OIDCRoleMapping    map[string]string
+	OIDCDefaultRole    string
+
+	AuthProviders []string
+
+	LDAPURL                string
+	LDAPStartTLS           bool
+	LDAPInsecureSkipVerify bool
+	LDAPBindDN             string
+	LDAPBindPassword       string
+	LDAPBaseDN             string
+	LDAPUserFilter         string
+	LDAPEmailAttribute     string
+	LDAPGroupAttribute     string
+	LDAPRoleMapping        map[string]string
+	LDAPDefaultRole        string
+	LDAPTimeout            time.Duration
+
+	HtpasswdFile string
+	HtpasswdRole string
 }
 
 func FromEnv() *Config {
@@ -28,10 +85,73 @@ func FromEnv() *Config {
 		AdminPassword:  os.Getenv("ADMIN_PASSWORD"),
 		SessionSecret:  os.Getenv("SESSION_SECRET"),
 		CookieSecure:   getenvBool("COOKIE_SECURE", false),
+
+		AnalyticsTimezone:  getenv("ANALYTICS_TIMEZONE", "UTC"),
+		VisitTrackedParams: getenvList("VISIT_TRACKED_PARAMS", []string{"gclid", "fbclid"}),
+		UserAgentRulesFile: os.Getenv("UA_RULES_FILE"),
+		VisitIPMode:        getenv("VISIT_IP_MODE", "raw"),
+		ClickIDParam:       os.Getenv("CLICK_ID_PARAM"),
+		ConversionToken:    os.Getenv("CONVERSION_TOKEN"),
+
+		WebhookMaxAttempts: getenvInt("WEBHOOK_MAX_ATTEMPTS", 8),
+		WebhookTimeout:     getenvDuration("WEBHOOK_TIMEOUT", 10*time.Second),
+
+		MetricsAddr:  os.Getenv("METRICS_ADDR"),
+		MetricsToken: os.Getenv("METRICS_TOKEN"),
+
+		TracingExporter:    os.Getenv("TRACING_EXPORTER"),
+		TracingEndpoint:    os.Getenv("TRACING_ENDPOINT"),
+		TracingSampleRatio: getenvFloat("TRACING_SAMPLE_RATIO", 1),
+
+		GeoIPCityDB:         os.Getenv("GEOIP_CITY_DB"),
+		GeoIPASNDB:          os.Getenv("GEOIP_ASN_DB"),
+		GeoIPLanguage:       getenv("GEOIP_LANGUAGE", "zh-CN"),
+		GeoIPReloadInterval: getenvDuration("GEOIP_RELOAD_INTERVAL", time.Minute),
+
+		LoginMaxAttempts:      getenvInt("LOGIN_MAX_ATTEMPTS", 5),
+		LoginMaxAttemptsPerIP: getenvInt("LOGIN_MAX_ATTEMPTS_PER_IP", 20),
+		LoginLockoutBase:      getenvDuration("LOGIN_LOCKOUT_BASE", time.Minute),
+		LoginLockoutMax:       getenvDuration("LOGIN_LOCKOUT_MAX", time.Hour),
+
+		OIDCIssuer:         os.Getenv("OIDC_ISSUER"),
+		OIDCClientID:       os.Getenv("OIDC_CLIENT_ID"),
+		OIDCClientSecret:   os.Getenv("OIDC_CLIENT_SECRET"),
+		OIDCRedirectURL:    os.Getenv("OIDC_REDIRECT_URL"),
+		OIDCScopes:         getenvList("OIDC_SCOPES", []string{"openid", "email", "profile"}),
+		OIDCAllowedDomains: getenvList("OIDC_ALLOWED_DOMAINS", nil),
+		OIDCRoleClaim:      getenv("OIDC_ROLE_CLAIM", "groups"),
+		OIDCRoleMapping:    getenvMap("OIDC_ROLE_MAPPING"),
+		OIDCDefaultRole:    os.Getenv("OIDC_DEFAULT_ROLE"),
+
+		AuthProviders: getenvList("AUTH_PROVIDERS", []string{"local"}),
+
+		LDAPURL:                os.Getenv("LDAP_URL"),
+		LDAPStartTLS:           getenvBool("LDAP_STARTTLS", false),
+		LDAPInsecureSkipVerify: getenvBool("LDAP_INSECURE_SKIP_VERIFY", false),
+		LDAPBindDN:             os.Getenv("LDAP_BIND_DN"),
+		LDAPBindPassword:       os.Getenv("LDAP_BIND_PASSWORD"),
+		LDAPBaseDN:             os.Getenv("LDAP_BASE_DN"),
+		LDAPUserFilter:         getenv("LDAP_USER_FILTER", "(uid=%s)"),
+		LDAPEmailAttribute:     getenv("LDAP_EMAIL_ATTRIBUTE", "mail"),
+		LDAPGroupAttribute:     getenv("LDAP_GROUP_ATTRIBUTE", "memberOf"),
+		LDAPRoleMapping:        getenvMap("LDAP_ROLE_MAPPING"),
+		LDAPDefaultRole:        os.Getenv("LDAP_DEFAULT_ROLE"),
+		LDAPTimeout:            getenvDuration("LDAP_TIMEOUT", 5*time.Second),
+
+		HtpasswdFile: os.Getenv("HTPASSWD_FILE"),
+		HtpasswdRole: getenv("HTPASSWD_ROLE", "viewer"),
 	}
 	return cfg
 }
 
+func (c *Config) GeoIPEnabled() bool {
+	return c.GeoIPCityDB != "" || c.GeoIPASNDB != ""
+}
+
+func (c *Config) OIDCEnabled() bool {
+	return c.OIDCIssuer != "" && c.OIDCClientID != ""
+}
+
 func (c *Config) Addr() string {
 	return c.Host + ":" + strconv.Itoa(c.Port)
 }
@@ -56,6 +176,18 @@ func getenvInt(k string, def int) int {
 	return n
 }
 
+func getenvFloat(k string, def float64) float64 {
+	v := os.Getenv(k)
+	if v == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return def
+	}
+	return f
+}
+
 func getenvBool(k string, def bool) bool {
 	v := os.Getenv(k)
 	if v == "" {
@@ -67,3 +199,43 @@ func getenvBool(k string, def bool) bool {
 	}
 	return b
 }
+
+func getenvDuration(k string, def time.Duration) time.Duration {
+	v := os.Getenv(k)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return def
+	}
+	return d
+}
+
+func getenvList(k string, def []string) []string {
+	v := os.Getenv(k)
+	if v == "" {
+		return def
+	}
+	items := make([]string, 0)
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// getenvMap parses "key=value,key2=value2" pairs; malformed pairs are skipped.
+func getenvMap(k string) map[string]string {
+	result := make(map[string]string)
+	for _, pair := range getenvList(k, nil) {
+		key, value, ok := strings.Cut(pair, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			continue
+		}
+		result[key] = strings.TrimSpace(value)
+	}
+	return result
+}
@@ -0,0 +1,207 @@
+package geoip
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang"
+
+	"github.com/mine/shorturl/internal/links"
+)
+
+type cityRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	Subdivisions []struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"subdivisions"`
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+}
+
+type asnRecord struct {
+	Number       uint   `maxminddb:"autonomous_system_number"`
+	Organization string `maxminddb:"autonomous_system_organization"`
+}
+
+// Locator answers lookups from local City and ASN databases. Either path may
+// be empty. Files are re-opened when they are replaced on disk.
+type Locator struct {
+	logger   *slog.Logger
+	language string
+	city     *database
+	asn      *database
+}
+
+func Open(logger *slog.Logger, cityPath string, asnPath string, language string) (*Locator, error) {
+	if cityPath == "" && asnPath == "" {
+		return nil, errors.New("geoip: no database configured")
+	}
+
+	locator := &Locator{logger: logger, language: language}
+	if cityPath != "" {
+		city, err := openDatabase(cityPath)
+		if err != nil {
+			return nil, err
+		}
+		locator.city = city
+	}
+	if asnPath != "" {
+		asn, err := openDatabase(asnPath)
+		if err != nil {
+			locator.Close()
+			return nil, err
+		}
+		locator.asn = asn
+	}
+
+	return locator, nil
+}
+
+func (l *Locator) Locate(raw string) links.GeoLocation {
+	var location links.GeoLocation
+	ip := net.ParseIP(raw)
+	if ip == nil {
+		return location
+	}
+
+	if l.city != nil {
+		var record cityRecord
+		if err := l.city.lookup(ip, &record); err == nil {
+			location.CountryCode = record.Country.ISOCode
+			location.City = l.name(record.City.Names)
+			if len(record.Subdivisions) > 0 {
+				location.Region = l.name(record.Subdivisions[0].Names)
+			}
+		}
+	}
+	if l.asn != nil {
+		var record asnRecord
+		if err := l.asn.lookup(ip, &record); err == nil {
+			location.ASN = record.Number
+			location.ASOrg = record.Organization
+		}
+	}
+
+	return location
+}
+
+// Watch polls the database files and swaps in a new reader when one changes.
+// It returns when ctx is done.
+func (l *Locator) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, db := range []*database{l.city, l.asn} {
+				if db == nil {
+					continue
+				}
+				reloaded, err := db.reloadIfChanged()
+				if err != nil {
+					l.logger.Error("reload geoip database failed", "error", err, "path", db.path)
+					continue
+				}
+				if reloaded {
+					l.logger.Info("geoip database reloaded", "path", db.path)
+				}
+			}
+		}
+	}
+}
+
+func (l *Locator) Close() {
+	for _, db := range []*database{l.city, l.asn} {
+		if db != nil {
+			db.close()
+		}
+	}
+}
+
+func (l *Locator) name(names map[string]string) string {
+	if name := names[l.language]; name != "" {
+		return name
+	}
+	return names["en"]
+}
+
+type database struct {
+	path string
+
+	mu      sync.RWMutex
+	reader  *maxminddb.Reader
+	modTime time.Time
+	size    int64
+}
+
+func openDatabase(path string) (*database, error) {
+	db := &database{path: path}
+	if _, err := db.reloadIfChanged(); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+func (d *database) lookup(ip net.IP, result any) error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.reader == nil {
+		return errors.New("geoip database closed")
+	}
+	return d.reader.Lookup(ip, result)
+}
+
+// reloadIfChanged opens the file again when its size or mtime moved. Updates
+// are expected to replace the file (write then rename), which leaves the old
+// mapping valid until it is closed here.
+func (d *database) reloadIfChanged() (bool, error) {
+	info, err := os.Stat(d.path)
+	if err != nil {
+		return false, fmt.Errorf("stat geoip database: %w", err)
+	}
+
+	d.mu.RLock()
+	unchanged := d.reader != nil && info.ModTime().Equal(d.modTime) && info.Size() == d.size
+	d.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	reader, err := maxminddb.Open(d.path)
+	if err != nil {
+		return false, fmt.Errorf("open geoip database: %w", err)
+	}
+
+	d.mu.Lock()
+	previous := d.reader
+	d.reader = reader
+	d.modTime = info.ModTime()
+	d.size = info.Size()
+	d.mu.Unlock()
+
+	if previous != nil {
+		_ = previous.Close()
+	}
+	return true, nil
+}
+
+func (d *database) close() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.reader != nil {
+		_ = d.reader.Close()
+		d.reader = nil
+	}
+}
@@ -0,0 +1,102 @@
+package geoip
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/maxmind/mmdbwriter"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+)
+
+func TestLocatorLooksUpAndReloads(t *testing.T) {
+	dir := t.TempDir()
+	cityPath := filepath.Join(dir, "city.mmdb")
+	asnPath := filepath.Join(dir, "asn.mmdb")
+	writeCityDB(t, cityPath, "81.2.69.0/24", "GB", "England", "London")
+	writeASNDB(t, asnPath, "81.2.69.0/24", 20712, "Andrews & Arnold Ltd")
+
+	locator, err := Open(slog.New(slog.NewTextHandler(io.Discard, nil)), cityPath, asnPath, "zh-CN")
+	if err != nil {
+		t.Fatalf("open locator: %v", err)
+	}
+	t.Cleanup(locator.Close)
+
+	location := locator.Locate("81.2.69.142")
+	if location.CountryCode != "GB" || location.Region != "England" || location.City != "London" || location.ASN != 20712 {
+		t.Fatalf("unexpected location %+v", location)
+	}
+	if unknown := locator.Locate("10.0.0.1"); unknown.CountryCode != "" || unknown.ASN != 0 {
+		t.Fatalf("expected empty location for unknown ip, got %+v", unknown)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go locator.Watch(ctx, 10*time.Millisecond)
+
+	// Replace the file the way updaters do: write a new one and rename it over.
+	replacement := filepath.Join(dir, "city.mmdb.tmp")
+	writeCityDB(t, replacement, "81.2.69.0/24", "GB", "Scotland", "Edinburgh")
+	future := time.Now().Add(time.Minute)
+	_ = os.Chtimes(replacement, future, future)
+	if err := os.Rename(replacement, cityPath); err != nil {
+		t.Fatalf("replace city db: %v", err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for locator.Locate("81.2.69.142").City != "Edinburgh" {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected reloaded city, still got %+v", locator.Locate("81.2.69.142"))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func writeCityDB(t *testing.T, path string, cidr string, country string, region string, city string) {
+	t.Helper()
+	writeDB(t, path, "GeoLite2-City", cidr, mmdbtype.Map{
+		"country": mmdbtype.Map{"iso_code": mmdbtype.String(country)},
+		"subdivisions": mmdbtype.Slice{
+			mmdbtype.Map{"names": mmdbtype.Map{"en": mmdbtype.String(region)}},
+		},
+		"city": mmdbtype.Map{"names": mmdbtype.Map{"en": mmdbtype.String(city)}},
+	})
+}
+
+func writeASNDB(t *testing.T, path string, cidr string, number uint32, organization string) {
+	t.Helper()
+	writeDB(t, path, "GeoLite2-ASN", cidr, mmdbtype.Map{
+		"autonomous_system_number":       mmdbtype.Uint32(number),
+		"autonomous_system_organization": mmdbtype.String(organization),
+	})
+}
+
+func writeDB(t *testing.T, path string, databaseType string, cidr string, record mmdbtype.Map) {
+	t.Helper()
+
+	tree, err := mmdbwriter.New(mmdbwriter.Options{DatabaseType: databaseType, RecordSize: 24})
+	if err != nil {
+		t.Fatalf("new mmdb tree: %v", err)
+	}
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		t.Fatalf("parse cidr: %v", err)
+	}
+	if err := tree.Insert(network, record); err != nil {
+		t.Fatalf("insert network: %v", err)
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("create mmdb: %v", err)
+	}
+	defer file.Close()
+	if _, err := tree.WriteTo(file); err != nil {
+		t.Fatalf("write mmdb: %v", err)
+	}
+}
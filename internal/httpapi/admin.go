@@ -2,6 +2,7 @@ package httpapi
 
 import (
 	"errors"
+	"log/slog"
 	"net/http"
 	"strconv"
 	"strings"
@@ -9,7 +10,11 @@ import (
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
 
+	"github.com/mine/shorturl/internal/audit"
+	"github.com/mine/shorturl/internal/auth"
 	"github.com/mine/shorturl/internal/links"
+	"github.com/mine/shorturl/internal/live"
+	"github.com/mine/shorturl/internal/webhooks"
 )
 
 type loginRequest struct {
@@ -17,24 +22,74 @@ type loginRequest struct {
 	Password string `json:"password"`
 }
 
-func registerAdminRoutes(router *gin.Engine, adminStaticDir string, linkService *links.Service, auth authChecker) {
+func registerAdminRoutes(
+	router *gin.Engine,
+	logger *slog.Logger,
+	adminStaticDir string,
+	linkService *links.Service,
+	users userStore,
+	sessionStore sessionBackend,
+	throttle *auth.Throttle,
+	authChain *auth.Chain,
+	oidcLogin *auth.OIDC,
+	auditLog *audit.Service,
+	visitStream *live.Hub,
+	hooks *webhooks.Service,
+) {
+	trail := auditTrail{service: auditLog, logger: logger}
+
 	adminAPI := router.Group("/admin/api/v1")
-	adminAPI.POST("/auth/login", loginHandler(auth))
-	adminAPI.POST("/auth/logout", logoutHandler())
-	adminAPI.GET("/auth/session", sessionHandler())
+	adminAPI.GET("/openapi.json", openAPIHandler())
+	adminAPI.POST("/auth/login", loginHandler(logger, users, authChain, throttle, trail))
+	adminAPI.POST("/auth/logout", logoutHandler(trail))
+	adminAPI.GET("/auth/providers", authProvidersHandler(authChain, oidcLogin))
+	if oidcLogin != nil {
+		adminAPI.GET("/auth/oidc/login", oidcLoginHandler(oidcLogin))
+		adminAPI.GET("/auth/oidc/callback", oidcCallbackHandler(logger, oidcLogin, users, trail))
+	}
 
 	protected := adminAPI.Group("/")
-	protected.Use(requireLogin())
+	protected.Use(requireLogin(users))
+	protected.GET("/auth/session", sessionHandler())
+	protected.GET("/auth/sessions", listSessionsHandler(sessionStore))
+	protected.DELETE("/auth/sessions", revokeOtherSessionsHandler(sessionStore, trail))
+	protected.DELETE("/auth/sessions/:id", revokeSessionHandler(sessionStore, trail))
+	protected.POST("/auth/password", changePasswordHandler(users, trail))
 	protected.GET("/links", listLinksHandler(linkService))
 	protected.GET("/links/:id/analytics", getLinkAnalyticsHandler(linkService))
-	protected.POST("/links", createLinkHandler(linkService))
-	protected.PUT("/links/:id", updateLinkHandler(linkService))
-	protected.DELETE("/links/:id", deleteLinkHandler(linkService))
+	protected.GET("/analytics/overview", getOverviewHandler(linkService))
+	protected.GET("/analytics/tags", getTagAnalyticsHandler(linkService))
+	protected.GET("/analytics/tags/matrix", getTagMatrixHandler(linkService))
+	protected.GET("/analytics/breakdown", getBreakdownHandler(linkService))
+	protected.GET("/visits/export", exportVisitsHandler(logger, linkService))
+	protected.GET("/visits/stream", streamVisitsHandler(visitStream))
+
+	editors := protected.Group("/")
+	editors.Use(requireRole(auth.RoleEditor))
+	editors.POST("/links", createLinkHandler(linkService, trail))
+	editors.PUT("/links/:id", updateLinkHandler(linkService, trail))
+	editors.DELETE("/links/:id", deleteLinkHandler(linkService, trail))
+
+	admins := protected.Group("/")
+	admins.Use(requireRole(auth.RoleAdmin))
+	admins.GET("/auth/lockouts", listLockoutsHandler(throttle))
+	admins.POST("/auth/lockouts/unlock", unlockHandler(throttle, trail))
+	admins.GET("/users", listUsersHandler(users))
+	admins.PUT("/users/:id", updateUserHandler(users, trail))
+	admins.GET("/audit-logs", listAuditLogsHandler(auditLog))
+	admins.GET("/audit-logs/export", exportAuditLogsHandler(logger, auditLog))
+	admins.POST("/visits/erase", eraseVisitsHandler(linkService, trail))
+	admins.GET("/webhooks", listWebhooksHandler(hooks))
+	admins.POST("/webhooks", createWebhookHandler(hooks, trail))
+	admins.PUT("/webhooks/:id", updateWebhookHandler(hooks, trail))
+	admins.DELETE("/webhooks/:id", deleteWebhookHandler(hooks, trail))
+	admins.GET("/webhooks/:id/deliveries", listWebhookDeliveriesHandler(hooks))
+	admins.POST("/webhooks/:id/test", testWebhookHandler(hooks))
 
 	registerAdminSPARoutes(router, adminStaticDir)
 }
 
-func loginHandler(auth authChecker) gin.HandlerFunc {
+func loginHandler(logger *slog.Logger, users userStore, authChain *auth.Chain, throttle *auth.Throttle, trail auditTrail) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var request loginRequest
 		if err := c.ShouldBindJSON(&request); err != nil {
@@ -43,41 +98,89 @@ func loginHandler(auth authChecker) gin.HandlerFunc {
 		}
 
 		username := strings.TrimSpace(request.Username)
-		ok, err := auth.CheckPassword(c.Request.Context(), username, request.Password)
+		if err := throttle.Check(c.Request.Context(), username, c.ClientIP()); err != nil {
+			if errors.Is(err, auth.ErrTooManyAttempts) {
+				trail.record(c, username, audit.ActionLoginLocked, audit.TargetUser, username, nil, nil)
+			}
+			writeThrottleError(c, err)
+			return
+		}
+
+		identity, err := authChain.Authenticate(c.Request.Context(), username, request.Password)
 		if err != nil {
+			// Every failure counts, or guesses against the local accounts
+			// would go unthrottled whenever another provider is down.
+			if err := throttle.RecordFailure(c.Request.Context(), username, c.ClientIP()); err != nil {
+				writeJSONError(c, http.StatusInternalServerError, "internal_error")
+				return
+			}
+			switch {
+			case errors.Is(err, auth.ErrInvalidCredentials):
+				trail.record(c, username, audit.ActionLoginFailed, audit.TargetUser, username, nil, nil)
+				writeJSONError(c, http.StatusUnauthorized, "unauthorized")
+			case errors.Is(err, auth.ErrAccessDenied):
+				trail.record(c, username, audit.ActionLoginFailed, audit.TargetUser, username, nil, nil)
+				writeJSONError(c, http.StatusForbidden, "forbidden")
+			default:
+				logger.Error("authenticate failed", "error", err, "username", username)
+				writeJSONError(c, http.StatusInternalServerError, "internal_error")
+			}
+			return
+		}
+
+		user, err := loginUser(c, users, identity)
+		if err != nil {
+			if errors.Is(err, auth.ErrUserConflict) || errors.Is(err, auth.ErrAccessDenied) {
+				trail.record(c, username, audit.ActionLoginFailed, audit.TargetUser, username, nil, gin.H{"source": identity.Source})
+				writeJSONError(c, http.StatusForbidden, "forbidden")
+				return
+			}
 			writeJSONError(c, http.StatusInternalServerError, "internal_error")
 			return
 		}
-		if !ok {
-			writeJSONError(c, http.StatusUnauthorized, "unauthorized")
+		if err := throttle.RecordSuccess(c.Request.Context(), username); err != nil {
+			writeJSONError(c, http.StatusInternalServerError, "internal_error")
 			return
 		}
 
 		session := sessions.Default(c)
-		session.Set(sessionUserKey, username)
+		session.Set(SessionUserKey, user.Username)
 		if err := session.Save(); err != nil {
 			writeJSONError(c, http.StatusInternalServerError, "internal_error")
 			return
 		}
+		trail.record(c, user.Username, audit.ActionLogin, audit.TargetUser, strconv.FormatInt(user.ID, 10), nil, gin.H{"source": user.Source})
 
 		c.JSON(http.StatusOK, apiResponse{
 			Success: true,
-			Data: gin.H{
-				"authenticated": true,
-				"username":      username,
-			},
+			Data:    sessionPayload(user),
 		})
 	}
 }
 
-func logoutHandler() gin.HandlerFunc {
+// loginUser maps an authenticated identity onto a users row. Directory and
+// htpasswd users are provisioned like SSO users so roles and sessions work the
+// same for every source.
+func loginUser(c *gin.Context, users userStore, identity auth.ExternalIdentity) (auth.User, error) {
+	if identity.Source == auth.SourceLocal {
+		return users.GetUser(c.Request.Context(), identity.Username)
+	}
+	return users.ProvisionExternalUser(c.Request.Context(), identity)
+}
+
+func logoutHandler(trail auditTrail) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		username := currentUsername(c)
 		session := sessions.Default(c)
 		session.Clear()
+		session.Options(sessions.Options{Path: "/", MaxAge: -1})
 		if err := session.Save(); err != nil {
 			writeJSONError(c, http.StatusInternalServerError, "internal_error")
 			return
 		}
+		if username != "" {
+			trail.record(c, username, audit.ActionLogout, audit.TargetUser, username, nil, nil)
+		}
 
 		c.JSON(http.StatusOK, apiResponse{
 			Success: true,
@@ -88,22 +191,22 @@ func logoutHandler() gin.HandlerFunc {
 
 func sessionHandler() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		username := currentUsername(c)
-		if username == "" {
-			writeJSONError(c, http.StatusUnauthorized, "unauthorized")
-			return
-		}
-
 		c.JSON(http.StatusOK, apiResponse{
 			Success: true,
-			Data: gin.H{
-				"authenticated": true,
-				"username":      username,
-			},
+			Data:    sessionPayload(currentUser(c)),
 		})
 	}
 }
 
+func sessionPayload(user auth.User) gin.H {
+	return gin.H{
+		"authenticated": true,
+		"username":      user.Username,
+		"role":          user.Role,
+		"source":        user.Source,
+	}
+}
+
 func listLinksHandler(linkService *links.Service) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		result, err := linkService.List(c.Request.Context())
@@ -119,7 +222,7 @@ func listLinksHandler(linkService *links.Service) gin.HandlerFunc {
 	}
 }
 
-func createLinkHandler(linkService *links.Service) gin.HandlerFunc {
+func createLinkHandler(linkService *links.Service, trail auditTrail) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var request links.CreateLinkInput
 		if err := c.ShouldBindJSON(&request); err != nil {
@@ -132,6 +235,7 @@ func createLinkHandler(linkService *links.Service) gin.HandlerFunc {
 			writeLinkError(c, err)
 			return
 		}
+		trail.record(c, currentUser(c).Username, audit.ActionLinkCreated, audit.TargetLink, strconv.FormatInt(link.ID, 10), nil, link)
 
 		c.JSON(http.StatusCreated, apiResponse{
 			Success: true,
@@ -148,17 +252,126 @@ func getLinkAnalyticsHandler(linkService *links.Service) gin.HandlerFunc {
 			return
 		}
 
-		days := 7
-		if rawDays := strings.TrimSpace(c.Query("days")); rawDays != "" {
-			parsedDays, parseErr := strconv.Atoi(rawDays)
-			if parseErr != nil {
+		query, ok := analyticsQuery(c)
+		if !ok {
+			writeJSONError(c, http.StatusBadRequest, "invalid_request")
+			return
+		}
+
+		analytics, err := linkService.Analytics(c.Request.Context(), id, query)
+		if err != nil {
+			writeLinkError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, apiResponse{
+			Success: true,
+			Data:    analytics,
+		})
+	}
+}
+
+func getOverviewHandler(linkService *links.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		query, ok := analyticsQuery(c)
+		if !ok {
+			writeJSONError(c, http.StatusBadRequest, "invalid_request")
+			return
+		}
+
+		overview, err := linkService.Overview(c.Request.Context(), query)
+		if err != nil {
+			writeLinkError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, apiResponse{
+			Success: true,
+			Data:    overview,
+		})
+	}
+}
+
+// getTagAnalyticsHandler takes the tag as a query parameter so tags containing
+// "/" need no special escaping.
+func getTagAnalyticsHandler(linkService *links.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		query, ok := analyticsQuery(c)
+		if !ok {
+			writeJSONError(c, http.StatusBadRequest, "invalid_request")
+			return
+		}
+
+		analytics, err := linkService.TagAnalytics(c.Request.Context(), c.Query("tag"), query)
+		if err != nil {
+			writeLinkError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, apiResponse{
+			Success: true,
+			Data:    analytics,
+		})
+	}
+}
+
+func getTagMatrixHandler(linkService *links.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		query, ok := analyticsQuery(c)
+		if !ok {
+			writeJSONError(c, http.StatusBadRequest, "invalid_request")
+			return
+		}
+
+		matrix, err := linkService.TagMatrix(c.Request.Context(), c.QueryArray("tag"), query)
+		if err != nil {
+			writeLinkError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, apiResponse{
+			Success: true,
+			Data:    matrix,
+		})
+	}
+}
+
+// getBreakdownHandler pivots visits on any dimension, e.g.
+// ?dimension=referer_host&filter=device:mobile&filter=os:iOS.
+func getBreakdownHandler(linkService *links.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		query, ok := analyticsQuery(c)
+		if !ok {
+			writeJSONError(c, http.StatusBadRequest, "invalid_request")
+			return
+		}
+		request := links.BreakdownRequest{
+			Tag:       c.Query("tag"),
+			Dimension: c.Query("dimension"),
+		}
+		var err error
+		if rawID := strings.TrimSpace(c.Query("link_id")); rawID != "" {
+			if request.LinkID, err = strconv.ParseInt(rawID, 10, 64); err != nil {
+				writeJSONError(c, http.StatusBadRequest, "invalid_request")
+				return
+			}
+		}
+		if rawLimit := strings.TrimSpace(c.Query("limit")); rawLimit != "" {
+			if request.Limit, err = strconv.Atoi(rawLimit); err != nil {
 				writeJSONError(c, http.StatusBadRequest, "invalid_request")
 				return
 			}
-			days = parsedDays
+		}
+		for _, raw := range c.QueryArray("filter") {
+			filter, err := links.ParseDimensionFilter(raw)
+			if err != nil {
+				writeLinkError(c, err)
+				return
+			}
+			request.Filters = append(request.Filters, filter)
 		}
 
-		analytics, err := linkService.Analytics(c.Request.Context(), id, days)
+		breakdown, err := linkService.Breakdown(c.Request.Context(), request, query)
 		if err != nil {
 			writeLinkError(c, err)
 			return
@@ -166,12 +379,34 @@ func getLinkAnalyticsHandler(linkService *links.Service) gin.HandlerFunc {
 
 		c.JSON(http.StatusOK, apiResponse{
 			Success: true,
-			Data:    analytics,
+			Data:    breakdown,
 		})
 	}
 }
 
-func updateLinkHandler(linkService *links.Service) gin.HandlerFunc {
+// analyticsQuery reads the window parameters shared by the analytics endpoints.
+func analyticsQuery(c *gin.Context) (links.AnalyticsQuery, bool) {
+	query := links.AnalyticsQuery{
+		Days:        7,
+		From:        c.Query("from"),
+		To:          c.Query("to"),
+		Granularity: c.Query("granularity"),
+		Timezone:    c.Query("tz"),
+		IncludeBots: c.Query("include_bots") == "true" || c.Query("include_bots") == "1",
+		Compare:     c.Query("compare"),
+		CompareFrom: c.Query("compare_from"),
+	}
+	if rawDays := strings.TrimSpace(c.Query("days")); rawDays != "" {
+		parsedDays, err := strconv.Atoi(rawDays)
+		if err != nil {
+			return links.AnalyticsQuery{}, false
+		}
+		query.Days = parsedDays
+	}
+	return query, true
+}
+
+func updateLinkHandler(linkService *links.Service, trail auditTrail) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		id, err := strconv.ParseInt(c.Param("id"), 10, 64)
 		if err != nil {
@@ -185,12 +420,27 @@ func updateLinkHandler(linkService *links.Service) gin.HandlerFunc {
 			return
 		}
 
+		before, err := linkService.Get(c.Request.Context(), id)
+		if err != nil {
+			writeLinkError(c, err)
+			return
+		}
+
 		link, err := linkService.Update(c.Request.Context(), id, request)
 		if err != nil {
 			writeLinkError(c, err)
 			return
 		}
 
+		action := audit.ActionLinkUpdated
+		switch {
+		case before.Enabled && !link.Enabled:
+			action = audit.ActionLinkDisabled
+		case !before.Enabled && link.Enabled:
+			action = audit.ActionLinkEnabled
+		}
+		trail.record(c, currentUser(c).Username, action, audit.TargetLink, strconv.FormatInt(link.ID, 10), before, link)
+
 		c.JSON(http.StatusOK, apiResponse{
 			Success: true,
 			Data:    link,
@@ -198,7 +448,7 @@ func updateLinkHandler(linkService *links.Service) gin.HandlerFunc {
 	}
 }
 
-func deleteLinkHandler(linkService *links.Service) gin.HandlerFunc {
+func deleteLinkHandler(linkService *links.Service, trail auditTrail) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		id, err := strconv.ParseInt(c.Param("id"), 10, 64)
 		if err != nil {
@@ -206,10 +456,17 @@ func deleteLinkHandler(linkService *links.Service) gin.HandlerFunc {
 			return
 		}
 
+		before, err := linkService.Get(c.Request.Context(), id)
+		if err != nil {
+			writeLinkError(c, err)
+			return
+		}
+
 		if err := linkService.Delete(c.Request.Context(), id); err != nil {
 			writeLinkError(c, err)
 			return
 		}
+		trail.record(c, currentUser(c).Username, audit.ActionLinkDeleted, audit.TargetLink, strconv.FormatInt(id, 10), before, nil)
 
 		c.JSON(http.StatusOK, apiResponse{
 			Success: true,
@@ -229,7 +486,7 @@ func writeLinkError(c *gin.Context, err error) {
 	case errors.Is(err, links.ErrLinkExists):
 		status = http.StatusConflict
 		code = "conflict"
-	case errors.Is(err, links.ErrLinkNotFound):
+	case errors.Is(err, links.ErrLinkNotFound), errors.Is(err, links.ErrClickNotFound):
 		status = http.StatusNotFound
 		code = "not_found"
 	}
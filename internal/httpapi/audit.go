@@ -0,0 +1,139 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/mine/shorturl/internal/audit"
+)
+
+type auditTrail struct {
+	service *audit.Service
+	logger  *slog.Logger
+}
+
+// record never fails the request: the admin action has already happened, so a
+// broken audit write is logged loudly instead.
+func (a auditTrail) record(c *gin.Context, actor string, action string, targetType string, targetID string, before any, after any) {
+	beforeJSON, afterJSON, err := audit.Diff(before, after)
+	if err != nil {
+		a.logger.Error("diff audit snapshot failed", "error", err, "action", action)
+	}
+
+	if err := a.service.Record(c.Request.Context(), audit.Entry{
+		Actor:      actor,
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		Before:     beforeJSON,
+		After:      afterJSON,
+		IP:         c.ClientIP(),
+		UserAgent:  c.Request.UserAgent(),
+	}); err != nil {
+		a.logger.Error("write audit log failed", "error", err, "action", action, "actor", actor)
+	}
+}
+
+func listAuditLogsHandler(auditLog *audit.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		filter, ok := parseAuditFilter(c)
+		if !ok {
+			writeJSONError(c, http.StatusBadRequest, "invalid_request")
+			return
+		}
+
+		page, err := auditLog.List(c.Request.Context(), filter)
+		if err != nil {
+			writeJSONError(c, http.StatusInternalServerError, "internal_error")
+			return
+		}
+
+		c.JSON(http.StatusOK, apiResponse{
+			Success: true,
+			Data:    page,
+		})
+	}
+}
+
+// exportAuditLogsHandler streams JSON Lines so large exports never sit in memory.
+func exportAuditLogsHandler(logger *slog.Logger, auditLog *audit.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		filter, ok := parseAuditFilter(c)
+		if !ok {
+			writeJSONError(c, http.StatusBadRequest, "invalid_request")
+			return
+		}
+
+		c.Header("Content-Type", "application/x-ndjson; charset=utf-8")
+		c.Header("Content-Disposition", `attachment; filename="audit-log.jsonl"`)
+		c.Status(http.StatusOK)
+
+		encoder := json.NewEncoder(c.Writer)
+		written := 0
+		err := auditLog.Export(c.Request.Context(), filter, func(entry audit.Entry) error {
+			if err := encoder.Encode(entry); err != nil {
+				return err
+			}
+			if written++; written%500 == 0 {
+				c.Writer.Flush()
+			}
+			return nil
+		})
+		if err != nil {
+			logger.Error("export audit log failed", "error", err, "written", written)
+		}
+	}
+}
+
+func parseAuditFilter(c *gin.Context) (audit.Filter, bool) {
+	filter := audit.Filter{
+		Actor:      strings.TrimSpace(c.Query("actor")),
+		Action:     strings.TrimSpace(c.Query("action")),
+		TargetType: strings.TrimSpace(c.Query("target_type")),
+		TargetID:   strings.TrimSpace(c.Query("target_id")),
+	}
+
+	for _, param := range []struct {
+		name   string
+		target *time.Time
+	}{
+		{"from", &filter.From},
+		{"to", &filter.To},
+	} {
+		raw := strings.TrimSpace(c.Query(param.name))
+		if raw == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return audit.Filter{}, false
+		}
+		*param.target = parsed
+	}
+
+	for _, param := range []struct {
+		name   string
+		target *int
+	}{
+		{"page", &filter.Page},
+		{"page_size", &filter.PageSize},
+	} {
+		raw := strings.TrimSpace(c.Query(param.name))
+		if raw == "" {
+			continue
+		}
+		parsed, err := strconv.Atoi(raw)
+		if err != nil {
+			return audit.Filter{}, false
+		}
+		*param.target = parsed
+	}
+
+	return filter, true
+}
@@ -0,0 +1,74 @@
+package httpapi
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/mine/shorturl/internal/links"
+)
+
+// transparentGIF is a 1x1 transparent GIF.
+var transparentGIF = []byte{
+	0x47, 0x49, 0x46, 0x38, 0x39, 0x61, 0x01, 0x00, 0x01, 0x00, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0xff, 0xff, 0xff, 0x21, 0xf9, 0x04, 0x01, 0x00, 0x00, 0x00, 0x00, 0x2c, 0x00, 0x00, 0x00, 0x00,
+	0x01, 0x00, 0x01, 0x00, 0x00, 0x02, 0x02, 0x44, 0x01, 0x00, 0x3b,
+}
+
+// conversionPostbackHandler takes server-to-server postbacks as JSON or form
+// data, authenticated with the configured bearer token.
+func conversionPostbackHandler(logger *slog.Logger, linkService *links.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token, _ := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !linkService.PostbackAllowed(strings.TrimSpace(token)) {
+			writeJSONError(c, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+
+		var input links.ConversionInput
+		if err := c.ShouldBind(&input); err != nil {
+			writeJSONError(c, http.StatusBadRequest, "invalid_request")
+			return
+		}
+
+		recorded, err := linkService.RecordConversion(c.Request.Context(), input)
+		if err != nil {
+			if !errors.Is(err, links.ErrValidation) && !errors.Is(err, links.ErrClickNotFound) {
+				logger.Error("record conversion failed", "error", err)
+			}
+			writeLinkError(c, err)
+			return
+		}
+
+		status := http.StatusOK
+		if recorded {
+			status = http.StatusCreated
+		}
+		c.JSON(status, apiResponse{
+			Success: true,
+			Data:    gin.H{"recorded": recorded},
+		})
+	}
+}
+
+// conversionPixelHandler is the browser variant for landing pages that cannot
+// make server calls. It always answers with the pixel so broken or repeated
+// loads never show up on the page; the token is not required because it
+// would be visible in the page source anyway.
+func conversionPixelHandler(logger *slog.Logger, linkService *links.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var input links.ConversionInput
+		if err := c.ShouldBindQuery(&input); err == nil {
+			_, err = linkService.RecordConversion(c.Request.Context(), input)
+			if err != nil && !errors.Is(err, links.ErrValidation) && !errors.Is(err, links.ErrClickNotFound) {
+				logger.Error("record conversion failed", "error", err)
+			}
+		}
+
+		c.Header("Cache-Control", "no-store")
+		c.Data(http.StatusOK, "image/gif", transparentGIF)
+	}
+}
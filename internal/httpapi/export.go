@@ -0,0 +1,168 @@
+package httpapi
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/mine/shorturl/internal/auth"
+	"github.com/mine/shorturl/internal/links"
+)
+
+const exportFlushEvery = 500
+
+var visitExportColumns = []string{
+	"visited_at", "link_id", "code", "ip", "visitor_id", "traffic_type",
+	"referer", "referer_host", "user_agent",
+	"client_name", "client_version", "client_type", "device_type", "device_brand", "device_model", "os", "os_version", "language",
+	"utm_source", "utm_medium", "utm_campaign", "utm_term", "utm_content", "extra_params",
+	"country_code", "region", "city", "asn", "as_org",
+}
+
+// exportVisitsHandler streams raw visits as CSV (default) or NDJSON. Only
+// admins get unmasked IPs.
+func exportVisitsHandler(logger *slog.Logger, linkService *links.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		query, ok := analyticsQuery(c)
+		if !ok {
+			writeJSONError(c, http.StatusBadRequest, "invalid_request")
+			return
+		}
+		filter := links.VisitExportFilter{
+			Tag:   c.Query("tag"),
+			RawIP: currentUser(c).Role.Allows(auth.RoleAdmin),
+		}
+		if rawID := strings.TrimSpace(c.Query("link_id")); rawID != "" {
+			id, err := strconv.ParseInt(rawID, 10, 64)
+			if err != nil {
+				writeJSONError(c, http.StatusBadRequest, "invalid_request")
+				return
+			}
+			filter.LinkID = id
+		}
+
+		format := strings.ToLower(strings.TrimSpace(c.DefaultQuery("format", "csv")))
+		var (
+			contentType string
+			filename    string
+			writeHeader func() error
+			writeRow    func(links.ExportedVisit) error
+			flush       func()
+		)
+		switch format {
+		case "csv":
+			writer := csv.NewWriter(c.Writer)
+			contentType, filename = "text/csv; charset=utf-8", "visits.csv"
+			writeHeader = func() error {
+				return writer.Write(visitExportColumns)
+			}
+			writeRow = func(visit links.ExportedVisit) error {
+				return writer.Write(visitCSVRecord(visit))
+			}
+			flush = func() {
+				writer.Flush()
+				c.Writer.Flush()
+			}
+		case "ndjson":
+			encoder := json.NewEncoder(c.Writer)
+			contentType, filename = "application/x-ndjson; charset=utf-8", "visits.jsonl"
+			writeHeader = func() error { return nil }
+			writeRow = func(visit links.ExportedVisit) error {
+				return encoder.Encode(visit)
+			}
+			flush = c.Writer.Flush
+		default:
+			writeJSONError(c, http.StatusBadRequest, "invalid_request")
+			return
+		}
+
+		// Headers go out with the first row so validation errors can still be
+		// reported as JSON.
+		started := false
+		start := func() error {
+			started = true
+			c.Header("Content-Type", contentType)
+			c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+			c.Status(http.StatusOK)
+			return writeHeader()
+		}
+
+		written := 0
+		err := linkService.ExportVisits(c.Request.Context(), filter, query, func(visit links.ExportedVisit) error {
+			if !started {
+				if err := start(); err != nil {
+					return err
+				}
+			}
+			if err := writeRow(visit); err != nil {
+				return err
+			}
+			if written++; written%exportFlushEvery == 0 {
+				flush()
+			}
+			return nil
+		})
+		if err != nil && !started {
+			writeLinkError(c, err)
+			return
+		}
+		if err != nil {
+			logger.Error("export visits failed", "error", err, "written", written)
+		}
+		if !started {
+			_ = start()
+		}
+		flush()
+	}
+}
+
+// visitCSVRecord renders one visit in visitExportColumns order.
+func visitCSVRecord(visit links.ExportedVisit) []string {
+	extra := ""
+	if len(visit.ExtraParams) > 0 {
+		encoded, _ := json.Marshal(visit.ExtraParams)
+		extra = string(encoded)
+	}
+	asn := ""
+	if visit.ASN != 0 {
+		asn = strconv.FormatUint(uint64(visit.ASN), 10)
+	}
+
+	return []string{
+		visit.VisitedAt.UTC().Format(time.RFC3339Nano),
+		strconv.FormatInt(visit.LinkID, 10),
+		visit.Code,
+		visit.IP,
+		visit.VisitorID,
+		visit.TrafficType,
+		visit.Referer,
+		visit.RefererHost,
+		visit.UserAgent,
+		visit.ClientName,
+		visit.ClientVersion,
+		visit.ClientType,
+		visit.DeviceType,
+		visit.DeviceBrand,
+		visit.DeviceModel,
+		visit.OS,
+		visit.OSVersion,
+		visit.Language,
+		visit.UTMSource,
+		visit.UTMMedium,
+		visit.UTMCampaign,
+		visit.UTMTerm,
+		visit.UTMContent,
+		extra,
+		visit.CountryCode,
+		visit.Region,
+		visit.City,
+		asn,
+		visit.ASOrg,
+	}
+}
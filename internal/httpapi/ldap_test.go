@@ -0,0 +1,268 @@
+package httpapi
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+
+	"github.com/mine/shorturl/internal/auth"
+)
+
+func TestLDAPLoginProvisionsUserWithMappedRole(t *testing.T) {
+	directory := newFakeLDAPServer(t)
+	router := newTestRouterWithAuth(t, nil, directory.provider(t))
+
+	recorder := performJSONRequest(router, http.MethodPost, "/admin/api/v1/auth/login", `{"username":"carol","password":"directory-pass"}`, "")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", recorder.Code, recorder.Body.String())
+	}
+	body := recorder.Body.String()
+	if !strings.Contains(body, `"role":"admin"`) || !strings.Contains(body, `"source":"ldap"`) {
+		t.Fatalf("expected ldap admin session, got %s", body)
+	}
+
+	badRecorder := performJSONRequest(router, http.MethodPost, "/admin/api/v1/auth/login", `{"username":"carol","password":"wrong"}`, "")
+	if badRecorder.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d body=%s", badRecorder.Code, badRecorder.Body.String())
+	}
+
+	// The local admin still logs in ahead of the directory.
+	login(t, router)
+}
+
+func TestLDAPLoginRejectsUnmappedGroups(t *testing.T) {
+	directory := newFakeLDAPServer(t)
+	router := newTestRouterWithAuth(t, nil, directory.provider(t))
+
+	recorder := performJSONRequest(router, http.MethodPost, "/admin/api/v1/auth/login", `{"username":"dave","password":"directory-pass"}`, "")
+	if recorder.Code != http.StatusForbidden {
+		t.Fatalf("expected 403, got %d body=%s", recorder.Code, recorder.Body.String())
+	}
+}
+
+func TestHtpasswdLogin(t *testing.T) {
+	// "{SHA}" of "static-pass".
+	path := filepath.Join(t.TempDir(), ".htpasswd")
+	if err := os.WriteFile(path, []byte("# static staff\nerin:{SHA}0r3BL7/5XD8exnlS8mpnUe1z2T4=\n"), 0o600); err != nil {
+		t.Fatalf("write htpasswd: %v", err)
+	}
+	provider, err := auth.NewHtpasswdProvider(path, auth.RoleViewer)
+	if err != nil {
+		t.Fatalf("new htpasswd provider: %v", err)
+	}
+	router := newTestRouterWithAuth(t, nil, provider)
+
+	recorder := performJSONRequest(router, http.MethodPost, "/admin/api/v1/auth/login", `{"username":"erin","password":"static-pass"}`, "")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", recorder.Code, recorder.Body.String())
+	}
+	if !strings.Contains(recorder.Body.String(), `"role":"viewer"`) {
+		t.Fatalf("expected viewer session, got %s", recorder.Body.String())
+	}
+
+	// An htpasswd entry must not take over the local admin account.
+	if err := os.WriteFile(path, []byte("admin:{SHA}0r3BL7/5XD8exnlS8mpnUe1z2T4=\n"), 0o600); err != nil {
+		t.Fatalf("rewrite htpasswd: %v", err)
+	}
+	future := time.Now().Add(time.Minute)
+	_ = os.Chtimes(path, future, future)
+	takeover := performJSONRequest(router, http.MethodPost, "/admin/api/v1/auth/login", `{"username":"admin","password":"static-pass"}`, "")
+	if takeover.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for source conflict, got %d body=%s", takeover.Code, takeover.Body.String())
+	}
+}
+
+type fakeLDAPEntry struct {
+	dn       string
+	password string
+	attrs    map[string][]string
+}
+
+// fakeLDAPServer speaks just enough LDAPv3 over TCP for a simple bind followed
+// by a subtree search, so the provider runs against the real client library.
+type fakeLDAPServer struct {
+	listener net.Listener
+	entries  map[string]fakeLDAPEntry
+}
+
+const (
+	fakeLDAPServiceDN       = "cn=shorturl,ou=services,dc=example,dc=com"
+	fakeLDAPServicePassword = "service-pass"
+)
+
+func newFakeLDAPServer(t *testing.T) *fakeLDAPServer {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	server := &fakeLDAPServer{
+		listener: listener,
+		entries: map[string]fakeLDAPEntry{
+			"carol": {
+				dn:       "uid=carol,ou=people,dc=example,dc=com",
+				password: "directory-pass",
+				attrs: map[string][]string{
+					"mail":     {"carol@example.com"},
+					"memberOf": {"cn=Shorturl-Admins,ou=groups,dc=example,dc=com"},
+				},
+			},
+			"dave": {
+				dn:       "uid=dave,ou=people,dc=example,dc=com",
+				password: "directory-pass",
+				attrs: map[string][]string{
+					"memberOf": {"cn=finance,ou=groups,dc=example,dc=com"},
+				},
+			},
+		},
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+
+	go server.serve()
+	return server
+}
+
+func (s *fakeLDAPServer) provider(t *testing.T) *auth.LDAPProvider {
+	t.Helper()
+
+	provider, err := auth.NewLDAPProvider(auth.LDAPConfig{
+		URL:          "ldap://" + s.listener.Addr().String(),
+		BindDN:       fakeLDAPServiceDN,
+		BindPassword: fakeLDAPServicePassword,
+		BaseDN:       "dc=example,dc=com",
+		RoleMapping:  map[string]auth.Role{"shorturl-admins": auth.RoleAdmin},
+		Timeout:      2 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("new ldap provider: %v", err)
+	}
+	return provider
+}
+
+func (s *fakeLDAPServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *fakeLDAPServer) handle(conn net.Conn) {
+	defer conn.Close()
+
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+		if len(packet.Children) < 2 {
+			return
+		}
+		messageID := packet.Children[0].Value.(int64)
+		request := packet.Children[1]
+
+		switch request.Tag {
+		case ldap.ApplicationBindRequest:
+			dn := request.Children[1].Value.(string)
+			password := request.Children[2].Data.String()
+			code := uint16(ldap.LDAPResultInvalidCredentials)
+			if s.checkBind(dn, password) {
+				code = ldap.LDAPResultSuccess
+			}
+			writeLDAPResult(conn, messageID, ldap.ApplicationBindResponse, code)
+		case ldap.ApplicationSearchRequest:
+			filter, _ := ldap.DecompileFilter(request.Children[6])
+			for username, entry := range s.entries {
+				if filter != "(uid="+username+")" {
+					continue
+				}
+				result := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Search Result Entry")
+				result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, entry.dn, "Object Name"))
+				attributes := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attributes")
+				for name, values := range entry.attrs {
+					attribute := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attribute")
+					attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "Type"))
+					set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Values")
+					for _, value := range values {
+						set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, "Value"))
+					}
+					attribute.AppendChild(set)
+					attributes.AppendChild(attribute)
+				}
+				result.AppendChild(attributes)
+				writeLDAPMessage(conn, messageID, result)
+			}
+			writeLDAPResult(conn, messageID, ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess)
+		case ldap.ApplicationUnbindRequest:
+			return
+		}
+	}
+}
+
+func (s *fakeLDAPServer) checkBind(dn string, password string) bool {
+	if dn == fakeLDAPServiceDN {
+		return password == fakeLDAPServicePassword
+	}
+	for _, entry := range s.entries {
+		if entry.dn == dn {
+			return password != "" && password == entry.password
+		}
+	}
+	return false
+}
+
+func writeLDAPResult(conn net.Conn, messageID int64, tag ber.Tag, code uint16) {
+	result := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Result")
+	result.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), "Result Code"))
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Matched DN"))
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Diagnostic Message"))
+	writeLDAPMessage(conn, messageID, result)
+}
+
+func writeLDAPMessage(conn net.Conn, messageID int64, op *ber.Packet) {
+	envelope := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Message")
+	envelope.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, "Message ID"))
+	envelope.AppendChild(op)
+	_, _ = conn.Write(envelope.Bytes())
+}
+
+func TestLoginLocksOutWhileProviderIsDown(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	addr := listener.Addr().String()
+	_ = listener.Close()
+
+	provider, err := auth.NewLDAPProvider(auth.LDAPConfig{
+		URL:     "ldap://" + addr,
+		BaseDN:  "dc=example,dc=com",
+		Timeout: time.Second,
+	})
+	if err != nil {
+		t.Fatalf("new ldap provider: %v", err)
+	}
+	router := newTestRouterWithAuth(t, nil, provider)
+
+	for attempt := range 3 {
+		recorder := performJSONRequest(router, http.MethodPost, "/admin/api/v1/auth/login", `{"username":"admin","password":"wrong"}`, "")
+		if recorder.Code != http.StatusInternalServerError {
+			t.Fatalf("attempt %d: expected 500, got %d body=%s", attempt+1, recorder.Code, recorder.Body.String())
+		}
+	}
+
+	lockedRecorder := performJSONRequest(router, http.MethodPost, "/admin/api/v1/auth/login", `{"username":"admin","password":"change-me"}`, "")
+	if lockedRecorder.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %d body=%s", lockedRecorder.Code, lockedRecorder.Body.String())
+	}
+}
@@ -0,0 +1,71 @@
+package httpapi
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/mine/shorturl/internal/audit"
+	"github.com/mine/shorturl/internal/auth"
+)
+
+type unlockRequest struct {
+	Scope string `json:"scope"`
+	Key   string `json:"key"`
+}
+
+func listLockoutsHandler(throttle *auth.Throttle) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		result, err := throttle.ListLocked(c.Request.Context())
+		if err != nil {
+			writeJSONError(c, http.StatusInternalServerError, "internal_error")
+			return
+		}
+
+		c.JSON(http.StatusOK, apiResponse{
+			Success: true,
+			Data:    result,
+		})
+	}
+}
+
+func unlockHandler(throttle *auth.Throttle, trail auditTrail) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request unlockRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			writeJSONError(c, http.StatusBadRequest, "invalid_request")
+			return
+		}
+		if request.Scope != auth.ScopeUsername && request.Scope != auth.ScopeIP || strings.TrimSpace(request.Key) == "" {
+			writeJSONError(c, http.StatusBadRequest, "invalid_request")
+			return
+		}
+
+		if err := throttle.Unlock(c.Request.Context(), request.Scope, request.Key); err != nil {
+			writeJSONError(c, http.StatusInternalServerError, "internal_error")
+			return
+		}
+		trail.record(c, currentUser(c).Username, audit.ActionLockoutCleared, audit.TargetLockout, request.Scope+":"+strings.TrimSpace(request.Key), nil, nil)
+
+		c.JSON(http.StatusOK, apiResponse{
+			Success: true,
+			Data:    gin.H{"ok": true},
+		})
+	}
+}
+
+func writeThrottleError(c *gin.Context, err error) {
+	var locked *auth.LockedError
+	if !errors.As(err, &locked) {
+		writeJSONError(c, http.StatusInternalServerError, "internal_error")
+		return
+	}
+
+	seconds := int(math.Ceil(locked.RetryAfter.Seconds()))
+	c.Header("Retry-After", strconv.Itoa(max(seconds, 1)))
+	writeJSONError(c, http.StatusTooManyRequests, "too_many_attempts")
+}
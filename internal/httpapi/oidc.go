@@ -0,0 +1,122 @@
+package httpapi
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+
+	"github.com/mine/shorturl/internal/audit"
+	"github.com/mine/shorturl/internal/auth"
+	"github.com/mine/shorturl/internal/shortcode"
+)
+
+const (
+	sessionOIDCStateKey    = "oidc_state"
+	sessionOIDCNonceKey    = "oidc_nonce"
+	sessionOIDCVerifierKey = "oidc_verifier"
+
+	adminHomePath  = "/admin"
+	adminLoginPath = "/admin/login"
+)
+
+func authProvidersHandler(authChain *auth.Chain, oidcLogin *auth.OIDC) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, apiResponse{
+			Success: true,
+			Data: gin.H{
+				"password":           true,
+				"password_providers": authChain.Names(),
+				"oidc":               oidcLogin != nil,
+			},
+		})
+	}
+}
+
+func oidcLoginHandler(oidcLogin *auth.OIDC) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		state := shortcode.MustRandomString(32)
+		nonce := shortcode.MustRandomString(32)
+		verifier := auth.GenerateVerifier()
+
+		session := sessions.Default(c)
+		session.Set(sessionOIDCStateKey, state)
+		session.Set(sessionOIDCNonceKey, nonce)
+		session.Set(sessionOIDCVerifierKey, verifier)
+		if err := session.Save(); err != nil {
+			writeJSONError(c, http.StatusInternalServerError, "internal_error")
+			return
+		}
+
+		c.Redirect(http.StatusFound, oidcLogin.AuthCodeURL(state, nonce, verifier))
+	}
+}
+
+// oidcCallbackHandler is reached through a browser redirect, so failures are
+// reported back to the admin login page instead of as JSON.
+func oidcCallbackHandler(logger *slog.Logger, oidcLogin *auth.OIDC, users userStore, trail auditTrail) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		session := sessions.Default(c)
+		state, _ := session.Get(sessionOIDCStateKey).(string)
+		nonce, _ := session.Get(sessionOIDCNonceKey).(string)
+		verifier, _ := session.Get(sessionOIDCVerifierKey).(string)
+		session.Delete(sessionOIDCStateKey)
+		session.Delete(sessionOIDCNonceKey)
+		session.Delete(sessionOIDCVerifierKey)
+
+		if c.Query("error") != "" {
+			redirectLoginError(c, session, "sso_denied")
+			return
+		}
+		if state == "" || c.Query("state") != state || c.Query("code") == "" {
+			redirectLoginError(c, session, "invalid_request")
+			return
+		}
+
+		identity, err := oidcLogin.Exchange(c.Request.Context(), c.Query("code"), verifier, nonce)
+		if err != nil {
+			if errors.Is(err, auth.ErrAccessDenied) {
+				trail.record(c, "", audit.ActionLoginFailed, audit.TargetUser, "", nil, nil)
+				redirectLoginError(c, session, "forbidden")
+				return
+			}
+			logger.Warn("oidc exchange failed", "error", err)
+			redirectLoginError(c, session, "unauthorized")
+			return
+		}
+
+		user, err := users.ProvisionExternalUser(c.Request.Context(), identity)
+		if err != nil {
+			switch {
+			case errors.Is(err, auth.ErrUserConflict):
+				redirectLoginError(c, session, "conflict")
+				return
+			case errors.Is(err, auth.ErrAccessDenied):
+				trail.record(c, identity.Username, audit.ActionLoginFailed, audit.TargetUser, "", nil, nil)
+				redirectLoginError(c, session, "forbidden")
+				return
+			}
+			logger.Error("provision oidc user failed", "error", err, "username", identity.Username)
+			redirectLoginError(c, session, "internal_error")
+			return
+		}
+
+		session.Set(SessionUserKey, user.Username)
+		if err := session.Save(); err != nil {
+			writeJSONError(c, http.StatusInternalServerError, "internal_error")
+			return
+		}
+		trail.record(c, user.Username, audit.ActionSSOLogin, audit.TargetUser, strconv.FormatInt(user.ID, 10), nil, nil)
+
+		c.Redirect(http.StatusFound, adminHomePath)
+	}
+}
+
+func redirectLoginError(c *gin.Context, session sessions.Session, code string) {
+	_ = session.Save()
+	c.Redirect(http.StatusFound, adminLoginPath+"?"+url.Values{"sso_error": {code}}.Encode())
+}
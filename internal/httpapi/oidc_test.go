@@ -0,0 +1,272 @@
+package httpapi
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/mine/shorturl/internal/auth"
+)
+
+func TestOIDCLoginProvisionsUserWithMappedRole(t *testing.T) {
+	provider := newMockOIDCProvider(t, map[string]any{
+		"email":          "alice@example.com",
+		"email_verified": true,
+		"groups":         []string{"marketing"},
+	})
+	router := newTestRouterWithOIDC(t, provider.client(t, []string{"example.com"}))
+
+	sessionCookie, callbackURL := startOIDCLogin(t, router, provider)
+	callbackRecorder := performJSONRequest(router, http.MethodGet, callbackURL, "", sessionCookie)
+	if callbackRecorder.Code != http.StatusFound || callbackRecorder.Header().Get("Location") != adminHomePath {
+		t.Fatalf("expected redirect to admin home, got %d location=%q", callbackRecorder.Code, callbackRecorder.Header().Get("Location"))
+	}
+	if !provider.pkceVerified {
+		t.Fatalf("expected token request to carry a matching PKCE verifier")
+	}
+
+	loggedInCookie := callbackRecorder.Header().Get("Set-Cookie")
+	if recorder := performJSONRequest(router, http.MethodGet, "/admin/api/v1/auth/session", "", sessionCookie); recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("expected the pre-login session cookie to stay signed out, got %d", recorder.Code)
+	}
+	sessionRecorder := performJSONRequest(router, http.MethodGet, "/admin/api/v1/auth/session", "", loggedInCookie)
+	if sessionRecorder.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", sessionRecorder.Code, sessionRecorder.Body.String())
+	}
+	body := sessionRecorder.Body.String()
+	if !strings.Contains(body, `"username":"alice@example.com"`) || !strings.Contains(body, `"role":"editor"`) {
+		t.Fatalf("expected provisioned editor session, got %s", body)
+	}
+
+	lockoutsRecorder := performJSONRequest(router, http.MethodGet, "/admin/api/v1/auth/lockouts", "", loggedInCookie)
+	if lockoutsRecorder.Code != http.StatusForbidden {
+		t.Fatalf("expected editor to be forbidden from admin routes, got %d", lockoutsRecorder.Code)
+	}
+}
+
+func TestOIDCLoginRejectsDisallowedDomain(t *testing.T) {
+	provider := newMockOIDCProvider(t, map[string]any{
+		"email":          "mallory@evil.test",
+		"email_verified": true,
+		"groups":         []string{"marketing"},
+	})
+	router := newTestRouterWithOIDC(t, provider.client(t, []string{"example.com"}))
+
+	sessionCookie, callbackURL := startOIDCLogin(t, router, provider)
+	callbackRecorder := performJSONRequest(router, http.MethodGet, callbackURL, "", sessionCookie)
+	if location := callbackRecorder.Header().Get("Location"); location != adminLoginPath+"?sso_error=forbidden" {
+		t.Fatalf("expected forbidden redirect, got %d location=%q", callbackRecorder.Code, location)
+	}
+}
+
+func TestOIDCLoginRejectsDisabledUser(t *testing.T) {
+	provider := newMockOIDCProvider(t, map[string]any{
+		"email":          "alice@example.com",
+		"email_verified": true,
+		"groups":         []string{"marketing"},
+	})
+	router := newTestRouterWithOIDC(t, provider.client(t, []string{"example.com"}))
+
+	sessionCookie, callbackURL := startOIDCLogin(t, router, provider)
+	if recorder := performJSONRequest(router, http.MethodGet, callbackURL, "", sessionCookie); recorder.Header().Get("Location") != adminHomePath {
+		t.Fatalf("expected first login to succeed, got %d location=%q", recorder.Code, recorder.Header().Get("Location"))
+	}
+	disabled := performJSONRequest(router, http.MethodPut, "/admin/api/v1/users/2", `{"role":"editor","disabled":true}`, login(t, router))
+	if disabled.Code != http.StatusOK {
+		t.Fatalf("expected user to be disabled, got %d body=%s", disabled.Code, disabled.Body.String())
+	}
+
+	sessionCookie, callbackURL = startOIDCLogin(t, router, provider)
+	callbackRecorder := performJSONRequest(router, http.MethodGet, callbackURL, "", sessionCookie)
+	if location := callbackRecorder.Header().Get("Location"); location != adminLoginPath+"?sso_error=forbidden" {
+		t.Fatalf("expected forbidden redirect, got %d location=%q", callbackRecorder.Code, location)
+	}
+}
+
+func TestOIDCCallbackRejectsStateMismatch(t *testing.T) {
+	provider := newMockOIDCProvider(t, map[string]any{"email": "alice@example.com"})
+	router := newTestRouterWithOIDC(t, provider.client(t, nil))
+
+	sessionCookie, _ := startOIDCLogin(t, router, provider)
+	callbackRecorder := performJSONRequest(router, http.MethodGet, "/admin/api/v1/auth/oidc/callback?code=whatever&state=forged", "", sessionCookie)
+	if location := callbackRecorder.Header().Get("Location"); location != adminLoginPath+"?sso_error=invalid_request" {
+		t.Fatalf("expected invalid_request redirect, got %d location=%q", callbackRecorder.Code, location)
+	}
+}
+
+func startOIDCLogin(t *testing.T, router *gin.Engine, provider *mockOIDCProvider) (string, string) {
+	t.Helper()
+
+	loginRecorder := performJSONRequest(router, http.MethodGet, "/admin/api/v1/auth/oidc/login", "", "")
+	if loginRecorder.Code != http.StatusFound {
+		t.Fatalf("expected redirect to provider, got %d", loginRecorder.Code)
+	}
+
+	authorizeURL, err := url.Parse(loginRecorder.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("parse authorize url: %v", err)
+	}
+	query := authorizeURL.Query()
+	if query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		t.Fatalf("expected PKCE challenge in authorize url, got %s", authorizeURL)
+	}
+
+	code := provider.authorize(query.Get("nonce"), query.Get("code_challenge"))
+	callbackURL := "/admin/api/v1/auth/oidc/callback?" + url.Values{
+		"code":  {code},
+		"state": {query.Get("state")},
+	}.Encode()
+	return loginRecorder.Header().Get("Set-Cookie"), callbackURL
+}
+
+type mockOIDCProvider struct {
+	server       *httptest.Server
+	key          *rsa.PrivateKey
+	claims       map[string]any
+	mu           sync.Mutex
+	pending      map[string]mockAuthorization
+	pkceVerified bool
+}
+
+type mockAuthorization struct {
+	nonce     string
+	challenge string
+}
+
+func newMockOIDCProvider(t *testing.T, claims map[string]any) *mockOIDCProvider {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+
+	provider := &mockOIDCProvider{key: key, claims: claims, pending: make(map[string]mockAuthorization)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, map[string]any{
+			"issuer":                                provider.server.URL,
+			"authorization_endpoint":                provider.server.URL + "/authorize",
+			"token_endpoint":                        provider.server.URL + "/token",
+			"jwks_uri":                              provider.server.URL + "/keys",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, map[string]any{"keys": []map[string]any{{
+			"kty": "RSA",
+			"alg": "RS256",
+			"use": "sig",
+			"kid": "test",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", provider.handleToken)
+	provider.server = httptest.NewServer(mux)
+	t.Cleanup(provider.server.Close)
+
+	return provider
+}
+
+func (p *mockOIDCProvider) client(t *testing.T, allowedDomains []string) *auth.OIDC {
+	t.Helper()
+
+	oidcLogin, err := auth.NewOIDC(context.Background(), auth.OIDCConfig{
+		IssuerURL:      p.server.URL,
+		ClientID:       "shorturl",
+		ClientSecret:   "secret",
+		RedirectURL:    "http://shorturl.test/admin/api/v1/auth/oidc/callback",
+		AllowedDomains: allowedDomains,
+		RoleMapping:    map[string]auth.Role{"marketing": auth.RoleEditor},
+	})
+	if err != nil {
+		t.Fatalf("new oidc: %v", err)
+	}
+	return oidcLogin
+}
+
+func (p *mockOIDCProvider) authorize(nonce string, challenge string) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	code := "code-" + nonce
+	p.pending[code] = mockAuthorization{nonce: nonce, challenge: challenge}
+	return code
+}
+
+func (p *mockOIDCProvider) handleToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	p.mu.Lock()
+	authorization, ok := p.pending[r.PostForm.Get("code")]
+	delete(p.pending, r.PostForm.Get("code"))
+	p.mu.Unlock()
+	if !ok {
+		http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+		return
+	}
+
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(sum[:]) != authorization.challenge {
+		http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+		return
+	}
+	p.mu.Lock()
+	p.pkceVerified = true
+	p.mu.Unlock()
+
+	claims := map[string]any{
+		"iss":   p.server.URL,
+		"sub":   "user-1",
+		"aud":   "shorturl",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"iat":   time.Now().Unix(),
+		"nonce": authorization.nonce,
+	}
+	for key, value := range p.claims {
+		claims[key] = value
+	}
+
+	writeTestJSON(w, map[string]any{
+		"access_token": "access",
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     p.sign(claims),
+	})
+}
+
+func (p *mockOIDCProvider) sign(claims map[string]any) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, digest[:])
+	if err != nil {
+		panic(err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func writeTestJSON(w http.ResponseWriter, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(value)
+}
@@ -0,0 +1,44 @@
+package httpapi
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/mine/shorturl/internal/audit"
+	"github.com/mine/shorturl/internal/links"
+)
+
+// eraseVisitsHandler serves data subject erasure requests. The audit entry
+// records which identifiers were used, never their values, since audit logs
+// cannot be deleted.
+func eraseVisitsHandler(linkService *links.Service, trail auditTrail) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request links.VisitErasure
+		if err := c.ShouldBindJSON(&request); err != nil {
+			writeJSONError(c, http.StatusBadRequest, "invalid_request")
+			return
+		}
+
+		deleted, err := linkService.EraseVisits(c.Request.Context(), request)
+		if err != nil {
+			writeLinkError(c, err)
+			return
+		}
+
+		matchedBy := make([]string, 0, 2)
+		if strings.TrimSpace(request.IP) != "" {
+			matchedBy = append(matchedBy, "ip")
+		}
+		if strings.TrimSpace(request.VisitorID) != "" {
+			matchedBy = append(matchedBy, "visitor_id")
+		}
+		trail.record(c, currentUser(c).Username, audit.ActionVisitsErased, audit.TargetVisits, "", nil, gin.H{"matched_by": matchedBy, "deleted": deleted})
+
+		c.JSON(http.StatusOK, apiResponse{
+			Success: true,
+			Data:    gin.H{"deleted": deleted},
+		})
+	}
+}
@@ -12,14 +12,42 @@ import (
 
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
 
+	"github.com/mine/shorturl/internal/audit"
+	"github.com/mine/shorturl/internal/auth"
 	"github.com/mine/shorturl/internal/links"
+	"github.com/mine/shorturl/internal/live"
+	"github.com/mine/shorturl/internal/metrics"
+	"github.com/mine/shorturl/internal/webhooks"
 )
 
-const sessionUserKey = "uid"
+// SessionUserKey is the session value holding the signed-in username; session
+// stores use it to index sessions by user.
+const SessionUserKey = "uid"
 
-type authChecker interface {
-	CheckPassword(ctx context.Context, username string, password string) (bool, error)
+const contextUserKey = "current_user"
+
+const tracerName = "github.com/mine/shorturl/internal/httpapi"
+
+type userStore interface {
+	GetUser(ctx context.Context, username string) (auth.User, error)
+	GetUserByID(ctx context.Context, id int64) (auth.User, error)
+	ListUsers(ctx context.Context) ([]auth.User, error)
+	UpdateUser(ctx context.Context, id int64, role auth.Role, disabled bool) (auth.User, error)
+	ChangePassword(ctx context.Context, username string, currentPassword string, newPassword string) error
+	ProvisionExternalUser(ctx context.Context, identity auth.ExternalIdentity) (auth.User, error)
+}
+
+type sessionBackend interface {
+	sessions.Store
+	ListUserSessions(ctx context.Context, username string, currentToken string) ([]auth.Session, error)
+	RevokeUserSession(ctx context.Context, username string, id int64) error
+	RevokeUserSessions(ctx context.Context, username string, exceptToken string) (int64, error)
 }
 
 type apiResponse struct {
@@ -30,47 +58,112 @@ type apiResponse struct {
 
 func NewRouter(
 	logger *slog.Logger,
-	sessionStore sessions.Store,
+	sessionStore sessionBackend,
 	adminStaticDir string,
 	linkService *links.Service,
-	auth authChecker,
+	users userStore,
+	authChain *auth.Chain,
+	throttle *auth.Throttle,
+	oidcLogin *auth.OIDC,
+	auditLog *audit.Service,
+	visitStream *live.Hub,
+	hooks *webhooks.Service,
+	serverMetrics *metrics.Metrics,
 ) *gin.Engine {
 	router := gin.New()
 	router.Use(gin.Recovery())
+	router.Use(requestTracing())
 	router.Use(requestLogger(logger))
+	router.Use(requestMetrics(serverMetrics))
+	router.Use(clientIPContext())
 	router.Use(sessions.Sessions("shorturl_session", sessionStore))
 
 	router.GET("/healthz", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{"status": "ok"})
 	})
+	router.POST("/conversions", conversionPostbackHandler(logger, linkService))
+	router.GET("/conversions/pixel.gif", conversionPixelHandler(logger, linkService))
 
-	registerAdminRoutes(router, adminStaticDir, linkService, auth)
+	registerAdminRoutes(router, logger, adminStaticDir, linkService, users, sessionStore, throttle, authChain, oidcLogin, auditLog, visitStream, hooks)
 
-	router.GET("/:code", func(c *gin.Context) {
-		targetURL, err := linkService.Resolve(c.Request.Context(), c.Param("code"), links.VisitMeta{
-			VisitedAt:   time.Now().UTC(),
-			IP:          c.ClientIP(),
-			Referer:     c.Request.Referer(),
-			RefererHost: "",
-			UserAgent:   c.Request.UserAgent(),
-		})
+	redirect := func(c *gin.Context) {
+		var (
+			targetURL    string
+			newVisitorID string
+			err          error
+		)
+		if c.Request.Method == http.MethodHead {
+			// Link checkers send HEAD; nobody is visiting, so nothing is recorded.
+			targetURL, err = linkService.Lookup(c.Request.Context(), c.Param("code"))
+		} else {
+			visitorID, _ := c.Cookie(visitorCookieName)
+			if !links.IsVisitorID(visitorID) {
+				visitorID = ""
+			}
+
+			targetURL, newVisitorID, err = linkService.Resolve(c.Request.Context(), c.Param("code"), links.VisitMeta{
+				VisitedAt:   time.Now().UTC(),
+				IP:          c.ClientIP(),
+				Referer:     c.Request.Referer(),
+				RefererHost: "",
+				UserAgent:   c.Request.UserAgent(),
+				VisitorID:   visitorID,
+				Prefetch:    links.IsPrefetchRequest(c.GetHeader("Sec-Purpose"), c.GetHeader("Purpose"), c.GetHeader("X-Moz")),
+				Language:    links.PrimaryLanguage(c.GetHeader("Accept-Language")),
+				DoNotTrack:  links.DoNotTrack(c.GetHeader("DNT"), c.GetHeader("Sec-GPC")),
+				Query:       c.Request.URL.Query(),
+			})
+		}
 		if err != nil {
-			if errors.Is(err, links.ErrLinkNotFound) {
-				c.Status(http.StatusNotFound)
+			switch {
+			case errors.Is(err, links.ErrLinkDisabled):
+				serverMetrics.Redirect(metrics.RedirectDisabled)
+			case errors.Is(err, links.ErrLinkExpired):
+				serverMetrics.Redirect(metrics.RedirectExpired)
+			case errors.Is(err, links.ErrLinkNotFound):
+				serverMetrics.Redirect(metrics.RedirectNotFound)
+			default:
+				serverMetrics.Redirect(metrics.RedirectError)
+				logger.Error("resolve link failed", "error", err, "code", c.Param("code"))
+				c.Status(http.StatusInternalServerError)
 				return
 			}
-
-			logger.Error("resolve link failed", "error", err, "code", c.Param("code"))
-			c.Status(http.StatusInternalServerError)
+			c.Status(http.StatusNotFound)
 			return
 		}
 
+		if newVisitorID != "" {
+			setVisitorCookie(c, newVisitorID)
+		}
+		serverMetrics.Redirect(metrics.RedirectHit)
 		c.Redirect(http.StatusFound, targetURL)
-	})
+	}
+	router.GET("/:code", redirect)
+	router.HEAD("/:code", redirect)
 
 	return router
 }
 
+const (
+	visitorCookieName   = "shorturl_vid"
+	visitorCookieMaxAge = 365 * 24 * time.Hour
+)
+
+// setVisitorCookie hands out a first-party visitor id so repeat visits within
+// the year count as the same visitor.
+func setVisitorCookie(c *gin.Context, visitorID string) {
+	secure := c.Request.TLS != nil || strings.EqualFold(c.GetHeader("X-Forwarded-Proto"), "https")
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     visitorCookieName,
+		Value:    visitorID,
+		Path:     "/",
+		MaxAge:   int(visitorCookieMaxAge.Seconds()),
+		HttpOnly: true,
+		Secure:   secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
 func requestLogger(logger *slog.Logger) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		started := time.Now()
@@ -81,14 +174,71 @@ func requestLogger(logger *slog.Logger) gin.HandlerFunc {
 			path = c.Request.URL.Path
 		}
 
-		logger.Info(
-			"request handled",
+		attrs := []any{
 			"method", c.Request.Method,
 			"path", path,
 			"status", c.Writer.Status(),
 			"latency_ms", time.Since(started).Milliseconds(),
 			"client_ip", c.ClientIP(),
+		}
+		if spanContext := trace.SpanContextFromContext(c.Request.Context()); spanContext.IsValid() {
+			attrs = append(attrs, "trace_id", spanContext.TraceID().String(), "span_id", spanContext.SpanID().String())
+		}
+		logger.Info("request handled", attrs...)
+	}
+}
+
+// requestTracing starts a server span per request, continuing the trace from
+// an incoming traceparent header. Spans are named after the route template so
+// short codes do not end up in span names.
+func requestTracing() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route := c.FullPath()
+		if route == "" {
+			route = metrics.UnmatchedRoute
+		}
+		ctx := otel.GetTextMapPropagator().Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+		ctx, span := otel.Tracer(tracerName).Start(ctx, c.Request.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(c.Request.Method),
+				semconv.HTTPRoute(route),
+				semconv.URLPath(c.Request.URL.Path),
+			),
 		)
+		defer span.End()
+
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	}
+}
+
+// errorCodeKey carries the code written by writeJSONError to requestMetrics.
+const errorCodeKey = "shorturl_error_code"
+
+func requestMetrics(serverMetrics *metrics.Metrics) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		started := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		serverMetrics.ObserveRequest(route, c.Request.Method, c.Writer.Status(), time.Since(started))
+		if code := c.GetString(errorCodeKey); code != "" && strings.HasPrefix(route, "/admin/api/") {
+			serverMetrics.APIError(code)
+		}
+	}
+}
+
+func clientIPContext() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Request = c.Request.WithContext(auth.WithClientIP(c.Request.Context(), c.ClientIP()))
+		c.Next()
 	}
 }
 
@@ -124,24 +274,60 @@ func serveAdminIndex(c *gin.Context, indexPath string) {
 	c.String(http.StatusServiceUnavailable, "admin frontend not built yet. Run `make admin-install admin-build` first.")
 }
 
-func requireLogin() gin.HandlerFunc {
+func requireLogin(users userStore) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		if currentUsername(c) == "" {
+		username := currentUsername(c)
+		if username == "" {
+			writeJSONError(c, http.StatusUnauthorized, "unauthorized")
+			c.Abort()
+			return
+		}
+
+		user, err := users.GetUser(c.Request.Context(), username)
+		if err != nil {
+			if errors.Is(err, auth.ErrUserNotFound) {
+				writeJSONError(c, http.StatusUnauthorized, "unauthorized")
+			} else {
+				writeJSONError(c, http.StatusInternalServerError, "internal_error")
+			}
+			c.Abort()
+			return
+		}
+		if user.Disabled {
 			writeJSONError(c, http.StatusUnauthorized, "unauthorized")
 			c.Abort()
 			return
 		}
+
+		c.Set(contextUserKey, user)
+		c.Next()
+	}
+}
+
+func requireRole(role auth.Role) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !currentUser(c).Role.Allows(role) {
+			writeJSONError(c, http.StatusForbidden, "forbidden")
+			c.Abort()
+			return
+		}
 		c.Next()
 	}
 }
 
+func currentUser(c *gin.Context) auth.User {
+	user, _ := c.MustGet(contextUserKey).(auth.User)
+	return user
+}
+
 func currentUsername(c *gin.Context) string {
 	session := sessions.Default(c)
-	username, _ := session.Get(sessionUserKey).(string)
+	username, _ := session.Get(SessionUserKey).(string)
 	return strings.TrimSpace(username)
 }
 
 func writeJSONError(c *gin.Context, status int, code string) {
+	c.Set(errorCodeKey, code)
 	c.JSON(status, apiResponse{
 		Success: false,
 		Error:   code,
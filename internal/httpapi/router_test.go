@@ -1,23 +1,38 @@
 package httpapi
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	"encoding/json"
+	"io"
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/gin-contrib/sessions"
-	"github.com/gin-contrib/sessions/cookie"
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 
+	"github.com/mine/shorturl/internal/audit"
+	"github.com/mine/shorturl/internal/auth"
 	"github.com/mine/shorturl/internal/links"
+	"github.com/mine/shorturl/internal/live"
+	"github.com/mine/shorturl/internal/metrics"
 	"github.com/mine/shorturl/internal/store/sqlite"
+	"github.com/mine/shorturl/internal/webhooks"
 )
 
 func TestHealthz(t *testing.T) {
@@ -75,6 +90,120 @@ func TestAdminLoginRejectsBadPassword(t *testing.T) {
 	}
 }
 
+func TestAdminLoginLocksOutAfterRepeatedFailures(t *testing.T) {
+	router := newTestRouter(t)
+
+	for attempt := range 3 {
+		recorder := performJSONRequest(router, http.MethodPost, "/admin/api/v1/auth/login", `{"username":"admin","password":"wrong"}`, "")
+		if recorder.Code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: expected 401, got %d body=%s", attempt+1, recorder.Code, recorder.Body.String())
+		}
+	}
+
+	lockedRecorder := performJSONRequest(router, http.MethodPost, "/admin/api/v1/auth/login", `{"username":"admin","password":"change-me"}`, "")
+	if lockedRecorder.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %d body=%s", lockedRecorder.Code, lockedRecorder.Body.String())
+	}
+	if !strings.Contains(lockedRecorder.Body.String(), `"error":"too_many_attempts"`) {
+		t.Fatalf("expected too_many_attempts error, got %s", lockedRecorder.Body.String())
+	}
+	if retryAfter := lockedRecorder.Header().Get("Retry-After"); retryAfter == "" || retryAfter == "0" {
+		t.Fatalf("expected Retry-After header, got %q", retryAfter)
+	}
+}
+
+func TestAdminCanListAndUnlockLockouts(t *testing.T) {
+	router := newTestRouter(t)
+	sessionCookie := login(t, router)
+
+	for range 3 {
+		performJSONRequest(router, http.MethodPost, "/admin/api/v1/auth/login", `{"username":"mallory","password":"guess"}`, "")
+	}
+
+	listRecorder := performJSONRequest(router, http.MethodGet, "/admin/api/v1/auth/lockouts", "", sessionCookie)
+	if listRecorder.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", listRecorder.Code, listRecorder.Body.String())
+	}
+	if !strings.Contains(listRecorder.Body.String(), `"key":"mallory"`) {
+		t.Fatalf("expected locked username in list, got %s", listRecorder.Body.String())
+	}
+
+	unlockRecorder := performJSONRequest(router, http.MethodPost, "/admin/api/v1/auth/lockouts/unlock", `{"scope":"username","key":"mallory"}`, sessionCookie)
+	if unlockRecorder.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", unlockRecorder.Code, unlockRecorder.Body.String())
+	}
+
+	afterRecorder := performJSONRequest(router, http.MethodGet, "/admin/api/v1/auth/lockouts", "", sessionCookie)
+	if strings.Contains(afterRecorder.Body.String(), `"key":"mallory"`) {
+		t.Fatalf("expected lockout to be cleared, got %s", afterRecorder.Body.String())
+	}
+}
+
+func TestLogoutRevokesSessionServerSide(t *testing.T) {
+	router := newTestRouter(t)
+	sessionCookie := login(t, router)
+
+	logoutRecorder := performJSONRequest(router, http.MethodPost, "/admin/api/v1/auth/logout", "", sessionCookie)
+	if logoutRecorder.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", logoutRecorder.Code, logoutRecorder.Body.String())
+	}
+
+	replayRecorder := performJSONRequest(router, http.MethodGet, "/admin/api/v1/auth/session", "", sessionCookie)
+	if replayRecorder.Code != http.StatusUnauthorized {
+		t.Fatalf("expected replayed cookie to be rejected, got %d", replayRecorder.Code)
+	}
+}
+
+func TestListAndRevokeSessions(t *testing.T) {
+	router := newTestRouter(t)
+	laptopCookie := login(t, router)
+	phoneCookie := login(t, router)
+
+	listRecorder := performJSONRequest(router, http.MethodGet, "/admin/api/v1/auth/sessions", "", laptopCookie)
+	if listRecorder.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", listRecorder.Code, listRecorder.Body.String())
+	}
+	body := listRecorder.Body.String()
+	if strings.Count(body, `"username":"admin"`) != 2 || strings.Count(body, `"current":true`) != 1 {
+		t.Fatalf("expected two sessions with one current, got %s", body)
+	}
+
+	revokeRecorder := performJSONRequest(router, http.MethodDelete, "/admin/api/v1/auth/sessions", "", laptopCookie)
+	if revokeRecorder.Code != http.StatusOK || !strings.Contains(revokeRecorder.Body.String(), `"revoked":1`) {
+		t.Fatalf("expected one revoked session, got %d body=%s", revokeRecorder.Code, revokeRecorder.Body.String())
+	}
+
+	if recorder := performJSONRequest(router, http.MethodGet, "/admin/api/v1/auth/session", "", phoneCookie); recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("expected revoked session to be rejected, got %d", recorder.Code)
+	}
+	if recorder := performJSONRequest(router, http.MethodGet, "/admin/api/v1/auth/session", "", laptopCookie); recorder.Code != http.StatusOK {
+		t.Fatalf("expected current session to survive, got %d", recorder.Code)
+	}
+}
+
+func TestChangePasswordRevokesOtherSessions(t *testing.T) {
+	router := newTestRouter(t)
+	laptopCookie := login(t, router)
+	phoneCookie := login(t, router)
+
+	changeRecorder := performJSONRequest(router, http.MethodPost, "/admin/api/v1/auth/password", `{"current_password":"change-me","new_password":"a-much-better-one"}`, laptopCookie)
+	if changeRecorder.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", changeRecorder.Code, changeRecorder.Body.String())
+	}
+
+	if recorder := performJSONRequest(router, http.MethodGet, "/admin/api/v1/auth/session", "", phoneCookie); recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("expected other session to be revoked, got %d", recorder.Code)
+	}
+	if recorder := performJSONRequest(router, http.MethodGet, "/admin/api/v1/auth/session", "", laptopCookie); recorder.Code != http.StatusOK {
+		t.Fatalf("expected current session to survive, got %d", recorder.Code)
+	}
+
+	oldRecorder := performJSONRequest(router, http.MethodPost, "/admin/api/v1/auth/login", `{"username":"admin","password":"change-me"}`, "")
+	if oldRecorder.Code != http.StatusUnauthorized {
+		t.Fatalf("expected old password to be rejected, got %d", oldRecorder.Code)
+	}
+}
+
 func TestCreateLinkFlow(t *testing.T) {
 	router := newTestRouter(t)
 	sessionCookie := login(t, router)
@@ -152,6 +281,1065 @@ func TestDeleteLinkFlow(t *testing.T) {
 	}
 }
 
+func TestLinkAnalyticsRangeValidation(t *testing.T) {
+	router := newTestRouter(t)
+	sessionCookie := login(t, router)
+
+	performJSONRequest(router, http.MethodPost, "/admin/api/v1/links", `{"code":"ranged","target_url":"https://example.com/r"}`, sessionCookie)
+
+	hourlyRecorder := performJSONRequest(router, http.MethodGet, "/admin/api/v1/links/1/analytics?from=2025-03-01&to=2025-03-02&granularity=hour", "", sessionCookie)
+	if hourlyRecorder.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", hourlyRecorder.Code, hourlyRecorder.Body.String())
+	}
+	var response struct {
+		Data struct {
+			Granularity string `json:"granularity"`
+			TimeSeries  []any  `json:"time_series"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(hourlyRecorder.Body.Bytes(), &response); err != nil {
+		t.Fatalf("decode analytics: %v", err)
+	}
+	if response.Data.Granularity != "hour" || len(response.Data.TimeSeries) != 48 {
+		t.Fatalf("expected 48 hourly buckets for two inclusive days, got %s", hourlyRecorder.Body.String())
+	}
+
+	for _, query := range []string{
+		"granularity=5m&days=7",
+		"granularity=week",
+		"from=2025-03-02&to=2025-03-01",
+		"from=yesterday",
+	} {
+		recorder := performJSONRequest(router, http.MethodGet, "/admin/api/v1/links/1/analytics?"+query, "", sessionCookie)
+		if recorder.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected 400, got %d body=%s", query, recorder.Code, recorder.Body.String())
+		}
+	}
+}
+
+func TestRedirectCapturesCampaignParams(t *testing.T) {
+	router := newTestRouter(t)
+	sessionCookie := login(t, router)
+
+	performJSONRequest(router, http.MethodPost, "/admin/api/v1/links", `{"code":"promo","target_url":"https://example.com/promo"}`, sessionCookie)
+	for _, path := range []string{
+		"/promo?utm_source=newsletter&utm_medium=email&utm_campaign=spring&gclid=abc123",
+		"/promo?utm_source=newsletter&utm_campaign=spring",
+		"/promo",
+	} {
+		if recorder := performJSONRequest(router, http.MethodGet, path, "", ""); recorder.Code != http.StatusFound {
+			t.Fatalf("%s: expected 302, got %d", path, recorder.Code)
+		}
+	}
+
+	recorder := performJSONRequest(router, http.MethodGet, "/admin/api/v1/links/1/analytics", "", sessionCookie)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", recorder.Code, recorder.Body.String())
+	}
+	var response struct {
+		Data struct {
+			Campaigns map[string][]struct {
+				Name  string `json:"name"`
+				Count int64  `json:"count"`
+			} `json:"campaigns"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatalf("decode analytics: %v", err)
+	}
+
+	sources := response.Data.Campaigns["utm_source"]
+	if len(sources) != 2 || sources[0].Name != "newsletter" || sources[0].Count != 2 || sources[1].Name != "未标记" {
+		t.Fatalf("unexpected utm_source breakdown %+v", sources)
+	}
+	gclids := response.Data.Campaigns["gclid"]
+	if len(gclids) != 2 || gclids[0].Name != "未标记" || gclids[1].Name != "abc123" {
+		t.Fatalf("unexpected gclid breakdown %+v", gclids)
+	}
+}
+
+func TestRedirectCountsUniqueVisitors(t *testing.T) {
+	router := newTestRouter(t)
+	sessionCookie := login(t, router)
+
+	performJSONRequest(router, http.MethodPost, "/admin/api/v1/links", `{"code":"visits","target_url":"https://example.com/visits"}`, sessionCookie)
+
+	first := performJSONRequest(router, http.MethodGet, "/visits", "", "")
+	visitorCookie := first.Header().Get("Set-Cookie")
+	if first.Code != http.StatusFound || !strings.HasPrefix(visitorCookie, "shorturl_vid=") || !strings.Contains(visitorCookie, "HttpOnly") {
+		t.Fatalf("expected visitor cookie on first visit, got %d %q", first.Code, visitorCookie)
+	}
+
+	second := performJSONRequest(router, http.MethodGet, "/visits", "", visitorCookie)
+	if second.Code != http.StatusFound || second.Header().Get("Set-Cookie") != "" {
+		t.Fatalf("expected returning visitor to keep its cookie, got %d %q", second.Code, second.Header().Get("Set-Cookie"))
+	}
+	performJSONRequest(router, http.MethodGet, "/visits", "", "shorturl_vid=AAAAAAAAAAAAAAAAAAAAAA")
+
+	recorder := performJSONRequest(router, http.MethodGet, "/admin/api/v1/links/1/analytics", "", sessionCookie)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", recorder.Code, recorder.Body.String())
+	}
+	var response struct {
+		Data struct {
+			RecentClicks   int64 `json:"recent_clicks"`
+			UniqueVisitors int64 `json:"unique_visitors"`
+			NewVisitors    int64 `json:"new_visitors"`
+			TimeSeries     []struct {
+				Clicks   int64 `json:"clicks"`
+				Visitors int64 `json:"visitors"`
+			} `json:"time_series"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatalf("decode analytics: %v", err)
+	}
+	if response.Data.RecentClicks != 3 || response.Data.UniqueVisitors != 2 || response.Data.NewVisitors != 2 {
+		t.Fatalf("unexpected visitor totals %+v", response.Data)
+	}
+	today := response.Data.TimeSeries[len(response.Data.TimeSeries)-1]
+	if today.Clicks != 3 || today.Visitors != 2 {
+		t.Fatalf("unexpected time series point %+v", today)
+	}
+}
+
+func TestRedirectGivesEachCookielessVisitorItsOwnID(t *testing.T) {
+	router := newTestRouter(t)
+	sessionCookie := login(t, router)
+	performJSONRequest(router, http.MethodPost, "/admin/api/v1/links", `{"code":"nat","target_url":"https://example.com/nat"}`, sessionCookie)
+
+	visit := func(userAgent string) string {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/nat", nil)
+		req.RemoteAddr = "203.0.113.7:4321"
+		req.Header.Set("User-Agent", userAgent)
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		if recorder.Code != http.StatusFound {
+			t.Fatalf("expected 302, got %d", recorder.Code)
+		}
+		return recorder.Header().Get("Set-Cookie")
+	}
+	// Two people behind the same NAT with the same phone.
+	phone := "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 Version/17.0 Mobile/15E148 Safari/604.1"
+	first, second := visit(phone), visit(phone)
+	if !strings.HasPrefix(first, "shorturl_vid=") || first == second {
+		t.Fatalf("expected distinct visitor cookies, got %q and %q", first, second)
+	}
+	if cookie := visit("Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)"); cookie != "" {
+		t.Fatalf("expected no visitor cookie for a crawler, got %q", cookie)
+	}
+
+	recorder := performJSONRequest(router, http.MethodGet, "/admin/api/v1/links/1/analytics", "", sessionCookie)
+	if !strings.Contains(recorder.Body.String(), `"unique_visitors":2`) {
+		t.Fatalf("expected two unique visitors, got %s", recorder.Body.String())
+	}
+}
+
+func TestRedirectSeparatesBotTraffic(t *testing.T) {
+	router := newTestRouter(t)
+	sessionCookie := login(t, router)
+
+	performJSONRequest(router, http.MethodPost, "/admin/api/v1/links", `{"code":"launch","target_url":"https://example.com/launch"}`, sessionCookie)
+	requests := []struct {
+		method string
+		header string
+		value  string
+	}{
+		{http.MethodGet, "User-Agent", "Mozilla/5.0 (Macintosh; Intel Mac OS X 14_0) AppleWebKit/605.1.15 Version/17.0 Safari/605.1.15"},
+		{http.MethodGet, "User-Agent", "Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)"},
+		{http.MethodGet, "User-Agent", "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)"},
+		{http.MethodGet, "Sec-Purpose", "prefetch;prerender"},
+		{http.MethodHead, "", ""},
+	}
+	for _, item := range requests {
+		req := httptest.NewRequest(item.method, "/launch", nil)
+		if item.header != "" {
+			req.Header.Set(item.header, item.value)
+		}
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		if recorder.Code != http.StatusFound {
+			t.Fatalf("%s %s=%q: expected 302, got %d", item.method, item.header, item.value, recorder.Code)
+		}
+	}
+
+	type analyticsResponse struct {
+		Data struct {
+			Link struct {
+				ClickCount int64 `json:"click_count"`
+			} `json:"link"`
+			RecentClicks int64 `json:"recent_clicks"`
+			BotTraffic   struct {
+				Total  int64 `json:"total"`
+				ByType []struct {
+					Name  string `json:"name"`
+					Count int64  `json:"count"`
+				} `json:"by_type"`
+			} `json:"bot_traffic"`
+		} `json:"data"`
+	}
+	decode := func(path string) analyticsResponse {
+		t.Helper()
+		recorder := performJSONRequest(router, http.MethodGet, path, "", sessionCookie)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d body=%s", recorder.Code, recorder.Body.String())
+		}
+		var response analyticsResponse
+		if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+			t.Fatalf("decode analytics: %v", err)
+		}
+		return response
+	}
+
+	humanOnly := decode("/admin/api/v1/links/1/analytics")
+	if humanOnly.Data.Link.ClickCount != 1 || humanOnly.Data.RecentClicks != 1 {
+		t.Fatalf("expected only the browser visit to count, got %+v", humanOnly.Data)
+	}
+	// The HEAD request redirects but is not recorded at all.
+	if humanOnly.Data.BotTraffic.Total != 3 || len(humanOnly.Data.BotTraffic.ByType) != 3 {
+		t.Fatalf("unexpected bot traffic report %+v", humanOnly.Data.BotTraffic)
+	}
+
+	withBots := decode("/admin/api/v1/links/1/analytics?include_bots=true")
+	if withBots.Data.RecentClicks != 4 {
+		t.Fatalf("expected include_bots to count every visit, got %d", withBots.Data.RecentClicks)
+	}
+}
+
+func TestRedirectHonorsPrivacyControls(t *testing.T) {
+	router := newTestRouter(t)
+	sessionCookie := login(t, router)
+
+	created := performJSONRequest(router, http.MethodPost, "/admin/api/v1/links", `{"code":"private","target_url":"https://example.com/private","no_tracking":true}`, sessionCookie)
+	if created.Code != http.StatusCreated || !strings.Contains(created.Body.String(), `"no_tracking":true`) {
+		t.Fatalf("expected no-tracking link, got %d body=%s", created.Code, created.Body.String())
+	}
+	performJSONRequest(router, http.MethodPost, "/admin/api/v1/links", `{"code":"public","target_url":"https://example.com/public"}`, sessionCookie)
+
+	// Toggling a link from the list does not send no_tracking.
+	toggled := performJSONRequest(router, http.MethodPut, "/admin/api/v1/links/1", `{"code":"private","target_url":"https://example.com/private","enabled":true}`, sessionCookie)
+	if toggled.Code != http.StatusOK || !strings.Contains(toggled.Body.String(), `"no_tracking":true`) {
+		t.Fatalf("expected update without no_tracking to keep it, got %d body=%s", toggled.Code, toggled.Body.String())
+	}
+
+	visit := func(path string, header string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, path+"?utm_source=newsletter", nil)
+		req.RemoteAddr = "203.0.113.7:4321"
+		req.Header.Set("User-Agent", "Mozilla/5.0 (Macintosh; Intel Mac OS X 14_0) AppleWebKit/605.1.15 Version/17.0 Safari/605.1.15")
+		if header != "" {
+			req.Header.Set(header, "1")
+		}
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		if recorder.Code != http.StatusFound {
+			t.Fatalf("%s %s: expected 302, got %d", path, header, recorder.Code)
+		}
+		return recorder
+	}
+	for _, item := range []struct{ path, header string }{{"/public", "DNT"}, {"/public", "Sec-GPC"}, {"/private", ""}} {
+		if cookie := visit(item.path, item.header).Header().Get("Set-Cookie"); cookie != "" {
+			t.Fatalf("%s %s: expected no visitor cookie, got %q", item.path, item.header, cookie)
+		}
+	}
+	if cookie := visit("/public", "").Header().Get("Set-Cookie"); !strings.HasPrefix(cookie, "shorturl_vid=") {
+		t.Fatalf("expected visitor cookie for tracked visit, got %q", cookie)
+	}
+
+	type analyticsResponse struct {
+		Data struct {
+			Link struct {
+				ClickCount int64 `json:"click_count"`
+			} `json:"link"`
+			RecentClicks   int64 `json:"recent_clicks"`
+			UniqueVisitors int64 `json:"unique_visitors"`
+			RecentVisits   []struct {
+				IPMasked  string `json:"ip_masked"`
+				UserAgent string `json:"user_agent"`
+				UTMSource string `json:"utm_source"`
+			} `json:"recent_visits"`
+		} `json:"data"`
+	}
+	decode := func(path string) analyticsResponse {
+		t.Helper()
+		recorder := performJSONRequest(router, http.MethodGet, path, "", sessionCookie)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d body=%s", recorder.Code, recorder.Body.String())
+		}
+		var response analyticsResponse
+		if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+			t.Fatalf("decode analytics: %v", err)
+		}
+		return response
+	}
+
+	private := decode("/admin/api/v1/links/1/analytics")
+	if private.Data.RecentClicks != 1 || private.Data.RecentVisits[0].IPMasked != "" || private.Data.RecentVisits[0].UTMSource != "" {
+		t.Fatalf("expected an anonymous visit on the no-tracking link, got %+v", private.Data)
+	}
+	public := decode("/admin/api/v1/links/2/analytics")
+	if public.Data.RecentClicks != 3 || public.Data.UniqueVisitors != 1 {
+		t.Fatalf("expected 3 visits and 1 tracked visitor, got %+v", public.Data)
+	}
+	anonymous := 0
+	for _, visit := range public.Data.RecentVisits {
+		if visit.IPMasked == "" && visit.UserAgent == "" {
+			anonymous++
+		}
+	}
+	if anonymous != 2 {
+		t.Fatalf("expected DNT and GPC visits to be stored without detail, got %+v", public.Data.RecentVisits)
+	}
+
+	if recorder := performJSONRequest(router, http.MethodPost, "/admin/api/v1/visits/erase", `{}`, sessionCookie); recorder.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 without identifiers, got %d", recorder.Code)
+	}
+	erased := performJSONRequest(router, http.MethodPost, "/admin/api/v1/visits/erase", `{"ip":"203.0.113.7"}`, sessionCookie)
+	if erased.Code != http.StatusOK || !strings.Contains(erased.Body.String(), `"deleted":1`) {
+		t.Fatalf("expected one erased visit, got %d body=%s", erased.Code, erased.Body.String())
+	}
+	afterErase := decode("/admin/api/v1/links/2/analytics")
+	if afterErase.Data.RecentClicks != 2 || afterErase.Data.Link.ClickCount != 3 {
+		t.Fatalf("expected anonymous visits and click_count to remain, got %+v", afterErase.Data)
+	}
+
+	auditLogs := performJSONRequest(router, http.MethodGet, "/admin/api/v1/audit-logs?action=visits.erased", "", sessionCookie)
+	if !strings.Contains(auditLogs.Body.String(), "visits.erased") || strings.Contains(auditLogs.Body.String(), "203.0.113.7") {
+		t.Fatalf("expected erasure audit entry without the address, got %s", auditLogs.Body.String())
+	}
+}
+
+func TestBreakdownPivotsOnDimensions(t *testing.T) {
+	router := newTestRouter(t)
+	sessionCookie := login(t, router)
+
+	performJSONRequest(router, http.MethodPost, "/admin/api/v1/links", `{"code":"pivot","target_url":"https://example.com/pivot"}`, sessionCookie)
+	for _, userAgent := range []string{
+		"Mozilla/5.0 (iPhone; CPU iPhone OS 17_5 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.5 Mobile/15E148 Safari/604.1",
+		"Mozilla/5.0 (iPhone; CPU iPhone OS 17_5 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.5 Mobile/15E148 Safari/604.1",
+		"Mozilla/5.0 (Macintosh; Intel Mac OS X 14_0) AppleWebKit/605.1.15 Version/17.0 Safari/605.1.15",
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/pivot", nil)
+		req.Header.Set("User-Agent", userAgent)
+		req.Header.Set("Referer", "https://news.example.com/today")
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	recorder := performJSONRequest(router, http.MethodGet, "/admin/api/v1/analytics/breakdown?link_id=1&dimension=referer_host&filter=device:mobile&filter=os:iOS", "", sessionCookie)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", recorder.Code, recorder.Body.String())
+	}
+	var response struct {
+		Data links.Breakdown `json:"data"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatalf("decode breakdown: %v", err)
+	}
+	if response.Data.Total != 2 || len(response.Data.Items) != 1 || response.Data.Items[0].Name != "news.example.com" || len(response.Data.Filters) != 2 {
+		t.Fatalf("unexpected breakdown %+v", response.Data)
+	}
+
+	analytics := performJSONRequest(router, http.MethodGet, "/admin/api/v1/links/1/analytics", "", sessionCookie)
+	if !strings.Contains(analytics.Body.String(), `"top_devices":[{"name":"mobile","count":2},{"name":"desktop","count":1}]`) {
+		t.Fatalf("expected device breakdown in link analytics, got %s", analytics.Body.String())
+	}
+
+	for _, path := range []string{
+		"/admin/api/v1/analytics/breakdown?dimension=ip",
+		"/admin/api/v1/analytics/breakdown?dimension=os&filter=mobile",
+		"/admin/api/v1/analytics/breakdown?dimension=os&filter=visitor_id:abc",
+		"/admin/api/v1/analytics/breakdown?dimension=os&limit=1000",
+		"/admin/api/v1/analytics/breakdown?dimension=os&link_id=1&tag=launch",
+	} {
+		if recorder := performJSONRequest(router, http.MethodGet, path, "", sessionCookie); recorder.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected 400, got %d", path, recorder.Code)
+		}
+	}
+	if recorder := performJSONRequest(router, http.MethodGet, "/admin/api/v1/analytics/breakdown?dimension=os&link_id=99", "", sessionCookie); recorder.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for a missing link, got %d", recorder.Code)
+	}
+}
+
+func TestAnalyticsGroupsAcceptLanguage(t *testing.T) {
+	router := newTestRouter(t)
+	sessionCookie := login(t, router)
+
+	performJSONRequest(router, http.MethodPost, "/admin/api/v1/links", `{"code":"intl","target_url":"https://example.com/intl"}`, sessionCookie)
+	for _, header := range []string{"zh-CN,zh;q=0.9,en;q=0.8", "zh-TW,zh;q=0.9", "zh_cn", "en;q=0.5, en-US", ""} {
+		req := httptest.NewRequest(http.MethodGet, "/intl", nil)
+		req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0.0.0 Safari/537.36")
+		if header != "" {
+			req.Header.Set("Accept-Language", header)
+		}
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	type languages struct {
+		Data struct {
+			TopLanguages []struct {
+				Name    string `json:"name"`
+				Count   int64  `json:"count"`
+				Regions []struct {
+					Name  string `json:"name"`
+					Count int64  `json:"count"`
+				} `json:"regions"`
+			} `json:"top_languages"`
+		} `json:"data"`
+	}
+	for _, path := range []string{"/admin/api/v1/links/1/analytics", "/admin/api/v1/analytics/overview"} {
+		recorder := performJSONRequest(router, http.MethodGet, path, "", sessionCookie)
+		var response languages
+		if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+			t.Fatalf("%s: decode: %v", path, err)
+		}
+		top := response.Data.TopLanguages
+		if len(top) != 3 || top[0].Name != "zh" || top[0].Count != 3 || top[1].Name != "en" || top[2].Name != "未知" {
+			t.Fatalf("%s: unexpected languages %+v", path, top)
+		}
+		if regions := top[0].Regions; len(regions) != 2 || regions[0].Name != "zh-CN" || regions[0].Count != 2 || regions[1].Name != "zh-TW" {
+			t.Fatalf("%s: unexpected zh regions %+v", path, regions)
+		}
+		if top[1].Regions[0].Name != "en-US" {
+			t.Fatalf("%s: expected en-US to win on q-value, got %+v", path, top[1].Regions)
+		}
+	}
+}
+
+func TestAnalyticsOverviewAggregatesAllLinks(t *testing.T) {
+	router := newTestRouter(t)
+	sessionCookie := login(t, router)
+
+	performJSONRequest(router, http.MethodPost, "/admin/api/v1/links", `{"code":"alpha","target_url":"https://example.com/a"}`, sessionCookie)
+	performJSONRequest(router, http.MethodPost, "/admin/api/v1/links", `{"code":"beta","target_url":"https://example.com/b"}`, sessionCookie)
+	for _, path := range []string{"/alpha", "/alpha", "/beta"} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("User-Agent", "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) Mobile/15E148 Safari/604.1")
+		req.Header.Set("Referer", "https://news.example.org/post")
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	recorder := performJSONRequest(router, http.MethodGet, "/admin/api/v1/analytics/overview?days=7", "", sessionCookie)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", recorder.Code, recorder.Body.String())
+	}
+	type breakdown struct {
+		Name  string `json:"name"`
+		Count int64  `json:"count"`
+	}
+	var response struct {
+		Data struct {
+			TotalClicks int64 `json:"total_clicks"`
+			NewLinks    int64 `json:"new_links"`
+			TimeSeries  []struct {
+				Clicks int64 `json:"clicks"`
+			} `json:"time_series"`
+			TopLinks     []breakdown `json:"top_links"`
+			TopReferrers []breakdown `json:"top_referrers"`
+			TopDevices   []breakdown `json:"top_devices"`
+			TopOS        []breakdown `json:"top_os"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatalf("decode overview: %v", err)
+	}
+
+	data := response.Data
+	if data.TotalClicks != 3 || data.NewLinks != 2 || len(data.TimeSeries) != 7 || data.TimeSeries[6].Clicks != 3 {
+		t.Fatalf("unexpected overview totals %+v", data)
+	}
+	if len(data.TopLinks) != 2 || data.TopLinks[0] != (breakdown{"alpha", 2}) || data.TopLinks[1] != (breakdown{"beta", 1}) {
+		t.Fatalf("unexpected top links %+v", data.TopLinks)
+	}
+	if len(data.TopReferrers) != 1 || data.TopReferrers[0].Name != "news.example.org" {
+		t.Fatalf("unexpected top referrers %+v", data.TopReferrers)
+	}
+	if len(data.TopDevices) != 1 || data.TopDevices[0].Name != "mobile" || len(data.TopOS) != 1 || data.TopOS[0].Name != "iOS" {
+		t.Fatalf("unexpected device breakdown %+v %+v", data.TopDevices, data.TopOS)
+	}
+
+	if recorder := performJSONRequest(router, http.MethodGet, "/admin/api/v1/analytics/overview?granularity=week", "", sessionCookie); recorder.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for invalid granularity, got %d", recorder.Code)
+	}
+}
+
+func TestTagAnalyticsAndMatrix(t *testing.T) {
+	router := newTestRouter(t)
+	sessionCookie := login(t, router)
+
+	performJSONRequest(router, http.MethodPost, "/admin/api/v1/links", `{"code":"wx1","target_url":"https://example.com/1","tags":["wechat","spring"]}`, sessionCookie)
+	performJSONRequest(router, http.MethodPost, "/admin/api/v1/links", `{"code":"wx2","target_url":"https://example.com/2","tags":["wechat"]}`, sessionCookie)
+	performJSONRequest(router, http.MethodPost, "/admin/api/v1/links", `{"code":"mail","target_url":"https://example.com/3","tags":["email"]}`, sessionCookie)
+	for _, path := range []string{"/wx1", "/wx1", "/wx2", "/mail"} {
+		performJSONRequest(router, http.MethodGet, path, "", "")
+	}
+	// Retagging must move the link out of the old tag.
+	performJSONRequest(router, http.MethodPut, "/admin/api/v1/links/3", `{"code":"mail","target_url":"https://example.com/3","tags":["newsletter"],"enabled":true}`, sessionCookie)
+
+	recorder := performJSONRequest(router, http.MethodGet, "/admin/api/v1/analytics/tags?tag=wechat", "", sessionCookie)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", recorder.Code, recorder.Body.String())
+	}
+	var tagResponse struct {
+		Data struct {
+			Tag         string `json:"tag"`
+			LinkCount   int64  `json:"link_count"`
+			TotalClicks int64  `json:"total_clicks"`
+			TopLinks    []struct {
+				Name  string `json:"name"`
+				Count int64  `json:"count"`
+			} `json:"top_links"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &tagResponse); err != nil {
+		t.Fatalf("decode tag analytics: %v", err)
+	}
+	if tagResponse.Data.LinkCount != 2 || tagResponse.Data.TotalClicks != 3 || len(tagResponse.Data.TopLinks) != 2 || tagResponse.Data.TopLinks[0].Name != "wx1" {
+		t.Fatalf("unexpected tag analytics %+v", tagResponse.Data)
+	}
+
+	recorder = performJSONRequest(router, http.MethodGet, "/admin/api/v1/analytics/tags/matrix?days=3", "", sessionCookie)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", recorder.Code, recorder.Body.String())
+	}
+	var matrixResponse struct {
+		Data struct {
+			Buckets []string `json:"buckets"`
+			Rows    []struct {
+				Tag    string  `json:"tag"`
+				Total  int64   `json:"total"`
+				Clicks []int64 `json:"clicks"`
+			} `json:"rows"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &matrixResponse); err != nil {
+		t.Fatalf("decode tag matrix: %v", err)
+	}
+	rows := matrixResponse.Data.Rows
+	if len(matrixResponse.Data.Buckets) != 3 || len(rows) != 3 {
+		t.Fatalf("unexpected matrix shape %+v", matrixResponse.Data)
+	}
+	if rows[0].Tag != "wechat" || rows[0].Total != 3 || rows[0].Clicks[2] != 3 || rows[1].Tag != "spring" || rows[2].Tag != "newsletter" {
+		t.Fatalf("unexpected matrix rows %+v", rows)
+	}
+
+	if recorder := performJSONRequest(router, http.MethodGet, "/admin/api/v1/analytics/tags", "", sessionCookie); recorder.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 without tag, got %d", recorder.Code)
+	}
+}
+
+func TestExportVisitsStreamsCSVAndNDJSON(t *testing.T) {
+	// "{SHA}" of "static-pass".
+	path := filepath.Join(t.TempDir(), ".htpasswd")
+	if err := os.WriteFile(path, []byte("erin:{SHA}0r3BL7/5XD8exnlS8mpnUe1z2T4=\n"), 0o600); err != nil {
+		t.Fatalf("write htpasswd: %v", err)
+	}
+	provider, err := auth.NewHtpasswdProvider(path, auth.RoleViewer)
+	if err != nil {
+		t.Fatalf("new htpasswd provider: %v", err)
+	}
+	router := newTestRouterWithAuth(t, nil, provider)
+	adminCookie := login(t, router)
+
+	performJSONRequest(router, http.MethodPost, "/admin/api/v1/links", `{"code":"export","target_url":"https://example.com/export","tags":["ads"]}`, adminCookie)
+	performJSONRequest(router, http.MethodGet, "/export?utm_source=newsletter&gclid=g1", "", "")
+	performJSONRequest(router, http.MethodGet, "/export", "", "")
+
+	recorder := performJSONRequest(router, http.MethodGet, "/admin/api/v1/visits/export?link_id=1", "", adminCookie)
+	if recorder.Code != http.StatusOK || !strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/csv") {
+		t.Fatalf("expected csv export, got %d %q body=%s", recorder.Code, recorder.Header().Get("Content-Type"), recorder.Body.String())
+	}
+	lines := strings.Split(strings.TrimSpace(recorder.Body.String()), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "visited_at,link_id,code,ip,") {
+		t.Fatalf("unexpected csv export %q", recorder.Body.String())
+	}
+	if !strings.Contains(lines[1], ",export,192.0.2.1,") || !strings.Contains(lines[1], "newsletter") || !strings.Contains(lines[1], `"{""gclid"":""g1""}"`) {
+		t.Fatalf("expected admin export with raw ip and campaign fields, got %q", lines[1])
+	}
+
+	viewerLogin := performJSONRequest(router, http.MethodPost, "/admin/api/v1/auth/login", `{"username":"erin","password":"static-pass"}`, "")
+	viewerCookie := viewerLogin.Header().Get("Set-Cookie")
+	recorder = performJSONRequest(router, http.MethodGet, "/admin/api/v1/visits/export?tag=ads&format=ndjson", "", viewerCookie)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", recorder.Code, recorder.Body.String())
+	}
+	rows := strings.Split(strings.TrimSpace(recorder.Body.String()), "\n")
+	var first struct {
+		Code string `json:"code"`
+		IP   string `json:"ip"`
+	}
+	if err := json.Unmarshal([]byte(rows[0]), &first); err != nil {
+		t.Fatalf("decode ndjson row: %v", err)
+	}
+	if len(rows) != 2 || first.Code != "export" || first.IP != "192.0.2.*" {
+		t.Fatalf("expected masked ndjson rows for viewer, got %q", recorder.Body.String())
+	}
+
+	for path, status := range map[string]int{
+		"/admin/api/v1/visits/export":                      http.StatusBadRequest,
+		"/admin/api/v1/visits/export?link_id=1&tag=ads":    http.StatusBadRequest,
+		"/admin/api/v1/visits/export?link_id=1&format=xml": http.StatusBadRequest,
+		"/admin/api/v1/visits/export?link_id=99":           http.StatusNotFound,
+	} {
+		if recorder := performJSONRequest(router, http.MethodGet, path, "", adminCookie); recorder.Code != status {
+			t.Fatalf("%s: expected %d, got %d", path, status, recorder.Code)
+		}
+	}
+}
+
+func TestVisitStreamPushesServerSentEvents(t *testing.T) {
+	router := newTestRouter(t)
+	sessionCookie := login(t, router)
+	performJSONRequest(router, http.MethodPost, "/admin/api/v1/links", `{"code":"live","target_url":"https://example.com/live","tags":["launch"]}`, sessionCookie)
+	performJSONRequest(router, http.MethodPost, "/admin/api/v1/links", `{"code":"quiet","target_url":"https://example.com/quiet"}`, sessionCookie)
+
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/admin/api/v1/visits/stream?tag=launch", nil)
+	req.Header.Set("Cookie", strings.Split(sessionCookie, ";")[0])
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("open stream: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("expected event stream, got %d %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	for _, code := range []string{"quiet", "live"} {
+		visit, _ := http.NewRequest(http.MethodGet, server.URL+"/"+code, nil)
+		visit.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 Chrome/126.0 Safari/537.36")
+		visit.Header.Set("Referer", "https://news.example.org/")
+		visitResp, err := client.Do(visit)
+		if err != nil {
+			t.Fatalf("visit %s: %v", code, err)
+		}
+		visitResp.Body.Close()
+	}
+
+	reader := bufio.NewReader(resp.Body)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("read stream: %v", err)
+		}
+		data, ok := strings.CutPrefix(strings.TrimSpace(line), "data: ")
+		if !ok {
+			continue
+		}
+		var event struct {
+			Code        string `json:"code"`
+			IPMasked    string `json:"ip_masked"`
+			RefererHost string `json:"referer_host"`
+			ClientName  string `json:"client_name"`
+		}
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			t.Fatalf("decode event %q: %v", data, err)
+		}
+		if event.Code != "live" || event.IPMasked != "127.0.0.*" || event.RefererHost != "news.example.org" || event.ClientName != "Chrome" {
+			t.Fatalf("unexpected event %+v", event)
+		}
+		return
+	}
+}
+
+func TestAuditLogRecordsLinkChanges(t *testing.T) {
+	router := newTestRouter(t)
+	sessionCookie := login(t, router)
+
+	performJSONRequest(router, http.MethodPost, "/admin/api/v1/links", `{"code":"audited","target_url":"https://example.com/a"}`, sessionCookie)
+	performJSONRequest(router, http.MethodPut, "/admin/api/v1/links/1", `{"code":"audited","target_url":"https://example.com/b","enabled":false}`, sessionCookie)
+
+	listRecorder := performJSONRequest(router, http.MethodGet, "/admin/api/v1/audit-logs?action=link.disabled", "", sessionCookie)
+	if listRecorder.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", listRecorder.Code, listRecorder.Body.String())
+	}
+	body := listRecorder.Body.String()
+	if !strings.Contains(body, `"total":1`) || !strings.Contains(body, `"actor":"admin"`) || !strings.Contains(body, `"target_url":"https://example.com/b"`) {
+		t.Fatalf("expected one link.disabled entry with diff, got %s", body)
+	}
+
+	exportRecorder := performJSONRequest(router, http.MethodGet, "/admin/api/v1/audit-logs/export?target_type=link", "", sessionCookie)
+	if exportRecorder.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", exportRecorder.Code, exportRecorder.Body.String())
+	}
+	lines := strings.Split(strings.TrimSpace(exportRecorder.Body.String()), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], `"action":"link.created"`) {
+		t.Fatalf("expected 2 ndjson lines oldest first, got %q", exportRecorder.Body.String())
+	}
+}
+
+func TestConversionsAttributeToClicks(t *testing.T) {
+	router := newTestRouterWithOptions(t, nil, []links.ServiceOption{links.WithClickIDParam("sclid"), links.WithPostbackToken("postback-secret")})
+	sessionCookie := login(t, router)
+
+	performJSONRequest(router, http.MethodPost, "/admin/api/v1/links", `{"code":"shop","target_url":"https://example.com/shop?b=2&a=1"}`, sessionCookie)
+
+	click := func(referer string) string {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/shop", nil)
+		req.Header.Set("User-Agent", "Mozilla/5.0 (Macintosh; Intel Mac OS X 14_0) AppleWebKit/605.1.15 Version/17.0 Safari/605.1.15")
+		req.Header.Set("Referer", referer)
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		location := recorder.Header().Get("Location")
+		clickID, ok := strings.CutPrefix(location, "https://example.com/shop?b=2&a=1&sclid=")
+		if recorder.Code != http.StatusFound || !ok || !links.IsClickID(clickID) {
+			t.Fatalf("expected a click id appended to the target, got %d %q", recorder.Code, location)
+		}
+		return clickID
+	}
+	newsletter := click("https://mail.example.org/inbox")
+	search := click("https://search.example.net/?q=shop")
+	click("https://search.example.net/?q=shop")
+
+	postback := func(body string, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/conversions", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		return recorder
+	}
+	if recorder := postback(`{"click_id":"`+newsletter+`","value":30}`, "wrong"); recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for a bad token, got %d", recorder.Code)
+	}
+	if recorder := postback(`{"click_id":"`+newsletter+`","value":30}`, "postback-secret"); recorder.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d body=%s", recorder.Code, recorder.Body.String())
+	}
+	if recorder := postback(`{"click_id":"`+newsletter+`","value":30}`, "postback-secret"); recorder.Code != http.StatusOK || !strings.Contains(recorder.Body.String(), `"recorded":false`) {
+		t.Fatalf("expected a repeated postback to be ignored, got %d body=%s", recorder.Code, recorder.Body.String())
+	}
+	if recorder := postback(`{"click_id":"AAAAAAAAAAAAAAAAAAAAAA"}`, "postback-secret"); recorder.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for an unknown click, got %d", recorder.Code)
+	}
+	if recorder := postback(`{"click_id":"`+search+`","value":-1}`, "postback-secret"); recorder.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for a negative value, got %d", recorder.Code)
+	}
+
+	pixel := httptest.NewRecorder()
+	router.ServeHTTP(pixel, httptest.NewRequest(http.MethodGet, "/conversions/pixel.gif?click_id="+search+"&value=12.5", nil))
+	if pixel.Code != http.StatusOK || pixel.Header().Get("Content-Type") != "image/gif" || pixel.Header().Get("Cache-Control") != "no-store" {
+		t.Fatalf("expected an uncached gif, got %d %v", pixel.Code, pixel.Header())
+	}
+	broken := httptest.NewRecorder()
+	router.ServeHTTP(broken, httptest.NewRequest(http.MethodGet, "/conversions/pixel.gif?click_id=nope", nil))
+	if broken.Code != http.StatusOK || broken.Header().Get("Content-Type") != "image/gif" {
+		t.Fatalf("expected the pixel even for a bad click id, got %d", broken.Code)
+	}
+
+	var analytics struct {
+		Data struct {
+			Conversions           links.ConversionSummary     `json:"conversions"`
+			ConversionsByReferrer []links.ConversionBreakdown `json:"conversions_by_referrer"`
+		} `json:"data"`
+	}
+	recorder := performJSONRequest(router, http.MethodGet, "/admin/api/v1/links/1/analytics", "", sessionCookie)
+	if err := json.Unmarshal(recorder.Body.Bytes(), &analytics); err != nil {
+		t.Fatalf("decode analytics: %v", err)
+	}
+	summary := analytics.Data.Conversions
+	if summary.Conversions != 2 || summary.Value != 42.5 || summary.Rate < 0.66 || summary.Rate > 0.67 {
+		t.Fatalf("unexpected conversion summary %+v", summary)
+	}
+	byReferrer := analytics.Data.ConversionsByReferrer
+	if len(byReferrer) != 2 || byReferrer[0].Name != "search.example.net" || byReferrer[0].Clicks != 2 || byReferrer[0].Rate != 0.5 || byReferrer[1].Value != 30 {
+		t.Fatalf("unexpected conversions by referrer %+v", byReferrer)
+	}
+
+	var overview struct {
+		Data struct {
+			ConversionsByLink []links.ConversionBreakdown `json:"conversions_by_link"`
+		} `json:"data"`
+	}
+	recorder = performJSONRequest(router, http.MethodGet, "/admin/api/v1/analytics/overview", "", sessionCookie)
+	if err := json.Unmarshal(recorder.Body.Bytes(), &overview); err != nil {
+		t.Fatalf("decode overview: %v", err)
+	}
+	if len(overview.Data.ConversionsByLink) != 1 || overview.Data.ConversionsByLink[0].Name != "shop" || overview.Data.ConversionsByLink[0].Conversions != 2 {
+		t.Fatalf("unexpected conversions by link %+v", overview.Data.ConversionsByLink)
+	}
+}
+
+func TestWebhooksDeliverSignedLinkEvents(t *testing.T) {
+	type received struct {
+		header http.Header
+		body   []byte
+	}
+	var (
+		mu       sync.Mutex
+		requests []received
+	)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		requests = append(requests, received{header: r.Header.Clone(), body: body})
+		if len(requests) == 1 {
+			http.Error(w, "try again", http.StatusInternalServerError)
+		}
+	}))
+	t.Cleanup(receiver.Close)
+
+	router, hooks := newTestRouterWithWebhooks(t, nil, nil, []webhooks.Option{webhooks.WithRetryPolicy(3, time.Millisecond, time.Millisecond)})
+	sessionCookie := login(t, router)
+	deliver := func() {
+		t.Helper()
+		if _, err := hooks.DeliverDue(context.Background()); err != nil {
+			t.Fatalf("deliver webhooks: %v", err)
+		}
+	}
+
+	if recorder := performJSONRequest(router, http.MethodPost, "/admin/api/v1/webhooks", `{"url":"ftp://example.com","events":["link.created"]}`, sessionCookie); recorder.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for a non-http url, got %d", recorder.Code)
+	}
+	if recorder := performJSONRequest(router, http.MethodPost, "/admin/api/v1/webhooks", `{"url":"`+receiver.URL+`","events":["link.renamed"]}`, sessionCookie); recorder.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an unknown event, got %d", recorder.Code)
+	}
+
+	createRecorder := performJSONRequest(router, http.MethodPost, "/admin/api/v1/webhooks", `{"url":"`+receiver.URL+`","events":["link.created","link.clicked"]}`, sessionCookie)
+	if createRecorder.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d body=%s", createRecorder.Code, createRecorder.Body.String())
+	}
+	var created struct {
+		Data struct {
+			ID     int64  `json:"id"`
+			Secret string `json:"secret"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(createRecorder.Body.Bytes(), &created); err != nil || !strings.HasPrefix(created.Data.Secret, "whsec_") {
+		t.Fatalf("expected the secret in the create response, got %s", createRecorder.Body.String())
+	}
+	if listRecorder := performJSONRequest(router, http.MethodGet, "/admin/api/v1/webhooks", "", sessionCookie); strings.Contains(listRecorder.Body.String(), created.Data.Secret) {
+		t.Fatalf("expected the secret to be hidden afterwards, got %s", listRecorder.Body.String())
+	}
+
+	performJSONRequest(router, http.MethodPost, "/admin/api/v1/links", `{"code":"hooked","target_url":"https://example.com/hooked"}`, sessionCookie)
+	deliver()
+	time.Sleep(5 * time.Millisecond)
+	deliver()
+
+	mu.Lock()
+	if len(requests) != 2 {
+		mu.Unlock()
+		t.Fatalf("expected a failed attempt and a retry, got %d requests", len(requests))
+	}
+	retry := requests[1]
+	mu.Unlock()
+	if retry.header.Get(webhooks.HeaderEvent) != links.EventLinkCreated {
+		t.Fatalf("unexpected event header %q", retry.header.Get(webhooks.HeaderEvent))
+	}
+	if want := webhooks.Sign(created.Data.Secret, retry.header.Get(webhooks.HeaderTimestamp), retry.body); retry.header.Get(webhooks.HeaderSignature) != want {
+		t.Fatalf("signature mismatch: got %q want %q", retry.header.Get(webhooks.HeaderSignature), want)
+	}
+	var event struct {
+		Type string `json:"type"`
+		Data struct {
+			Link links.Link `json:"link"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(retry.body, &event); err != nil || event.Type != links.EventLinkCreated || event.Data.Link.Code != "hooked" {
+		t.Fatalf("unexpected payload %s", retry.body)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/hooked", nil)
+	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 Chrome/126.0 Safari/537.36")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	bot := httptest.NewRequest(http.MethodGet, "/hooked", nil)
+	bot.Header.Set("User-Agent", "Googlebot/2.1 (+http://www.google.com/bot.html)")
+	router.ServeHTTP(httptest.NewRecorder(), bot)
+	// Not subscribed to link.updated, so this is not sent.
+	performJSONRequest(router, http.MethodPut, "/admin/api/v1/links/1", `{"code":"hooked","target_url":"https://example.com/hooked","enabled":true}`, sessionCookie)
+	testRecorder := performJSONRequest(router, http.MethodPost, "/admin/api/v1/webhooks/"+strconv.FormatInt(created.Data.ID, 10)+"/test", "", sessionCookie)
+	if testRecorder.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d body=%s", testRecorder.Code, testRecorder.Body.String())
+	}
+	deliver()
+
+	var deliveries struct {
+		Data []webhooks.Delivery `json:"data"`
+	}
+	deliveriesRecorder := performJSONRequest(router, http.MethodGet, "/admin/api/v1/webhooks/"+strconv.FormatInt(created.Data.ID, 10)+"/deliveries", "", sessionCookie)
+	if err := json.Unmarshal(deliveriesRecorder.Body.Bytes(), &deliveries); err != nil {
+		t.Fatalf("decode deliveries: %v", err)
+	}
+	types := []string{}
+	for _, delivery := range deliveries.Data {
+		if delivery.Status != webhooks.StatusDelivered {
+			t.Fatalf("expected every delivery to be delivered, got %+v", delivery)
+		}
+		types = append(types, delivery.EventType)
+	}
+	// Clicks are buffered until the dispatcher runs, so the test event,
+	// queued directly, comes first.
+	if strings.Join(types, ",") != "link.clicked,webhook.test,link.created" || deliveries.Data[2].Attempts != 2 {
+		t.Fatalf("unexpected delivery log %+v", deliveries.Data)
+	}
+
+	if recorder := performJSONRequest(router, http.MethodDelete, "/admin/api/v1/webhooks/"+strconv.FormatInt(created.Data.ID, 10), "", sessionCookie); recorder.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", recorder.Code)
+	}
+	if recorder := performJSONRequest(router, http.MethodGet, "/admin/api/v1/webhooks/"+strconv.FormatInt(created.Data.ID, 10)+"/deliveries", "", sessionCookie); recorder.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 after delete, got %d", recorder.Code)
+	}
+}
+
+func TestExpiredLinksStopRedirecting(t *testing.T) {
+	router := newTestRouter(t)
+	sessionCookie := login(t, router)
+
+	if recorder := performJSONRequest(router, http.MethodPost, "/admin/api/v1/links", `{"code":"past","target_url":"https://example.com/past","expires_at":"2020-01-01T00:00:00Z"}`, sessionCookie); recorder.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an expiry in the past, got %d", recorder.Code)
+	}
+	expiresAt := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	if recorder := performJSONRequest(router, http.MethodPost, "/admin/api/v1/links", `{"code":"sale","target_url":"https://example.com/sale","expires_at":"`+expiresAt+`"}`, sessionCookie); recorder.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d body=%s", recorder.Code, recorder.Body.String())
+	}
+
+	redirect := func() int {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/sale", nil))
+		return recorder.Code
+	}
+	if code := redirect(); code != http.StatusFound {
+		t.Fatalf("expected 302 before expiry, got %d", code)
+	}
+
+	if recorder := performJSONRequest(router, http.MethodPut, "/admin/api/v1/links/1", `{"code":"sale","target_url":"https://example.com/sale","enabled":true,"expires_at":"2020-01-01T00:00:00Z"}`, sessionCookie); recorder.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an updated expiry in the past, got %d", recorder.Code)
+	}
+	// Toggling a link from the list does not send expires_at.
+	kept := performJSONRequest(router, http.MethodPut, "/admin/api/v1/links/1", `{"code":"sale","target_url":"https://example.com/sale","enabled":true}`, sessionCookie)
+	if kept.Code != http.StatusOK || strings.Contains(kept.Body.String(), `"expires_at":null`) {
+		t.Fatalf("expected update without expires_at to keep it, got %d body=%s", kept.Code, kept.Body.String())
+	}
+	cleared := performJSONRequest(router, http.MethodPut, "/admin/api/v1/links/1", `{"code":"sale","target_url":"https://example.com/sale","enabled":true,"expires_at":null}`, sessionCookie)
+	if cleared.Code != http.StatusOK || !strings.Contains(cleared.Body.String(), `"expires_at":null`) {
+		t.Fatalf("expected expires_at null to clear it, got %d body=%s", cleared.Code, cleared.Body.String())
+	}
+
+	soon := time.Now().Add(300 * time.Millisecond)
+	performJSONRequest(router, http.MethodPut, "/admin/api/v1/links/1", `{"code":"sale","target_url":"https://example.com/sale","enabled":true,"expires_at":"`+soon.UTC().Format(time.RFC3339Nano)+`"}`, sessionCookie)
+	time.Sleep(time.Until(soon) + 50*time.Millisecond)
+	if code := redirect(); code != http.StatusNotFound {
+		t.Fatalf("expected 404 after expiry, got %d", code)
+	}
+}
+
+func TestMetricsCountRedirectsAndAdminErrors(t *testing.T) {
+	router := newTestRouter(t)
+	sessionCookie := login(t, router)
+
+	performJSONRequest(router, http.MethodPost, "/admin/api/v1/links", `{"code":"counted","target_url":"https://example.com/counted"}`, sessionCookie)
+	performJSONRequest(router, http.MethodPost, "/admin/api/v1/links", `{"code":"paused","target_url":"https://example.com/paused"}`, sessionCookie)
+	performJSONRequest(router, http.MethodPut, "/admin/api/v1/links/2", `{"code":"paused","target_url":"https://example.com/paused","enabled":false}`, sessionCookie)
+	for _, path := range []string{"/counted", "/counted", "/paused", "/missing"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+	performJSONRequest(router, http.MethodGet, "/admin/api/v1/links/99/analytics", "", sessionCookie)
+	performJSONRequest(router, http.MethodGet, "/admin/api/v1/links", "", "")
+	if reserved := performJSONRequest(router, http.MethodPost, "/admin/api/v1/links", `{"code":"metrics","target_url":"https://example.com/metrics"}`, sessionCookie); reserved.Code != http.StatusBadRequest {
+		t.Fatalf("expected the metrics path to be reserved, got %d", reserved.Code)
+	}
+
+	unauthorized := httptest.NewRecorder()
+	router.ServeHTTP(unauthorized, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if unauthorized.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without the metrics token, got %d", unauthorized.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Authorization", "Bearer metrics-secret")
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", recorder.Code)
+	}
+	body := recorder.Body.String()
+	for _, want := range []string{
+		`shorturl_redirects_total{outcome="hit"} 2`,
+		`shorturl_redirects_total{outcome="disabled"} 1`,
+		`shorturl_redirects_total{outcome="not_found"} 1`,
+		`shorturl_admin_api_errors_total{code="not_found"} 1`,
+		`shorturl_admin_api_errors_total{code="unauthorized"} 1`,
+		`shorturl_admin_api_errors_total{code="invalid_request"} 1`,
+		`shorturl_http_request_duration_seconds_count{method="GET",route="/:code",status="302"} 2`,
+		`shorturl_db_query_duration_seconds_count{statement="insert"}`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("expected %q in metrics, got %s", want, body)
+		}
+	}
+}
+
+func TestRedirectSpansContinueIncomingTrace(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previousProvider, previousPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previousProvider)
+		otel.SetTextMapPropagator(previousPropagator)
+	})
+
+	router := newTestRouter(t)
+	sessionCookie := login(t, router)
+	performJSONRequest(router, http.MethodPost, "/admin/api/v1/links", `{"code":"traced","target_url":"https://example.com/traced"}`, sessionCookie)
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := httptest.NewRequest(http.MethodGet, "/traced", nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	var queries []sdktrace.ReadOnlySpan
+	for _, span := range recorder.Ended() {
+		if span.SpanContext().TraceID().String() != traceID {
+			continue
+		}
+		if strings.HasPrefix(span.Name(), "sqlite ") {
+			queries = append(queries, span)
+			continue
+		}
+		spans[span.Name()] = span
+	}
+	server, ok := spans["GET /:code"]
+	if !ok || server.Parent().SpanID().String() != "00f067aa0ba902b7" {
+		t.Fatalf("expected a server span continuing the incoming trace, got %v", spans)
+	}
+	resolve, ok := spans["links.Resolve"]
+	if !ok || resolve.Parent().SpanID() != server.SpanContext().SpanID() {
+		t.Fatalf("expected links.Resolve under the server span, got %v", spans)
+	}
+	operations := []string{}
+	for _, query := range queries {
+		if query.Parent().SpanID() != resolve.SpanContext().SpanID() {
+			t.Fatalf("expected queries under links.Resolve, got parent %s", query.Parent().SpanID())
+		}
+		operations = append(operations, query.Name())
+	}
+	if !slices.Contains(operations, "sqlite select") || !slices.Contains(operations, "sqlite insert") {
+		t.Fatalf("expected the lookup and the visit insert to be traced, got %v", operations)
+	}
+
+	var logs bytes.Buffer
+	logged := gin.New()
+	logged.Use(requestTracing(), requestLogger(slog.New(slog.NewTextHandler(&logs, nil))))
+	logged.GET("/ping", func(c *gin.Context) { c.Status(http.StatusNoContent) })
+	logReq := httptest.NewRequest(http.MethodGet, "/ping", nil)
+	logReq.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	logged.ServeHTTP(httptest.NewRecorder(), logReq)
+	if !strings.Contains(logs.String(), "trace_id="+traceID) || !strings.Contains(logs.String(), "span_id=") {
+		t.Fatalf("expected trace ids in the request log, got %s", logs.String())
+	}
+}
+
 func TestRedirectIncrementsClicksAndHonorsDisabled(t *testing.T) {
 	router := newTestRouter(t)
 	sessionCookie := login(t, router)
@@ -226,12 +1414,122 @@ func TestLinkAnalyticsIncludesVisitContext(t *testing.T) {
 	}
 }
 
+func TestOpenAPISpecCoversRoutes(t *testing.T) {
+	// With OIDC configured every admin route is registered.
+	router := newTestRouterWithOIDC(t, newMockOIDCProvider(t, nil).client(t, nil))
+
+	recorder := performJSONRequest(router, http.MethodGet, "/admin/api/v1/openapi.json", "", "")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", recorder.Code, recorder.Body.String())
+	}
+	var spec struct {
+		OpenAPI string                                `json:"openapi"`
+		Paths   map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &spec); err != nil {
+		t.Fatalf("decode spec: %v", err)
+	}
+	if !strings.HasPrefix(spec.OpenAPI, "3.") {
+		t.Fatalf("expected an OpenAPI 3 document, got %q", spec.OpenAPI)
+	}
+
+	const prefix = "/admin/api/v1"
+	registered := map[string]bool{}
+	for _, route := range router.Routes() {
+		path, ok := strings.CutPrefix(route.Path, prefix)
+		if !ok {
+			continue
+		}
+		segments := strings.Split(path, "/")
+		for i, segment := range segments {
+			if name, ok := strings.CutPrefix(segment, ":"); ok {
+				segments[i] = "{" + name + "}"
+			}
+		}
+		path = strings.Join(segments, "/")
+		method := strings.ToLower(route.Method)
+		registered[method+" "+path] = true
+
+		if _, ok := spec.Paths[path][method]; !ok {
+			t.Errorf("%s %s is not documented in openapi.json", route.Method, route.Path)
+		}
+	}
+	for path, operations := range spec.Paths {
+		for method := range operations {
+			if !registered[method+" "+path] {
+				t.Errorf("openapi.json documents %s %s, which is not registered", strings.ToUpper(method), prefix+path)
+			}
+		}
+	}
+}
+
+func TestOpenAPISpecListsErrorCodes(t *testing.T) {
+	var spec struct {
+		Components struct {
+			Schemas struct {
+				ErrorCode struct {
+					Enum []string `json:"enum"`
+				} `json:"ErrorCode"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(openAPISpec, &spec); err != nil {
+		t.Fatalf("decode spec: %v", err)
+	}
+
+	sources, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatalf("glob sources: %v", err)
+	}
+	codePattern := regexp.MustCompile(`(?:writeJSONError\(c, http\.\w+, |code :?= )"(\w+)"`)
+	for _, source := range sources {
+		if strings.HasSuffix(source, "_test.go") {
+			continue
+		}
+		content, err := os.ReadFile(source)
+		if err != nil {
+			t.Fatalf("read %s: %v", source, err)
+		}
+		for _, match := range codePattern.FindAllStringSubmatch(string(content), -1) {
+			if !slices.Contains(spec.Components.Schemas.ErrorCode.Enum, match[1]) {
+				t.Errorf("error code %q from %s is missing from the ErrorCode schema", match[1], source)
+			}
+		}
+	}
+}
+
 func newTestRouter(t *testing.T) *gin.Engine {
+	t.Helper()
+	return newTestRouterWithOIDC(t, nil)
+}
+
+func newTestRouterWithOIDC(t *testing.T, oidcLogin *auth.OIDC) *gin.Engine {
+	t.Helper()
+	return newTestRouterWithAuth(t, oidcLogin)
+}
+
+// newTestRouterWithAuth chains extraProviders after the local database provider.
+func newTestRouterWithAuth(t *testing.T, oidcLogin *auth.OIDC, extraProviders ...auth.Provider) *gin.Engine {
+	t.Helper()
+	return newTestRouterWithOptions(t, oidcLogin, nil, extraProviders...)
+}
+
+// newTestRouterWithOptions also applies linkOptions to the link service.
+func newTestRouterWithOptions(t *testing.T, oidcLogin *auth.OIDC, linkOptions []links.ServiceOption, extraProviders ...auth.Provider) *gin.Engine {
+	t.Helper()
+	router, _ := newTestRouterWithWebhooks(t, oidcLogin, linkOptions, nil, extraProviders...)
+	return router
+}
+
+// newTestRouterWithWebhooks also returns the webhook service so tests can run
+// its dispatcher by hand.
+func newTestRouterWithWebhooks(t *testing.T, oidcLogin *auth.OIDC, linkOptions []links.ServiceOption, hookOptions []webhooks.Option, extraProviders ...auth.Provider) (*gin.Engine, *webhooks.Service) {
 	t.Helper()
 	gin.SetMode(gin.TestMode)
 
+	serverMetrics := metrics.New()
 	dbPath := filepath.Join(t.TempDir(), "shorturl-test.db")
-	database, err := sqlite.Open(dbPath)
+	database, err := sqlite.Open(dbPath, sqlite.WithQueryObserver(serverMetrics.ObserveQuery))
 	if err != nil {
 		t.Fatalf("open db: %v", err)
 	}
@@ -247,14 +1545,27 @@ func newTestRouter(t *testing.T) *gin.Engine {
 		t.Fatalf("ensure admin: %v", err)
 	}
 
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	hooks := webhooks.NewService(sqlite.NewWebhookRepository(database), logger, hookOptions...)
+
 	linkRepo := sqlite.NewLinkRepository(database)
-	linkService := links.NewService(linkRepo)
+	visitStream := live.NewHub()
+	linkService := links.NewService(linkRepo, append([]links.ServiceOption{links.WithTrackedParams("gclid"), links.WithVisitPublisher(visitStream), links.WithLinkObserver(hooks)}, linkOptions...)...)
 
-	store := cookie.NewStore([]byte("01234567890123456789012345678901"))
+	store := sqlite.NewSessionStore(database, SessionUserKey, []byte("01234567890123456789012345678901"))
 	store.Options(sessionsOptions())
 
-	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
-	return NewRouter(logger, store, t.TempDir(), linkService, users)
+	throttlePolicy := auth.DefaultThrottlePolicy()
+	throttlePolicy.MaxUsernameFailures = 3
+	throttle := auth.NewThrottle(sqlite.NewLoginAttemptRepository(database), throttlePolicy)
+
+	auditLog := audit.NewService(sqlite.NewAuditRepository(database))
+
+	authChain := auth.NewChain(append([]auth.Provider{auth.NewLocalProvider(users)}, extraProviders...)...)
+
+	router := NewRouter(logger, store, t.TempDir(), linkService, users, authChain, throttle, oidcLogin, auditLog, visitStream, hooks, serverMetrics)
+	router.GET("/metrics", gin.WrapH(serverMetrics.Handler("metrics-secret")))
+	return router, hooks
 }
 
 func sessionsOptions() sessions.Options {
@@ -0,0 +1,74 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/mine/shorturl/internal/live"
+)
+
+const streamHeartbeatInterval = 15 * time.Second
+
+// streamVisitsHandler pushes visits as Server-Sent Events until the client
+// disconnects or the hub is closed on shutdown. Skipped events are reported as
+// a "dropped" event so the page knows it fell behind.
+func streamVisitsHandler(visitStream *live.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		filter := live.Filter{
+			Tag:         strings.TrimSpace(c.Query("tag")),
+			IncludeBots: c.Query("include_bots") == "true" || c.Query("include_bots") == "1",
+		}
+		if rawID := strings.TrimSpace(c.Query("link_id")); rawID != "" {
+			id, err := strconv.ParseInt(rawID, 10, 64)
+			if err != nil {
+				writeJSONError(c, http.StatusBadRequest, "invalid_request")
+				return
+			}
+			filter.LinkID = id
+		}
+
+		sub := visitStream.Subscribe(filter, live.DefaultBuffer)
+		defer sub.Close()
+
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("Connection", "keep-alive")
+		c.Header("X-Accel-Buffering", "no")
+		c.Status(http.StatusOK)
+		fmt.Fprint(c.Writer, "retry: 3000\n\n")
+		c.Writer.Flush()
+
+		heartbeat := time.NewTicker(streamHeartbeatInterval)
+		defer heartbeat.Stop()
+
+		var reported int64
+		for {
+			select {
+			case <-c.Request.Context().Done():
+				return
+			case <-heartbeat.C:
+				fmt.Fprint(c.Writer, ": ping\n\n")
+			case visit, ok := <-sub.Events():
+				if !ok {
+					return
+				}
+				if dropped := sub.Dropped(); dropped > reported {
+					fmt.Fprintf(c.Writer, "event: dropped\ndata: {\"count\":%d}\n\n", dropped-reported)
+					reported = dropped
+				}
+				payload, err := json.Marshal(visit)
+				if err != nil {
+					continue
+				}
+				fmt.Fprintf(c.Writer, "event: visit\ndata: %s\n\n", payload)
+			}
+			c.Writer.Flush()
+		}
+	}
+}
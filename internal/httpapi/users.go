@@ -0,0 +1,186 @@
+package httpapi
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+
+	"github.com/mine/shorturl/internal/audit"
+	"github.com/mine/shorturl/internal/auth"
+	"github.com/mine/shorturl/internal/links"
+)
+
+const minPasswordLength = 8
+
+type changePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+type updateUserRequest struct {
+	Role     string `json:"role"`
+	Disabled bool   `json:"disabled"`
+}
+
+func listSessionsHandler(sessionStore sessionBackend) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		result, err := sessionStore.ListUserSessions(c.Request.Context(), currentUser(c).Username, sessions.Default(c).ID())
+		if err != nil {
+			writeJSONError(c, http.StatusInternalServerError, "internal_error")
+			return
+		}
+		for index := range result {
+			result[index].Device = links.DescribeUserAgent(result[index].UserAgent)
+		}
+
+		c.JSON(http.StatusOK, apiResponse{
+			Success: true,
+			Data:    result,
+		})
+	}
+}
+
+func revokeSessionHandler(sessionStore sessionBackend, trail auditTrail) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil {
+			writeJSONError(c, http.StatusBadRequest, "invalid_request")
+			return
+		}
+
+		if err := sessionStore.RevokeUserSession(c.Request.Context(), currentUser(c).Username, id); err != nil {
+			if errors.Is(err, auth.ErrSessionNotFound) {
+				writeJSONError(c, http.StatusNotFound, "not_found")
+				return
+			}
+			writeJSONError(c, http.StatusInternalServerError, "internal_error")
+			return
+		}
+		trail.record(c, currentUser(c).Username, audit.ActionSessionRevoked, audit.TargetSession, strconv.FormatInt(id, 10), nil, nil)
+
+		c.JSON(http.StatusOK, apiResponse{
+			Success: true,
+			Data:    gin.H{"ok": true},
+		})
+	}
+}
+
+func revokeOtherSessionsHandler(sessionStore sessionBackend, trail auditTrail) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		revoked, err := sessionStore.RevokeUserSessions(c.Request.Context(), currentUser(c).Username, sessions.Default(c).ID())
+		if err != nil {
+			writeJSONError(c, http.StatusInternalServerError, "internal_error")
+			return
+		}
+		trail.record(c, currentUser(c).Username, audit.ActionSessionRevoked, audit.TargetSession, "others", nil, gin.H{"revoked": revoked})
+
+		c.JSON(http.StatusOK, apiResponse{
+			Success: true,
+			Data:    gin.H{"revoked": revoked},
+		})
+	}
+}
+
+func changePasswordHandler(users userStore, trail auditTrail) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request changePasswordRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			writeJSONError(c, http.StatusBadRequest, "invalid_request")
+			return
+		}
+
+		user := currentUser(c)
+		if user.Source != auth.SourceLocal || len(request.NewPassword) < minPasswordLength {
+			writeJSONError(c, http.StatusBadRequest, "invalid_request")
+			return
+		}
+
+		if err := users.ChangePassword(c.Request.Context(), user.Username, request.CurrentPassword, request.NewPassword); err != nil {
+			if errors.Is(err, auth.ErrInvalidCredentials) {
+				writeJSONError(c, http.StatusUnauthorized, "unauthorized")
+				return
+			}
+			writeJSONError(c, http.StatusInternalServerError, "internal_error")
+			return
+		}
+
+		// ChangePassword revoked every session; keep this one signed in.
+		session := sessions.Default(c)
+		session.Set(SessionUserKey, user.Username)
+		if err := session.Save(); err != nil {
+			writeJSONError(c, http.StatusInternalServerError, "internal_error")
+			return
+		}
+		trail.record(c, user.Username, audit.ActionPasswordChanged, audit.TargetUser, strconv.FormatInt(user.ID, 10), nil, nil)
+
+		c.JSON(http.StatusOK, apiResponse{
+			Success: true,
+			Data:    gin.H{"ok": true},
+		})
+	}
+}
+
+func listUsersHandler(users userStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		result, err := users.ListUsers(c.Request.Context())
+		if err != nil {
+			writeJSONError(c, http.StatusInternalServerError, "internal_error")
+			return
+		}
+
+		c.JSON(http.StatusOK, apiResponse{
+			Success: true,
+			Data:    result,
+		})
+	}
+}
+
+func updateUserHandler(users userStore, trail auditTrail) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil {
+			writeJSONError(c, http.StatusBadRequest, "invalid_request")
+			return
+		}
+
+		var request updateUserRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			writeJSONError(c, http.StatusBadRequest, "invalid_request")
+			return
+		}
+		role, ok := auth.ParseRole(request.Role)
+		if !ok {
+			writeJSONError(c, http.StatusBadRequest, "invalid_request")
+			return
+		}
+		if id == currentUser(c).ID && (request.Disabled || role != auth.RoleAdmin) {
+			writeJSONError(c, http.StatusBadRequest, "invalid_request")
+			return
+		}
+
+		before, err := users.GetUserByID(c.Request.Context(), id)
+		if err != nil {
+			if errors.Is(err, auth.ErrUserNotFound) {
+				writeJSONError(c, http.StatusNotFound, "not_found")
+				return
+			}
+			writeJSONError(c, http.StatusInternalServerError, "internal_error")
+			return
+		}
+
+		user, err := users.UpdateUser(c.Request.Context(), id, role, request.Disabled)
+		if err != nil {
+			writeJSONError(c, http.StatusInternalServerError, "internal_error")
+			return
+		}
+		trail.record(c, currentUser(c).Username, audit.ActionUserUpdated, audit.TargetUser, strconv.FormatInt(user.ID, 10), before, user)
+
+		c.JSON(http.StatusOK, apiResponse{
+			Success: true,
+			Data:    user,
+		})
+	}
+}
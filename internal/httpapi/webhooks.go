@@ -0,0 +1,183 @@
+package httpapi
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/mine/shorturl/internal/audit"
+	"github.com/mine/shorturl/internal/webhooks"
+)
+
+// createdWebhook is the only response that carries the signing secret.
+type createdWebhook struct {
+	webhooks.Subscription
+	Secret string `json:"secret"`
+}
+
+func listWebhooksHandler(hooks *webhooks.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		subs, err := hooks.List(c.Request.Context())
+		if err != nil {
+			writeWebhookError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, apiResponse{
+			Success: true,
+			Data:    subs,
+		})
+	}
+}
+
+func createWebhookHandler(hooks *webhooks.Service, trail auditTrail) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request webhooks.SubscriptionInput
+		if err := c.ShouldBindJSON(&request); err != nil {
+			writeJSONError(c, http.StatusBadRequest, "invalid_request")
+			return
+		}
+
+		sub, err := hooks.Create(c.Request.Context(), request)
+		if err != nil {
+			writeWebhookError(c, err)
+			return
+		}
+		trail.record(c, currentUser(c).Username, audit.ActionWebhookCreated, audit.TargetWebhook, strconv.FormatInt(sub.ID, 10), nil, sub)
+
+		c.JSON(http.StatusCreated, apiResponse{
+			Success: true,
+			Data:    createdWebhook{Subscription: sub, Secret: sub.Secret},
+		})
+	}
+}
+
+func updateWebhookHandler(hooks *webhooks.Service, trail auditTrail) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil {
+			writeJSONError(c, http.StatusBadRequest, "invalid_request")
+			return
+		}
+
+		var request webhooks.SubscriptionInput
+		if err := c.ShouldBindJSON(&request); err != nil {
+			writeJSONError(c, http.StatusBadRequest, "invalid_request")
+			return
+		}
+
+		before, err := hooks.Get(c.Request.Context(), id)
+		if err != nil {
+			writeWebhookError(c, err)
+			return
+		}
+
+		sub, err := hooks.Update(c.Request.Context(), id, request)
+		if err != nil {
+			writeWebhookError(c, err)
+			return
+		}
+		trail.record(c, currentUser(c).Username, audit.ActionWebhookUpdated, audit.TargetWebhook, strconv.FormatInt(sub.ID, 10), before, sub)
+
+		c.JSON(http.StatusOK, apiResponse{
+			Success: true,
+			Data:    sub,
+		})
+	}
+}
+
+func deleteWebhookHandler(hooks *webhooks.Service, trail auditTrail) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil {
+			writeJSONError(c, http.StatusBadRequest, "invalid_request")
+			return
+		}
+
+		before, err := hooks.Get(c.Request.Context(), id)
+		if err != nil {
+			writeWebhookError(c, err)
+			return
+		}
+
+		if err := hooks.Delete(c.Request.Context(), id); err != nil {
+			writeWebhookError(c, err)
+			return
+		}
+		trail.record(c, currentUser(c).Username, audit.ActionWebhookDeleted, audit.TargetWebhook, strconv.FormatInt(id, 10), before, nil)
+
+		c.JSON(http.StatusOK, apiResponse{
+			Success: true,
+			Data:    gin.H{"ok": true},
+		})
+	}
+}
+
+func listWebhookDeliveriesHandler(hooks *webhooks.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil {
+			writeJSONError(c, http.StatusBadRequest, "invalid_request")
+			return
+		}
+
+		limit := 0
+		if rawLimit := strings.TrimSpace(c.Query("limit")); rawLimit != "" {
+			if limit, err = strconv.Atoi(rawLimit); err != nil {
+				writeJSONError(c, http.StatusBadRequest, "invalid_request")
+				return
+			}
+		}
+
+		deliveries, err := hooks.Deliveries(c.Request.Context(), id, limit)
+		if err != nil {
+			writeWebhookError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, apiResponse{
+			Success: true,
+			Data:    deliveries,
+		})
+	}
+}
+
+func testWebhookHandler(hooks *webhooks.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil {
+			writeJSONError(c, http.StatusBadRequest, "invalid_request")
+			return
+		}
+
+		delivery, err := hooks.SendTest(c.Request.Context(), id)
+		if err != nil {
+			writeWebhookError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusAccepted, apiResponse{
+			Success: true,
+			Data:    delivery,
+		})
+	}
+}
+
+func writeWebhookError(c *gin.Context, err error) {
+	status := http.StatusInternalServerError
+	code := "internal_error"
+
+	switch {
+	case errors.Is(err, webhooks.ErrValidation):
+		status = http.StatusBadRequest
+		code = "invalid_request"
+	case errors.Is(err, webhooks.ErrNotFound):
+		status = http.StatusNotFound
+		code = "not_found"
+	}
+
+	writeJSONError(c, status, code)
+}
@@ -6,6 +6,8 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/mine/shorturl/internal/useragent"
 )
 
 const analyticsRecentVisitLimit = 20
@@ -22,6 +24,10 @@ func normalizeAnalyticsDays(days int) int {
 }
 
 func maskIP(raw string) string {
+	// Hashed addresses reveal nothing and are shown as stored.
+	if strings.HasPrefix(raw, hashedIPPrefix) {
+		return raw
+	}
 	ip := net.ParseIP(strings.TrimSpace(raw))
 	if ip == nil {
 		return ""
@@ -57,66 +63,60 @@ func extractRefererHost(raw string) string {
 	return parsed.Hostname()
 }
 
-func detectClient(userAgent string) (clientName string, clientType string, deviceType string, osName string) {
-	ua := strings.ToLower(strings.TrimSpace(userAgent))
-	if ua == "" {
-		return "未知客户端", "unknown", "unknown", "未知系统"
+// describeClient parses userAgent with agents and classifies the traffic.
+// Crawlers and link previews are named after the agent rather than whatever
+// browser they claim to be.
+func describeClient(agents *useragent.Parser, userAgent string, prefetch bool) ClientInfo {
+	if strings.TrimSpace(userAgent) == "" {
+		trafficType, _ := ClassifyTraffic(userAgent, prefetch)
+		return ClientInfo{ClientName: "未知客户端", ClientType: "unknown", DeviceType: "unknown", OS: "未知系统", TrafficType: trafficType}
 	}
 
-	deviceType = "desktop"
-	switch {
-	case strings.Contains(ua, "ipad"), strings.Contains(ua, "tablet"):
-		deviceType = "tablet"
-	case strings.Contains(ua, "mobile"), strings.Contains(ua, "iphone"), strings.Contains(ua, "android"):
-		deviceType = "mobile"
+	parsed := agents.Parse(userAgent)
+	info := ClientInfo{
+		ClientName:    parsed.Name,
+		ClientVersion: parsed.Version,
+		ClientType:    parsed.Type,
+		DeviceType:    parsed.DeviceType,
+		DeviceBrand:   parsed.DeviceBrand,
+		DeviceModel:   parsed.DeviceModel,
+		OS:            parsed.OS,
+		OSVersion:     parsed.OSVersion,
+	}
+	if info.ClientName == "" {
+		info.ClientName, info.ClientType = "未知客户端", "unknown"
+	}
+	if info.OS == "" {
+		info.OS = "未知系统"
 	}
 
-	switch {
-	case strings.Contains(ua, "windows"):
-		osName = "Windows"
-	case strings.Contains(ua, "android"):
-		osName = "Android"
-	case strings.Contains(ua, "iphone"), strings.Contains(ua, "ipad"), strings.Contains(ua, "ios"):
-		osName = "iOS"
-	case strings.Contains(ua, "mac os x"), strings.Contains(ua, "macintosh"):
-		osName = "macOS"
-	case strings.Contains(ua, "linux"):
-		osName = "Linux"
-	default:
-		osName = "未知系统"
+	var agentName string
+	info.TrafficType, agentName = ClassifyTraffic(userAgent, prefetch)
+	if info.TrafficType == TrafficBot || info.TrafficType == TrafficPreview {
+		info.ClientName, info.ClientVersion, info.ClientType = agentName, "", info.TrafficType
 	}
+	return info
+}
 
-	switch {
-	case strings.Contains(ua, "bot"), strings.Contains(ua, "spider"), strings.Contains(ua, "crawler"):
-		return "Bot", "bot", "bot", osName
-	case strings.Contains(ua, "micromessenger"):
-		return "微信", "app", deviceType, osName
-	case strings.Contains(ua, "weibo"):
-		return "微博", "app", deviceType, osName
-	case strings.Contains(ua, "douyin"):
-		return "抖音", "app", deviceType, osName
-	case strings.Contains(ua, "qq/"):
-		return "QQ", "app", deviceType, osName
-	case strings.Contains(ua, "postman"):
-		return "Postman", "tool", deviceType, osName
-	case strings.Contains(ua, "curl"):
-		return "curl", "tool", deviceType, osName
-	case strings.Contains(ua, "edg/"):
-		return "Edge", "browser", deviceType, osName
-	case strings.Contains(ua, "opr/"), strings.Contains(ua, "opera"):
-		return "Opera", "browser", deviceType, osName
-	case strings.Contains(ua, "firefox/"):
-		return "Firefox", "browser", deviceType, osName
-	case strings.Contains(ua, "chrome/") && !strings.Contains(ua, "edg/") && !strings.Contains(ua, "opr/"):
-		return "Chrome", "browser", deviceType, osName
-	case strings.Contains(ua, "safari/") && !strings.Contains(ua, "chrome/"):
-		return "Safari", "browser", deviceType, osName
-	default:
-		return "未知客户端", "unknown", deviceType, osName
+// DescribeUserAgent summarises a User-Agent for display, e.g. in the session
+// list.
+func DescribeUserAgent(userAgent string) string {
+	info := describeClient(useragent.Default(), userAgent, false)
+	return joinVersion(info.ClientName, info.ClientVersion) + " / " + joinVersion(info.OS, info.OSVersion) + " / " + info.DeviceType
+}
+
+func joinVersion(name string, version string) string {
+	if version == "" {
+		return name
 	}
+	return name + " " + version
 }
 
-func normalizeVisitMeta(meta VisitMeta) VisitMeta {
+const maxCampaignValueLength = 200
+
+var UTMParams = []string{"utm_source", "utm_medium", "utm_campaign", "utm_term", "utm_content"}
+
+func normalizeVisitMeta(meta VisitMeta, extraParams []string, agents *useragent.Parser) VisitMeta {
 	if meta.VisitedAt.IsZero() {
 		meta.VisitedAt = time.Now().UTC()
 	}
@@ -125,9 +125,54 @@ func normalizeVisitMeta(meta VisitMeta) VisitMeta {
 		meta.RefererHost = extractRefererHost(meta.Referer)
 	}
 	meta.UserAgent = strings.TrimSpace(meta.UserAgent)
-	if meta.ClientName == "" || meta.ClientType == "" || meta.DeviceType == "" || meta.OS == "" {
-		meta.ClientName, meta.ClientType, meta.DeviceType, meta.OS = detectClient(meta.UserAgent)
+	if !IsVisitorID(meta.VisitorID) {
+		meta.VisitorID = ""
+	}
+	client := describeClient(agents, meta.UserAgent, meta.Prefetch)
+	meta.ClientName, meta.ClientVersion, meta.ClientType = client.ClientName, client.ClientVersion, client.ClientType
+	meta.DeviceType, meta.DeviceBrand, meta.DeviceModel = client.DeviceType, client.DeviceBrand, client.DeviceModel
+	meta.OS, meta.OSVersion = client.OS, client.OSVersion
+	meta.TrafficType = client.TrafficType
+	meta.RulesVersion = agents.Version()
+	meta.Language = PrimaryLanguage(meta.Language)
+
+	if meta.Query != nil {
+		meta.UTMSource = campaignValue(meta.Query, "utm_source")
+		meta.UTMMedium = campaignValue(meta.Query, "utm_medium")
+		meta.UTMCampaign = campaignValue(meta.Query, "utm_campaign")
+		meta.UTMTerm = campaignValue(meta.Query, "utm_term")
+		meta.UTMContent = campaignValue(meta.Query, "utm_content")
+		for _, key := range extraParams {
+			if value := campaignValue(meta.Query, key); value != "" {
+				if meta.ExtraParams == nil {
+					meta.ExtraParams = make(map[string]string, len(extraParams))
+				}
+				meta.ExtraParams[key] = value
+			}
+		}
 	}
 
 	return meta
 }
+
+func campaignValue(query url.Values, key string) string {
+	value := strings.TrimSpace(query.Get(key))
+	if runes := []rune(value); len(runes) > maxCampaignValueLength {
+		value = string(runes[:maxCampaignValueLength])
+	}
+	return value
+}
+
+// IsTrackableParam limits extra tracked parameters to names that are safe to
+// use as JSON object keys in SQL paths.
+func IsTrackableParam(key string) bool {
+	if key == "" || len(key) > 64 {
+		return false
+	}
+	for _, r := range key {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-') {
+			return false
+		}
+	}
+	return true
+}
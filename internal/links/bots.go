@@ -0,0 +1,116 @@
+package links
+
+import "strings"
+
+// Traffic types stored on each visit. Only human visits count towards
+// click_count and the headline analytics unless bots are explicitly included.
+const (
+	TrafficHuman    = "human"
+	TrafficBot      = "bot"
+	TrafficPreview  = "preview"
+	TrafficPrefetch = "prefetch"
+)
+
+type trafficSignature struct {
+	// match is a lower-case substring of the User-Agent.
+	match string
+	// also, when set, must appear in the User-Agent as well.
+	also string
+	// token requires match to stand apart from the surrounding word, e.g.
+	// "bot/" or "-bot" but not "cubot".
+	token       bool
+	name        string
+	trafficType string
+}
+
+// trafficSignatures is checked in order, so specific unfurlers must come before
+// the generic "bot" / "spider" catch-alls. Keep entries lower-case.
+var trafficSignatures = []trafficSignature{
+	// Link previews in chat and social apps.
+	{match: "slackbot-linkexpanding", name: "Slack", trafficType: TrafficPreview},
+	{match: "slack-imgproxy", name: "Slack", trafficType: TrafficPreview},
+	{match: "discordbot", name: "Discord", trafficType: TrafficPreview},
+	{match: "telegrambot", name: "Telegram", trafficType: TrafficPreview},
+	{match: "twitterbot", name: "Twitter", trafficType: TrafficPreview},
+	{match: "facebookexternalhit", name: "Facebook", trafficType: TrafficPreview},
+	{match: "facebookcatalog", name: "Facebook", trafficType: TrafficPreview},
+	{match: "linkedinbot", name: "LinkedIn", trafficType: TrafficPreview},
+	{match: "whatsapp", name: "WhatsApp", trafficType: TrafficPreview},
+	{match: "skypeuripreview", name: "Skype", trafficType: TrafficPreview},
+	{match: "embedly", name: "Embedly", trafficType: TrafficPreview},
+	{match: "iframely", name: "Iframely", trafficType: TrafficPreview},
+	{match: "dingtalkbot", name: "钉钉", trafficType: TrafficPreview},
+	{match: "micromessenger", also: "preview", name: "微信", trafficType: TrafficPreview},
+
+	// Search engines and crawlers.
+	{match: "googlebot", name: "Googlebot", trafficType: TrafficBot},
+	{match: "bingbot", name: "Bingbot", trafficType: TrafficBot},
+	{match: "baiduspider", name: "Baiduspider", trafficType: TrafficBot},
+	{match: "yandexbot", name: "YandexBot", trafficType: TrafficBot},
+	{match: "duckduckbot", name: "DuckDuckBot", trafficType: TrafficBot},
+	{match: "sogou", name: "Sogou", trafficType: TrafficBot},
+	{match: "360spider", name: "360Spider", trafficType: TrafficBot},
+	{match: "bytespider", name: "Bytespider", trafficType: TrafficBot},
+	{match: "petalbot", name: "PetalBot", trafficType: TrafficBot},
+	{match: "applebot", name: "Applebot", trafficType: TrafficBot},
+	{match: "ahrefsbot", name: "AhrefsBot", trafficType: TrafficBot},
+	{match: "semrushbot", name: "SemrushBot", trafficType: TrafficBot},
+	{match: "gptbot", name: "GPTBot", trafficType: TrafficBot},
+	{match: "headlesschrome", name: "HeadlessChrome", trafficType: TrafficBot},
+	{match: "python-requests", name: "python-requests", trafficType: TrafficBot},
+	{match: "go-http-client", name: "Go-http-client", trafficType: TrafficBot},
+	{match: "wget", name: "Wget", trafficType: TrafficBot},
+	{match: "wechat", also: "spider", name: "微信爬虫", trafficType: TrafficBot},
+	{match: "wechat", also: "crawler", name: "微信爬虫", trafficType: TrafficBot},
+	{match: "bot", token: true, name: "Bot", trafficType: TrafficBot},
+	{match: "spider", name: "Bot", trafficType: TrafficBot},
+	{match: "crawler", name: "Bot", trafficType: TrafficBot},
+}
+
+// ClassifyTraffic reports whether a request comes from a person, a crawler, a
+// link-preview fetcher or a browser prefetch, with a display name for the
+// non-human cases.
+func ClassifyTraffic(userAgent string, prefetch bool) (trafficType string, name string) {
+	ua := strings.ToLower(strings.TrimSpace(userAgent))
+	for _, signature := range trafficSignatures {
+		if signature.matches(ua) {
+			return signature.trafficType, signature.name
+		}
+	}
+	if prefetch {
+		return TrafficPrefetch, "预加载"
+	}
+	return TrafficHuman, ""
+}
+
+func (s trafficSignature) matches(ua string) bool {
+	if s.also != "" && !strings.Contains(ua, s.also) {
+		return false
+	}
+	if !s.token {
+		return strings.Contains(ua, s.match)
+	}
+	for from := 0; ; {
+		i := strings.Index(ua[from:], s.match)
+		if i < 0 {
+			return false
+		}
+		start, end := from+i, from+i+len(s.match)
+		if (start > 0 && strings.IndexByte(" -_(;", ua[start-1]) >= 0) || end == len(ua) || strings.IndexByte("/;)", ua[end]) >= 0 {
+			return true
+		}
+		from = end
+	}
+}
+
+// IsPrefetchRequest detects speculative loads that never reach the visitor
+// from the prefetch / prerender hints browsers send.
+func IsPrefetchRequest(secPurpose string, purpose string, xMoz string) bool {
+	for _, header := range []string{secPurpose, purpose, xMoz} {
+		header = strings.ToLower(header)
+		if strings.Contains(header, "prefetch") || strings.Contains(header, "prerender") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,27 @@
+package links
+
+import "testing"
+
+func TestClassifyTraffic(t *testing.T) {
+	tests := []struct {
+		userAgent   string
+		trafficType string
+		name        string
+	}{
+		{"Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 Mobile/15E148 MicroMessenger/8.0.49(0x18003129) NetType/WIFI Language/zh_CN", TrafficHuman, ""},
+		{"Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 Mobile/15E148 MicroMessenger/8.0.49 Preview", TrafficPreview, "微信"},
+		{"Mozilla/5.0 (compatible; WeChat Spider)", TrafficBot, "微信爬虫"},
+		{"WeChat-Crawler/1.0", TrafficBot, "微信爬虫"},
+		{"Mozilla/5.0 (Linux; Android 10; CUBOT KINGKONG 5 Pro) AppleWebKit/537.36 Chrome/120.0 Mobile Safari/537.36", TrafficHuman, ""},
+		{"Mozilla/5.0 (compatible; SiteAuditBot/0.97; +http://www.semrush.com/bot.html)", TrafficBot, "Bot"},
+		{"uptime-bot 2.0", TrafficBot, "Bot"},
+		{"Mozilla/5.0 (compatible; MyBot)", TrafficBot, "Bot"},
+		{"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)", TrafficBot, "Googlebot"},
+	}
+	for _, test := range tests {
+		trafficType, name := ClassifyTraffic(test.userAgent, false)
+		if trafficType != test.trafficType || name != test.name {
+			t.Errorf("%q: got %s/%q want %s/%q", test.userAgent, trafficType, name, test.trafficType, test.name)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package links
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// BreakdownDimensions lists the visit attributes Breakdown can group and
+// filter by. Storage backends map each name to a column or expression.
+var BreakdownDimensions = []string{
+	"link",
+	"referer_host",
+	"client",
+	"client_version",
+	"client_type",
+	"device",
+	"device_model",
+	"os",
+	"os_version",
+	"language",
+	"country",
+	"city",
+	"traffic_type",
+	"utm_source",
+	"utm_medium",
+	"utm_campaign",
+	"utm_term",
+	"utm_content",
+}
+
+const (
+	defaultBreakdownLimit = 10
+	maxBreakdownLimit     = 100
+	maxBreakdownFilters   = 4
+)
+
+// DimensionFilter keeps visits whose Dimension reads Value. Value is compared
+// with the names Breakdown returns, so a row picked in the UI (including
+// fallbacks such as "直接访问") can be used as a filter as is.
+type DimensionFilter struct {
+	Dimension string `json:"dimension"`
+	Value     string `json:"value"`
+}
+
+// BreakdownRequest groups the visits of one link, of the links carrying Tag,
+// or of every link when neither is set.
+type BreakdownRequest struct {
+	LinkID    int64
+	Tag       string
+	Dimension string
+	Filters   []DimensionFilter
+	Limit     int
+}
+
+type Breakdown struct {
+	AnalyticsRange
+	Dimension string            `json:"dimension"`
+	Filters   []DimensionFilter `json:"filters"`
+	// Total counts every visit matching the filters, including the ones past
+	// the limit.
+	Total int64            `json:"total"`
+	Items []VisitBreakdown `json:"items"`
+}
+
+// ParseDimensionFilter reads the "dimension:value" form used in query strings.
+func ParseDimensionFilter(raw string) (DimensionFilter, error) {
+	dimension, value, ok := strings.Cut(raw, ":")
+	if !ok {
+		return DimensionFilter{}, fmt.Errorf("%w: filter must be dimension:value", ErrValidation)
+	}
+	return DimensionFilter{Dimension: strings.TrimSpace(dimension), Value: strings.TrimSpace(value)}, nil
+}
+
+func IsBreakdownDimension(name string) bool {
+	return slices.Contains(BreakdownDimensions, name)
+}
+
+func (s *Service) Breakdown(ctx context.Context, request BreakdownRequest, query AnalyticsQuery) (_ Breakdown, err error) {
+	ctx, span := startSpan(ctx, "Breakdown", attribute.String("breakdown.dimension", request.Dimension))
+	defer func() { endSpan(span, err) }()
+
+	request.Dimension = strings.TrimSpace(request.Dimension)
+	request.Tag = strings.TrimSpace(request.Tag)
+	if !IsBreakdownDimension(request.Dimension) {
+		return Breakdown{}, fmt.Errorf("%w: unknown dimension %q", ErrValidation, request.Dimension)
+	}
+	if request.LinkID != 0 && request.Tag != "" {
+		return Breakdown{}, fmt.Errorf("%w: link_id and tag are mutually exclusive", ErrValidation)
+	}
+	if len(request.Filters) > maxBreakdownFilters {
+		return Breakdown{}, fmt.Errorf("%w: at most %d filters", ErrValidation, maxBreakdownFilters)
+	}
+	for _, filter := range request.Filters {
+		if !IsBreakdownDimension(filter.Dimension) {
+			return Breakdown{}, fmt.Errorf("%w: unknown filter dimension %q", ErrValidation, filter.Dimension)
+		}
+	}
+	switch {
+	case request.Limit == 0:
+		request.Limit = defaultBreakdownLimit
+	case request.Limit < 0 || request.Limit > maxBreakdownLimit:
+		return Breakdown{}, fmt.Errorf("%w: limit must be between 1 and %d", ErrValidation, maxBreakdownLimit)
+	}
+	if request.LinkID != 0 {
+		if _, err := s.repo.GetLinkByID(ctx, request.LinkID); err != nil {
+			return Breakdown{}, err
+		}
+	}
+
+	window, err := resolveAnalyticsWindow(query, time.Now(), s.location)
+	if err != nil {
+		return Breakdown{}, err
+	}
+
+	breakdown, err := s.repo.GetBreakdown(ctx, request, window)
+	if err != nil {
+		return Breakdown{}, err
+	}
+	breakdown.AnalyticsRange = window.Range()
+	breakdown.Dimension = request.Dimension
+	breakdown.Filters = request.Filters
+	if breakdown.Filters == nil {
+		breakdown.Filters = []DimensionFilter{}
+	}
+	return breakdown, nil
+}
@@ -0,0 +1,67 @@
+package links
+
+import (
+	"math"
+	"time"
+)
+
+// Delta compares one metric between the current and the comparison window.
+// Percent is nil when the comparison value is 0.
+type Delta struct {
+	Current  int64    `json:"current"`
+	Previous int64    `json:"previous"`
+	Change   int64    `json:"change"`
+	Percent  *float64 `json:"percent"`
+}
+
+type BreakdownDelta struct {
+	Name string `json:"name"`
+	Delta
+}
+
+// AnalyticsComparison holds the comparison window's metrics. TimeSeries lines
+// up index by index with LinkAnalytics.TimeSeries and keeps its own labels.
+type AnalyticsComparison struct {
+	From           time.Time        `json:"from"`
+	To             time.Time        `json:"to"`
+	Clicks         Delta            `json:"clicks"`
+	UniqueVisitors Delta            `json:"unique_visitors"`
+	TimeSeries     []VisitPoint     `json:"time_series"`
+	TopReferrers   []BreakdownDelta `json:"top_referrers"`
+	TopClients     []BreakdownDelta `json:"top_clients"`
+}
+
+// PeriodMetrics is what the repository reports for a comparison window.
+// Referrers and Clients hold counts only for the names that were asked for.
+type PeriodMetrics struct {
+	Clicks         int64
+	UniqueVisitors int64
+	TimeSeries     []VisitPoint
+	Referrers      map[string]int64
+	Clients        map[string]int64
+}
+
+func newDelta(current int64, previous int64) Delta {
+	delta := Delta{Current: current, Previous: previous, Change: current - previous}
+	if previous != 0 {
+		percent := math.Round(float64(delta.Change)/float64(previous)*1000) / 10
+		delta.Percent = &percent
+	}
+	return delta
+}
+
+func breakdownDeltas(current []VisitBreakdown, previous map[string]int64) []BreakdownDelta {
+	deltas := make([]BreakdownDelta, 0, len(current))
+	for _, item := range current {
+		deltas = append(deltas, BreakdownDelta{Name: item.Name, Delta: newDelta(item.Count, previous[item.Name])})
+	}
+	return deltas
+}
+
+func breakdownNames(items []VisitBreakdown) []string {
+	names := make([]string, 0, len(items))
+	for _, item := range items {
+		names = append(names, item.Name)
+	}
+	return names
+}
@@ -0,0 +1,139 @@
+package links
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var ErrClickNotFound = errors.New("click not found")
+
+// ConversionInput attributes a conversion to the click id handed out on
+// redirect. Value is optional, e.g. an order amount.
+type ConversionInput struct {
+	ClickID     string    `json:"click_id" form:"click_id"`
+	Value       float64   `json:"value" form:"value"`
+	ConvertedAt time.Time `json:"-" form:"-"`
+}
+
+// ConversionSummary is attributed by click time: conversions of the clicks
+// that happened in the window, whenever they converted.
+type ConversionSummary struct {
+	Conversions int64   `json:"conversions"`
+	Rate        float64 `json:"rate"`
+	Value       float64 `json:"value"`
+}
+
+type ConversionBreakdown struct {
+	Name        string  `json:"name"`
+	Clicks      int64   `json:"clicks"`
+	Conversions int64   `json:"conversions"`
+	Rate        float64 `json:"rate"`
+	Value       float64 `json:"value"`
+}
+
+// WithClickIDParam appends a fresh click id to the target URL of tracked human
+// visits under this query parameter, so the landing page can report
+// conversions back. Unsafe names are ignored.
+func WithClickIDParam(name string) ServiceOption {
+	return func(s *Service) {
+		if name = strings.TrimSpace(name); IsTrackableParam(name) {
+			s.clickIDParam = name
+		}
+	}
+}
+
+// WithPostbackToken requires server-to-server postbacks to present token.
+func WithPostbackToken(token string) ServiceOption {
+	return func(s *Service) {
+		s.postbackToken = strings.TrimSpace(token)
+	}
+}
+
+// PostbackAllowed checks a postback's bearer token. Without a configured
+// token every postback is accepted.
+func (s *Service) PostbackAllowed(token string) bool {
+	if s.postbackToken == "" {
+		return true
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.postbackToken)) == 1
+}
+
+// RecordConversion attributes a conversion to its click. Each click converts
+// at most once; recorded is false for repeats so postbacks can be retried.
+func (s *Service) RecordConversion(ctx context.Context, input ConversionInput) (recorded bool, err error) {
+	ctx, span := startSpan(ctx, "RecordConversion")
+	defer func() { endSpan(span, err) }()
+
+	input.ClickID = strings.TrimSpace(input.ClickID)
+	if !IsClickID(input.ClickID) {
+		return false, fmt.Errorf("%w: invalid click_id", ErrValidation)
+	}
+	if input.Value < 0 || math.IsNaN(input.Value) || math.IsInf(input.Value, 0) {
+		return false, fmt.Errorf("%w: invalid value", ErrValidation)
+	}
+	if input.ConvertedAt.IsZero() {
+		input.ConvertedAt = time.Now().UTC()
+	}
+	return s.repo.RecordConversion(ctx, input)
+}
+
+func IsClickID(raw string) bool {
+	return isRandomID(raw)
+}
+
+func newClickID() string {
+	buf := make([]byte, 16)
+	_, _ = rand.Read(buf)
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+// withClickID sets param on target, replacing any value already there. The
+// rest of the query is left untouched since some landing pages depend on its
+// exact order or encoding.
+func withClickID(target string, param string, clickID string) string {
+	parsed, err := url.Parse(target)
+	if err != nil {
+		return target
+	}
+	pair := url.QueryEscape(param) + "=" + url.QueryEscape(clickID)
+	if parsed.RawQuery == "" {
+		parsed.RawQuery = pair
+		return parsed.String()
+	}
+
+	parts := strings.Split(parsed.RawQuery, "&")
+	kept := parts[:0]
+	replaced := false
+	for _, part := range parts {
+		key, _, _ := strings.Cut(part, "=")
+		if unescaped, err := url.QueryUnescape(key); err != nil || unescaped != param {
+			kept = append(kept, part)
+			continue
+		}
+		if !replaced {
+			kept = append(kept, pair)
+			replaced = true
+		}
+	}
+	if !replaced {
+		kept = append(kept, pair)
+	}
+	parsed.RawQuery = strings.Join(kept, "&")
+	return parsed.String()
+}
+
+// ConversionRate is conversions per click, 0 without clicks.
+func ConversionRate(conversions int64, clicks int64) float64 {
+	if clicks == 0 {
+		return 0
+	}
+	return float64(conversions) / float64(clicks)
+}
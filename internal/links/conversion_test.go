@@ -0,0 +1,19 @@
+package links
+
+import "testing"
+
+func TestWithClickIDKeepsTheRestOfTheQuery(t *testing.T) {
+	tests := []struct {
+		target string
+		want   string
+	}{
+		{"https://example.com/landing", "https://example.com/landing?sid=abc"},
+		{"https://example.com/landing?z=1&a=%2f", "https://example.com/landing?z=1&a=%2f&sid=abc"},
+		{"https://example.com/landing?z=1&sid=old&a=x+y&sid=older#top", "https://example.com/landing?z=1&sid=abc&a=x+y#top"},
+	}
+	for _, test := range tests {
+		if got := withClickID(test.target, "sid", "abc"); got != test.want {
+			t.Errorf("withClickID(%q) = %q, want %q", test.target, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package links
+
+import (
+	"context"
+	"time"
+)
+
+// Link event types reported to a LinkObserver.
+const (
+	EventLinkCreated = "link.created"
+	EventLinkUpdated = "link.updated"
+	EventLinkDeleted = "link.deleted"
+	EventLinkClicked = "link.clicked"
+	EventLinkExpired = "link.expired"
+)
+
+// LinkEvent describes something that happened to a link. Visit is only set for
+// link.clicked, which is reported for human visits.
+type LinkEvent struct {
+	Type       string
+	Link       Link
+	Visit      *LiveVisit
+	OccurredAt time.Time
+}
+
+// LinkObserver is told about link events after they are stored. Observe runs
+// on the request path, redirects included, so it should only queue work.
+type LinkObserver interface {
+	Observe(ctx context.Context, event LinkEvent)
+}
+
+func WithLinkObserver(observer LinkObserver) ServiceOption {
+	return func(s *Service) {
+		s.observer = observer
+	}
+}
+
+func (s *Service) notify(ctx context.Context, eventType string, link Link, visit *LiveVisit) {
+	if s.observer == nil {
+		return
+	}
+	s.observer.Observe(ctx, LinkEvent{Type: eventType, Link: link, Visit: visit, OccurredAt: time.Now().UTC()})
+}
+
+// ExpireLinks reports link.expired once for every link whose expires_at has
+// passed. Redirects stop at expires_at regardless; this only drives the event.
+func (s *Service) ExpireLinks(ctx context.Context) (_ int, err error) {
+	ctx, span := startSpan(ctx, "ExpireLinks")
+	defer func() { endSpan(span, err) }()
+
+	expired, err := s.repo.ClaimExpiredLinks(ctx, time.Now().UTC())
+	if err != nil {
+		return 0, err
+	}
+	for _, link := range expired {
+		s.notify(ctx, EventLinkExpired, link, nil)
+	}
+	return len(expired), nil
+}
@@ -0,0 +1,75 @@
+package links
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// VisitExportFilter selects the visits of one link or of every link carrying a
+// tag. RawIP exports full addresses instead of MaskVisitIP values.
+type VisitExportFilter struct {
+	LinkID int64
+	Tag    string
+	RawIP  bool
+}
+
+type ExportedVisit struct {
+	VisitedAt     time.Time         `json:"visited_at"`
+	LinkID        int64             `json:"link_id"`
+	Code          string            `json:"code"`
+	IP            string            `json:"ip"`
+	VisitorID     string            `json:"visitor_id"`
+	TrafficType   string            `json:"traffic_type"`
+	Referer       string            `json:"referer"`
+	RefererHost   string            `json:"referer_host"`
+	UserAgent     string            `json:"user_agent"`
+	ClientName    string            `json:"client_name"`
+	ClientVersion string            `json:"client_version"`
+	ClientType    string            `json:"client_type"`
+	DeviceType    string            `json:"device_type"`
+	DeviceBrand   string            `json:"device_brand"`
+	DeviceModel   string            `json:"device_model"`
+	OS            string            `json:"os"`
+	OSVersion     string            `json:"os_version"`
+	Language      string            `json:"language"`
+	UTMSource     string            `json:"utm_source"`
+	UTMMedium     string            `json:"utm_medium"`
+	UTMCampaign   string            `json:"utm_campaign"`
+	UTMTerm       string            `json:"utm_term"`
+	UTMContent    string            `json:"utm_content"`
+	ExtraParams   map[string]string `json:"extra_params"`
+	GeoLocation
+}
+
+// ExportVisits streams matching visits oldest first, calling fn once per row,
+// so large exports never sit in memory. The window follows AnalyticsQuery but
+// is always resolved at day granularity.
+func (s *Service) ExportVisits(ctx context.Context, filter VisitExportFilter, query AnalyticsQuery, fn func(ExportedVisit) error) (err error) {
+	ctx, span := startSpan(ctx, "ExportVisits")
+	defer func() { endSpan(span, err) }()
+
+	filter.Tag = strings.TrimSpace(filter.Tag)
+	if (filter.LinkID == 0) == (filter.Tag == "") {
+		return fmt.Errorf("%w: exactly one of link_id and tag is required", ErrValidation)
+	}
+	if filter.LinkID != 0 {
+		if _, err := s.repo.GetLinkByID(ctx, filter.LinkID); err != nil {
+			return err
+		}
+	}
+
+	query.Granularity = string(GranularityDay)
+	window, err := resolveAnalyticsWindow(query, time.Now(), s.location)
+	if err != nil {
+		return err
+	}
+
+	return s.repo.StreamVisits(ctx, filter, window, func(visit ExportedVisit) error {
+		if !filter.RawIP {
+			visit.IP = maskIP(visit.IP)
+		}
+		return fn(visit)
+	})
+}
@@ -0,0 +1,86 @@
+package links
+
+import (
+	"strconv"
+	"strings"
+)
+
+// PrimaryLanguage picks the preferred tag from an Accept-Language header and
+// normalises it to base[-Script][-REGION], e.g. "zh-Hant-TW". Ties keep the
+// first tag, matching header order. Wildcards and malformed tags are skipped.
+func PrimaryLanguage(header string) string {
+	var (
+		best    string
+		bestQ   float64
+		entries = strings.Split(header, ",")
+	)
+	if len(entries) > 32 {
+		entries = entries[:32]
+	}
+	for _, entry := range entries {
+		rawTag, params, _ := strings.Cut(entry, ";")
+		tag := normalizeLanguageTag(rawTag)
+		if tag == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			key, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+			if !ok || !strings.EqualFold(key, "q") {
+				continue
+			}
+			parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil || parsed < 0 || parsed > 1 {
+				parsed = 0
+			}
+			q = parsed
+		}
+		if q > bestQ {
+			best, bestQ = tag, q
+		}
+	}
+	return best
+}
+
+// LanguageBase returns the base language of a normalised tag ("zh" for
+// "zh-CN").
+func LanguageBase(tag string) string {
+	base, _, _ := strings.Cut(tag, "-")
+	return base
+}
+
+func normalizeLanguageTag(raw string) string {
+	subtags := strings.FieldsFunc(strings.TrimSpace(raw), func(r rune) bool { return r == '-' || r == '_' })
+	if len(subtags) == 0 || !isAlpha(subtags[0]) || len(subtags[0]) < 2 || len(subtags[0]) > 3 {
+		return ""
+	}
+
+	parts := []string{strings.ToLower(subtags[0])}
+	rest := subtags[1:]
+	if len(rest) > 0 && len(rest[0]) == 4 && isAlpha(rest[0]) {
+		parts = append(parts, strings.ToUpper(rest[0][:1])+strings.ToLower(rest[0][1:]))
+		rest = rest[1:]
+	}
+	if len(rest) > 0 && (len(rest[0]) == 2 && isAlpha(rest[0]) || len(rest[0]) == 3 && isDigits(rest[0])) {
+		parts = append(parts, strings.ToUpper(rest[0]))
+	}
+	return strings.Join(parts, "-")
+}
+
+func isAlpha(value string) bool {
+	for _, r := range value {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z') {
+			return false
+		}
+	}
+	return value != ""
+}
+
+func isDigits(value string) bool {
+	for _, r := range value {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return value != ""
+}
@@ -0,0 +1,28 @@
+package links
+
+import "testing"
+
+func TestPrimaryLanguage(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"zh-CN,zh;q=0.9,en;q=0.8", "zh-CN"},
+		{"en;q=0.5, de-de;q=0.9", "de-DE"},
+		{"zh_hant_tw", "zh-Hant-TW"},
+		{"es-419,es;q=0.9", "es-419"},
+		{"*, fr;q=0.4", "fr"},
+		{"en-US-x-private", "en-US"},
+		{"fr;q=0, it;q=abc", ""},
+		{"12-34, ", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := PrimaryLanguage(tt.header); got != tt.want {
+			t.Errorf("PrimaryLanguage(%q) = %q, want %q", tt.header, got, tt.want)
+		}
+	}
+	if base := LanguageBase("zh-Hant-TW"); base != "zh" {
+		t.Fatalf("expected base zh, got %q", base)
+	}
+}
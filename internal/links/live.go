@@ -0,0 +1,48 @@
+package links
+
+import (
+	"slices"
+	"time"
+)
+
+// LiveVisit is the privacy-safe view of a recorded visit pushed to live
+// dashboards.
+type LiveVisit struct {
+	LinkID      int64     `json:"link_id"`
+	Code        string    `json:"code"`
+	Tags        []string  `json:"tags"`
+	VisitedAt   time.Time `json:"visited_at"`
+	IPMasked    string    `json:"ip_masked"`
+	RefererHost string    `json:"referer_host"`
+	ClientName  string    `json:"client_name"`
+	DeviceType  string    `json:"device_type"`
+	OS          string    `json:"os"`
+	CountryCode string    `json:"country_code,omitempty"`
+	TrafficType string    `json:"traffic_type"`
+}
+
+func (v LiveVisit) HasTag(tag string) bool {
+	return slices.Contains(v.Tags, tag)
+}
+
+// VisitPublisher receives every visit recorded by Resolve. Publish runs on the
+// redirect path and must not block.
+type VisitPublisher interface {
+	Publish(visit LiveVisit)
+}
+
+func newLiveVisit(link Link, visit VisitMeta) LiveVisit {
+	return LiveVisit{
+		LinkID:      link.ID,
+		Code:        link.Code,
+		Tags:        link.Tags,
+		VisitedAt:   visit.VisitedAt,
+		IPMasked:    maskIP(visit.IP),
+		RefererHost: visit.RefererHost,
+		ClientName:  visit.ClientName,
+		DeviceType:  visit.DeviceType,
+		OS:          visit.OS,
+		CountryCode: visit.Geo.CountryCode,
+		TrafficType: visit.TrafficType,
+	}
+}
@@ -0,0 +1,18 @@
+package links
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLiveVisitShowsStoredAddressForEveryIPMode(t *testing.T) {
+	visitedAt := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	for mode, want := range map[IPMode]string{IPModeRaw: "203.0.113.*", IPModeTruncate: "203.0.113.*", IPModeHash: hashedIPPrefix} {
+		s := NewService(nil, WithIPMode(mode))
+		visit := newLiveVisit(Link{ID: 1, Code: "live"}, VisitMeta{VisitedAt: visitedAt, IP: s.storedIP("203.0.113.7", visitedAt)})
+		if !strings.HasPrefix(visit.IPMasked, want) || strings.Contains(visit.IPMasked, "203.0.113.7") {
+			t.Errorf("%s: unexpected ip_masked %q", mode, visit.IPMasked)
+		}
+	}
+}
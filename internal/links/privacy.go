@@ -0,0 +1,139 @@
+package links
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// IPMode controls what link_visits.ip holds.
+type IPMode string
+
+const (
+	// IPModeRaw stores the address as received; it is masked on read.
+	IPModeRaw IPMode = "raw"
+	// IPModeTruncate zeroes the host part (/24 for IPv4, /48 for IPv6) before
+	// storing.
+	IPModeTruncate IPMode = "truncate"
+	// IPModeHash stores a keyed hash whose salt rotates every UTC day, so the
+	// same address cannot be linked across days.
+	IPModeHash IPMode = "hash"
+)
+
+const hashedIPPrefix = "h:"
+
+func ParseIPMode(raw string) (IPMode, error) {
+	switch mode := IPMode(strings.ToLower(strings.TrimSpace(raw))); mode {
+	case "":
+		return IPModeRaw, nil
+	case IPModeRaw, IPModeTruncate, IPModeHash:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("%w: unknown ip mode %q", ErrValidation, raw)
+	}
+}
+
+// DoNotTrack reports whether the visitor asked not to be tracked through the
+// DNT or Sec-GPC header.
+func DoNotTrack(dnt string, gpc string) bool {
+	return strings.TrimSpace(dnt) == "1" || strings.TrimSpace(gpc) == "1"
+}
+
+// storedIP applies the configured IPMode to a visit's address.
+func (s *Service) storedIP(raw string, visitedAt time.Time) string {
+	ip := net.ParseIP(strings.TrimSpace(raw))
+	if ip == nil {
+		return ""
+	}
+	switch s.ipMode {
+	case IPModeTruncate:
+		return truncateIP(ip)
+	case IPModeHash:
+		return s.hashIP(ip, visitedAt)
+	default:
+		return ip.String()
+	}
+}
+
+func truncateIP(ip net.IP) string {
+	if v4 := ip.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return ip.Mask(net.CIDRMask(48, 128)).String()
+}
+
+func (s *Service) hashIP(ip net.IP, visitedAt time.Time) string {
+	mac := hmac.New(sha256.New, s.dailySalt("ip-salt", visitedAt))
+	mac.Write([]byte(ip.String()))
+	return hashedIPPrefix + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))[:visitorIDLength]
+}
+
+// anonymousVisit keeps only what aggregate counts need: when the visit
+// happened and what kind of traffic it was.
+func anonymousVisit(meta VisitMeta) VisitMeta {
+	return VisitMeta{
+		VisitedAt:    meta.VisitedAt,
+		ClientName:   "未知客户端",
+		ClientType:   "unknown",
+		DeviceType:   "unknown",
+		OS:           "未知系统",
+		RulesVersion: meta.RulesVersion,
+		TrafficType:  meta.TrafficType,
+		DoNotTrack:   true,
+	}
+}
+
+// VisitErasure names the visitor whose visits should be deleted. At least one
+// field is required.
+type VisitErasure struct {
+	IP        string `json:"ip"`
+	VisitorID string `json:"visitor_id"`
+}
+
+// ErasureMatch is what the repository deletes: visits whose ip is one of IPs
+// or equals HashIP for the visit's UTC day, or whose visitor_id matches.
+type ErasureMatch struct {
+	IPs       []string
+	HashIP    func(day time.Time) string
+	VisitorID string
+}
+
+// EraseVisits deletes every visit recorded for an IP address or visitor id and
+// reports how many were removed. Hashed addresses are matched day by day, so
+// erasure works whichever IPMode was in effect. Truncated addresses are shared
+// by many visitors and are not matched. click_count is left as it is.
+func (s *Service) EraseVisits(ctx context.Context, request VisitErasure) (_ int64, err error) {
+	ctx, span := startSpan(ctx, "EraseVisits")
+	defer func() { endSpan(span, err) }()
+
+	var match ErasureMatch
+	if raw := strings.TrimSpace(request.IP); raw != "" {
+		ip := net.ParseIP(raw)
+		if ip == nil {
+			return 0, fmt.Errorf("%w: invalid ip", ErrValidation)
+		}
+		match.IPs = []string{ip.String()}
+		if raw != ip.String() {
+			match.IPs = append(match.IPs, raw)
+		}
+		match.HashIP = func(day time.Time) string {
+			return s.hashIP(ip, day)
+		}
+	}
+	if visitorID := strings.TrimSpace(request.VisitorID); visitorID != "" {
+		if !IsVisitorID(visitorID) {
+			return 0, fmt.Errorf("%w: invalid visitor_id", ErrValidation)
+		}
+		match.VisitorID = visitorID
+	}
+	if len(match.IPs) == 0 && match.VisitorID == "" {
+		return 0, fmt.Errorf("%w: ip or visitor_id is required", ErrValidation)
+	}
+
+	return s.repo.EraseVisits(ctx, match)
+}
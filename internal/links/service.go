@@ -6,27 +6,129 @@ import (
 	"errors"
 	"fmt"
 	"net/url"
+	"slices"
 	"strings"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
+
 	"github.com/mine/shorturl/internal/shortcode"
+	"github.com/mine/shorturl/internal/useragent"
 )
 
-const defaultListLimit = 200
+const (
+	defaultListLimit = 200
+	maxTagMatrixRows = 20
+)
 
 type Service struct {
-	repo Repository
+	repo          Repository
+	location      *time.Location
+	extraParams   []string
+	geo           GeoLocator
+	visitorSecret []byte
+	publisher     VisitPublisher
+	agents        *useragent.Parser
+	ipMode        IPMode
+	clickIDParam  string
+	postbackToken string
+	observer      LinkObserver
+}
+
+type ServiceOption func(*Service)
+
+// WithDefaultLocation sets the timezone for analytics requests that do not
+// name one.
+func WithDefaultLocation(location *time.Location) ServiceOption {
+	return func(s *Service) {
+		if location != nil {
+			s.location = location
+		}
+	}
+}
+
+// WithTrackedParams records these query parameters (e.g. gclid, fbclid) on
+// visits alongside the utm_* ones. Unsafe names are ignored.
+func WithTrackedParams(keys ...string) ServiceOption {
+	return func(s *Service) {
+		for _, key := range keys {
+			key = strings.TrimSpace(key)
+			if IsTrackableParam(key) && !slices.Contains(UTMParams, key) && !slices.Contains(s.extraParams, key) {
+				s.extraParams = append(s.extraParams, key)
+			}
+		}
+	}
+}
+
+// WithGeoLocator enriches recorded visits with country, region, city and ASN.
+func WithGeoLocator(locator GeoLocator) ServiceOption {
+	return func(s *Service) {
+		s.geo = locator
+	}
 }
 
-func NewService(repo Repository) *Service {
-	return &Service{repo: repo}
+// WithVisitorSecret keys the daily visitor hash. Without it a random key is
+// used, so hashes change on every restart.
+func WithVisitorSecret(secret []byte) ServiceOption {
+	return func(s *Service) {
+		if len(secret) > 0 {
+			s.visitorSecret = secret
+		}
+	}
 }
 
-func (s *Service) List(ctx context.Context) ([]Link, error) {
+// WithVisitPublisher pushes each recorded visit to publisher, e.g. for live
+// dashboards.
+func WithVisitPublisher(publisher VisitPublisher) ServiceOption {
+	return func(s *Service) {
+		s.publisher = publisher
+	}
+}
+
+// WithUserAgentParser replaces the built-in user agent rules.
+func WithUserAgentParser(parser *useragent.Parser) ServiceOption {
+	return func(s *Service) {
+		if parser != nil {
+			s.agents = parser
+		}
+	}
+}
+
+// WithIPMode sets how visitor addresses are stored. The default is IPModeRaw.
+func WithIPMode(mode IPMode) ServiceOption {
+	return func(s *Service) {
+		if mode != "" {
+			s.ipMode = mode
+		}
+	}
+}
+
+func NewService(repo Repository, opts ...ServiceOption) *Service {
+	service := &Service{repo: repo, location: time.UTC, visitorSecret: []byte(shortcode.MustRandomString(32)), agents: useragent.Default(), ipMode: IPModeRaw}
+	for _, opt := range opts {
+		opt(service)
+	}
+	return service
+}
+
+func (s *Service) List(ctx context.Context) (_ []Link, err error) {
+	ctx, span := startSpan(ctx, "List")
+	defer func() { endSpan(span, err) }()
+
 	return s.repo.ListLinks(ctx, defaultListLimit)
 }
 
-func (s *Service) Create(ctx context.Context, input CreateLinkInput) (Link, error) {
+func (s *Service) Get(ctx context.Context, id int64) (_ Link, err error) {
+	ctx, span := startSpan(ctx, "Get", attribute.Int64("link.id", id))
+	defer func() { endSpan(span, err) }()
+
+	return s.repo.GetLinkByID(ctx, id)
+}
+
+func (s *Service) Create(ctx context.Context, input CreateLinkInput) (_ Link, err error) {
+	ctx, span := startSpan(ctx, "Create")
+	defer func() { endSpan(span, err) }()
+
 	code := strings.TrimSpace(input.Code)
 	targetURL := strings.TrimSpace(input.TargetURL)
 	remark := strings.TrimSpace(input.Remark)
@@ -39,6 +141,10 @@ func (s *Service) Create(ctx context.Context, input CreateLinkInput) (Link, erro
 		return Link{}, fmt.Errorf("%w: invalid code", ErrValidation)
 	}
 
+	if err := validateExpiry(input.ExpiresAt, nil); err != nil {
+		return Link{}, err
+	}
+
 	if code == "" {
 		generatedCode, err := generateUniqueCode(ctx, s.repo, 6)
 		if err != nil {
@@ -47,10 +153,18 @@ func (s *Service) Create(ctx context.Context, input CreateLinkInput) (Link, erro
 		code = generatedCode
 	}
 
-	return s.repo.CreateLink(ctx, code, targetURL, remark, tags)
+	link, err := s.repo.CreateLink(ctx, Link{Code: code, TargetURL: targetURL, Remark: remark, Tags: tags, Enabled: true, NoTracking: input.NoTracking, ExpiresAt: input.ExpiresAt})
+	if err != nil {
+		return Link{}, err
+	}
+	s.notify(ctx, EventLinkCreated, link, nil)
+	return link, nil
 }
 
-func (s *Service) Update(ctx context.Context, id int64, input UpdateLinkInput) (Link, error) {
+func (s *Service) Update(ctx context.Context, id int64, input UpdateLinkInput) (_ Link, err error) {
+	ctx, span := startSpan(ctx, "Update", attribute.Int64("link.id", id))
+	defer func() { endSpan(span, err) }()
+
 	current, err := s.repo.GetLinkByID(ctx, id)
 	if err != nil {
 		return Link{}, err
@@ -70,52 +184,253 @@ func (s *Service) Update(ctx context.Context, id int64, input UpdateLinkInput) (
 	if !isValidURL(targetURL) {
 		return Link{}, fmt.Errorf("%w: invalid target_url", ErrValidation)
 	}
+	if input.ExpiresAt.Set {
+		if err := validateExpiry(input.ExpiresAt.Value, current.ExpiresAt); err != nil {
+			return Link{}, err
+		}
+	}
 
 	current.Code = code
 	current.TargetURL = targetURL
 	current.Remark = remark
 	current.Tags = tags
 	current.Enabled = input.Enabled
+	if input.NoTracking != nil {
+		current.NoTracking = *input.NoTracking
+	}
+	if input.ExpiresAt.Set {
+		current.ExpiresAt = input.ExpiresAt.Value
+	}
+
+	updated, err := s.repo.UpdateLink(ctx, current)
+	if err != nil {
+		return Link{}, err
+	}
+	s.notify(ctx, EventLinkUpdated, updated, nil)
+	return updated, nil
+}
+
+// validateExpiry rejects an expiry that has already passed, unless it is the
+// link's current one being sent back unchanged.
+func validateExpiry(expiresAt *time.Time, current *time.Time) error {
+	if expiresAt == nil || expiresAt.After(time.Now()) {
+		return nil
+	}
+	if current != nil && current.Equal(*expiresAt) {
+		return nil
+	}
+	return fmt.Errorf("%w: expires_at must be in the future", ErrValidation)
+}
+
+func (s *Service) Delete(ctx context.Context, id int64) (err error) {
+	ctx, span := startSpan(ctx, "Delete", attribute.Int64("link.id", id))
+	defer func() { endSpan(span, err) }()
+
+	link, err := s.repo.GetLinkByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := s.repo.DeleteLink(ctx, id); err != nil {
+		return err
+	}
+	s.notify(ctx, EventLinkDeleted, link, nil)
+	return nil
+}
 
-	return s.repo.UpdateLink(ctx, current)
+// Resolve looks up the target for code and records the visit. meta.VisitorID
+// is the id from the visitor cookie, if any. newVisitorID is set when a human
+// visitor without a cookie was given a fresh id that callers should hand out
+// as the cookie; it stays empty for anonymous visits (DNT / Sec-GPC or the
+// link's no-tracking option) and for bots, which get the daily hash instead.
+func (s *Service) Resolve(ctx context.Context, code string, meta VisitMeta) (targetURL string, newVisitorID string, err error) {
+	ctx, span := startSpan(ctx, "Resolve", attribute.String("link.code", code))
+	defer func() { endSpan(span, err) }()
+
+	link, err := s.activeLink(ctx, code)
+	if err != nil {
+		return "", "", err
+	}
+
+	visit := normalizeVisitMeta(meta, s.extraParams, s.agents)
+	if visit.TrafficType == TrafficHuman {
+		_ = s.repo.IncrementClick(ctx, link.ID)
+	}
+	targetURL = link.TargetURL
+	if !visit.DoNotTrack && !link.NoTracking {
+		if s.clickIDParam != "" && visit.TrafficType == TrafficHuman {
+			visit.ClickID = newClickID()
+			targetURL = withClickID(targetURL, s.clickIDParam, visit.ClickID)
+		}
+		if visit.VisitorID == "" {
+			if visit.TrafficType == TrafficHuman {
+				// A random id tells apart people who share an address and browser.
+				newVisitorID = newClickID()
+				visit.VisitorID = newVisitorID
+			} else {
+				visit.VisitorID = s.DailyVisitorID(visit.IP, visit.UserAgent, visit.VisitedAt)
+			}
+		}
+		if s.geo != nil && visit.IP != "" {
+			visit.Geo = s.geo.Locate(visit.IP)
+		}
+		visit.IP = s.storedIP(visit.IP, visit.VisitedAt)
+	} else {
+		visit = anonymousVisit(visit)
+	}
+	if err := s.repo.RecordVisit(ctx, link.ID, visit); err == nil {
+		live := newLiveVisit(link, visit)
+		if s.publisher != nil {
+			s.publisher.Publish(live)
+		}
+		if visit.TrafficType == TrafficHuman {
+			s.notify(ctx, EventLinkClicked, link, &live)
+		}
+	}
+	return targetURL, newVisitorID, nil
 }
 
-func (s *Service) Delete(ctx context.Context, id int64) error {
-	return s.repo.DeleteLink(ctx, id)
+// Lookup returns the target of an active link without recording a visit, for
+// requests such as HEAD that check a link rather than follow it.
+func (s *Service) Lookup(ctx context.Context, code string) (_ string, err error) {
+	ctx, span := startSpan(ctx, "Lookup", attribute.String("link.code", code))
+	defer func() { endSpan(span, err) }()
+
+	link, err := s.activeLink(ctx, code)
+	if err != nil {
+		return "", err
+	}
+	return link.TargetURL, nil
 }
 
-func (s *Service) Resolve(ctx context.Context, code string, meta VisitMeta) (string, error) {
+func (s *Service) activeLink(ctx context.Context, code string) (Link, error) {
 	trimmed := strings.TrimSpace(code)
 	if trimmed == "" || strings.Contains(trimmed, "/") {
-		return "", ErrLinkNotFound
+		return Link{}, ErrLinkNotFound
 	}
 
 	link, err := s.repo.GetLinkByCode(ctx, trimmed)
 	if err != nil {
-		return "", err
+		return Link{}, err
 	}
 	if !link.Enabled {
-		return "", ErrLinkNotFound
+		return Link{}, ErrLinkDisabled
+	}
+	if link.Expired(time.Now()) {
+		return Link{}, ErrLinkExpired
 	}
+	return link, nil
+}
 
-	_ = s.repo.IncrementClick(ctx, link.ID)
-	_ = s.repo.RecordVisit(ctx, link.ID, normalizeVisitMeta(meta))
-	return link.TargetURL, nil
+// ReparseVisits re-derives the client, OS, device and traffic type of visits
+// recorded under other user agent rules, e.g. after the rules were updated.
+// It reports how many visits were re-parsed; click_count is left as it is.
+func (s *Service) ReparseVisits(ctx context.Context) (_ int64, err error) {
+	ctx, span := startSpan(ctx, "ReparseVisits")
+	defer func() { endSpan(span, err) }()
+
+	return s.repo.ReparseVisits(ctx, s.agents.Version(), func(userAgent string) ClientInfo {
+		return describeClient(s.agents, userAgent, false)
+	})
 }
 
-func (s *Service) Analytics(ctx context.Context, id int64, days int) (LinkAnalytics, error) {
-	windowDays := normalizeAnalyticsDays(days)
-	now := time.Now().UTC()
-	since := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC).AddDate(0, 0, -windowDays+1)
+func (s *Service) Analytics(ctx context.Context, id int64, query AnalyticsQuery) (_ LinkAnalytics, err error) {
+	ctx, span := startSpan(ctx, "Analytics", attribute.Int64("link.id", id))
+	defer func() { endSpan(span, err) }()
+
+	window, err := resolveAnalyticsWindow(query, time.Now(), s.location)
+	if err != nil {
+		return LinkAnalytics{}, err
+	}
+
+	compareWindow, compare, err := comparisonWindow(query, window)
+	if err != nil {
+		return LinkAnalytics{}, err
+	}
 
-	analytics, err := s.repo.GetLinkAnalytics(ctx, id, since, analyticsRecentVisitLimit)
+	analytics, err := s.repo.GetLinkAnalytics(ctx, id, window, analyticsRecentVisitLimit)
 	if err != nil {
 		return LinkAnalytics{}, err
 	}
-	analytics.RangeDays = windowDays
+	if compare {
+		previous, err := s.repo.GetPeriodMetrics(ctx, id, compareWindow, breakdownNames(analytics.TopReferrers), breakdownNames(analytics.TopClients))
+		if err != nil {
+			return LinkAnalytics{}, err
+		}
+		analytics.Comparison = &AnalyticsComparison{
+			From:           compareWindow.From,
+			To:             compareWindow.To,
+			Clicks:         newDelta(analytics.RecentClicks, previous.Clicks),
+			UniqueVisitors: newDelta(analytics.UniqueVisitors, previous.UniqueVisitors),
+			TimeSeries:     previous.TimeSeries,
+			TopReferrers:   breakdownDeltas(analytics.TopReferrers, previous.Referrers),
+			TopClients:     breakdownDeltas(analytics.TopClients, previous.Clients),
+		}
+	}
+	analytics.AnalyticsRange = window.Range()
 	return analytics, nil
 }
 
+func (s *Service) Overview(ctx context.Context, query AnalyticsQuery) (_ Overview, err error) {
+	ctx, span := startSpan(ctx, "Overview")
+	defer func() { endSpan(span, err) }()
+
+	window, err := resolveAnalyticsWindow(query, time.Now(), s.location)
+	if err != nil {
+		return Overview{}, err
+	}
+
+	overview, err := s.repo.GetOverview(ctx, window)
+	if err != nil {
+		return Overview{}, err
+	}
+	overview.AnalyticsRange = window.Range()
+	return overview, nil
+}
+
+func (s *Service) TagAnalytics(ctx context.Context, tag string, query AnalyticsQuery) (_ TagAnalytics, err error) {
+	ctx, span := startSpan(ctx, "TagAnalytics", attribute.String("link.tag", tag))
+	defer func() { endSpan(span, err) }()
+
+	tag = strings.TrimSpace(tag)
+	if tag == "" {
+		return TagAnalytics{}, fmt.Errorf("%w: tag is required", ErrValidation)
+	}
+	window, err := resolveAnalyticsWindow(query, time.Now(), s.location)
+	if err != nil {
+		return TagAnalytics{}, err
+	}
+
+	analytics, err := s.repo.GetTagAnalytics(ctx, tag, window)
+	if err != nil {
+		return TagAnalytics{}, err
+	}
+	analytics.AnalyticsRange = window.Range()
+	return analytics, nil
+}
+
+// TagMatrix compares the given tags bucket by bucket. Without tags it picks the
+// busiest ones in the window.
+func (s *Service) TagMatrix(ctx context.Context, tags []string, query AnalyticsQuery) (_ TagMatrix, err error) {
+	ctx, span := startSpan(ctx, "TagMatrix")
+	defer func() { endSpan(span, err) }()
+
+	tags = normalizeTags(tags)
+	if len(tags) > maxTagMatrixRows {
+		return TagMatrix{}, fmt.Errorf("%w: at most %d tags", ErrValidation, maxTagMatrixRows)
+	}
+	window, err := resolveAnalyticsWindow(query, time.Now(), s.location)
+	if err != nil {
+		return TagMatrix{}, err
+	}
+
+	matrix, err := s.repo.GetTagMatrix(ctx, tags, window, maxTagMatrixRows)
+	if err != nil {
+		return TagMatrix{}, err
+	}
+	matrix.AnalyticsRange = window.Range()
+	return matrix, nil
+}
+
 func isValidURL(raw string) bool {
 	u, err := url.Parse(raw)
 	if err != nil {
@@ -0,0 +1,36 @@
+package links
+
+import (
+	"context"
+	"errors"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/mine/shorturl/internal/links"
+
+func startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, "links."+name, trace.WithAttributes(attrs...))
+}
+
+// endSpan ends span, recording err. Errors the caller caused, such as an
+// unknown code or invalid input, do not mark the span as failed.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		if !isClientError(err) {
+			span.SetStatus(codes.Error, err.Error())
+		}
+	}
+	span.End()
+}
+
+func isClientError(err error) bool {
+	return errors.Is(err, ErrLinkNotFound) ||
+		errors.Is(err, ErrLinkExists) ||
+		errors.Is(err, ErrValidation) ||
+		errors.Is(err, ErrClickNotFound)
+}
@@ -2,7 +2,10 @@ package links
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"net/url"
 	"time"
 )
 
@@ -10,25 +13,39 @@ var (
 	ErrLinkNotFound = errors.New("link not found")
 	ErrLinkExists   = errors.New("link already exists")
 	ErrValidation   = errors.New("validation failed")
+
+	// Resolve reports inactive links with these; both still match
+	// ErrLinkNotFound so visitors see the same 404.
+	ErrLinkDisabled = fmt.Errorf("%w: disabled", ErrLinkNotFound)
+	ErrLinkExpired  = fmt.Errorf("%w: expired", ErrLinkNotFound)
 )
 
 type Link struct {
-	ID         int64     `json:"id"`
-	Code       string    `json:"code"`
-	TargetURL  string    `json:"target_url"`
-	Remark     string    `json:"remark"`
-	Tags       []string  `json:"tags"`
-	Enabled    bool      `json:"enabled"`
-	ClickCount int64     `json:"click_count"`
-	CreatedAt  time.Time `json:"created_at"`
-	UpdatedAt  time.Time `json:"updated_at"`
+	ID         int64    `json:"id"`
+	Code       string   `json:"code"`
+	TargetURL  string   `json:"target_url"`
+	Remark     string   `json:"remark"`
+	Tags       []string `json:"tags"`
+	Enabled    bool     `json:"enabled"`
+	NoTracking bool     `json:"no_tracking"`
+	// ExpiresAt stops the link from redirecting from that moment on.
+	ExpiresAt  *time.Time `json:"expires_at"`
+	ClickCount int64      `json:"click_count"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
+
+func (l Link) Expired(now time.Time) bool {
+	return l.ExpiresAt != nil && !now.Before(*l.ExpiresAt)
 }
 
 type CreateLinkInput struct {
-	Code      string   `json:"code"`
-	TargetURL string   `json:"target_url"`
-	Remark    string   `json:"remark"`
-	Tags      []string `json:"tags"`
+	Code       string     `json:"code"`
+	TargetURL  string     `json:"target_url"`
+	Remark     string     `json:"remark"`
+	Tags       []string   `json:"tags"`
+	NoTracking bool       `json:"no_tracking"`
+	ExpiresAt  *time.Time `json:"expires_at"`
 }
 
 type UpdateLinkInput struct {
@@ -37,23 +54,105 @@ type UpdateLinkInput struct {
 	Remark    string   `json:"remark"`
 	Tags      []string `json:"tags"`
 	Enabled   bool     `json:"enabled"`
+	// NoTracking keeps the link's current setting when omitted.
+	NoTracking *bool `json:"no_tracking"`
+	// ExpiresAt keeps the current expiry when omitted and clears it when null.
+	ExpiresAt OptionalTime `json:"expires_at"`
+}
+
+// OptionalTime tells an omitted JSON field apart from an explicit null.
+type OptionalTime struct {
+	Set   bool
+	Value *time.Time
+}
+
+func (o *OptionalTime) UnmarshalJSON(data []byte) error {
+	o.Set = true
+	o.Value = nil
+	if string(data) == "null" {
+		return nil
+	}
+	var value time.Time
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	o.Value = &value
+	return nil
 }
 
 type VisitMeta struct {
-	VisitedAt   time.Time
-	IP          string
-	Referer     string
-	RefererHost string
-	UserAgent   string
-	ClientName  string
-	ClientType  string
-	DeviceType  string
-	OS          string
+	VisitedAt     time.Time
+	IP            string
+	Referer       string
+	RefererHost   string
+	UserAgent     string
+	ClientName    string
+	ClientVersion string
+	ClientType    string
+	DeviceType    string
+	DeviceBrand   string
+	DeviceModel   string
+	OS            string
+	OSVersion     string
+	// RulesVersion is the user agent rules version the client fields came
+	// from; visits parsed with older rules are re-parsed on startup.
+	RulesVersion string
+	VisitorID    string
+	// Language is the preferred Accept-Language tag, see PrimaryLanguage.
+	Language string
+	// ClickID is handed to the target URL for conversion attribution.
+	ClickID string
+	// Prefetch marks HEAD requests and browser prefetch / prerender hints.
+	Prefetch    bool
+	TrafficType string
+	// DoNotTrack is set from DNT / Sec-GPC; such visits are stored without
+	// any detail beyond time and traffic type.
+	DoNotTrack bool
+
+	// Query is the query string the visitor arrived with; the service copies
+	// the campaign parameters out of it.
+	Query       url.Values
+	UTMSource   string
+	UTMMedium   string
+	UTMCampaign string
+	UTMTerm     string
+	UTMContent  string
+	ExtraParams map[string]string
+
+	Geo GeoLocation
+}
+
+// ClientInfo is what a visit's User-Agent resolves to.
+type ClientInfo struct {
+	ClientName    string
+	ClientVersion string
+	ClientType    string
+	DeviceType    string
+	DeviceBrand   string
+	DeviceModel   string
+	OS            string
+	OSVersion     string
+	TrafficType   string
+}
+
+type GeoLocation struct {
+	CountryCode string `json:"country_code,omitempty"`
+	Region      string `json:"region,omitempty"`
+	City        string `json:"city,omitempty"`
+	ASN         uint   `json:"asn,omitempty"`
+	ASOrg       string `json:"as_org,omitempty"`
+}
+
+// GeoLocator resolves a visitor IP offline. It returns a zero GeoLocation
+// when the address is unknown.
+type GeoLocator interface {
+	Locate(ip string) GeoLocation
 }
 
 type VisitPoint struct {
-	Bucket string `json:"bucket"`
-	Clicks int64  `json:"clicks"`
+	Bucket   string `json:"bucket"`
+	Clicks   int64  `json:"clicks"`
+	Visitors int64  `json:"visitors"`
 }
 
 type VisitBreakdown struct {
@@ -61,38 +160,151 @@ type VisitBreakdown struct {
 	Count int64  `json:"count"`
 }
 
+// LanguageBreakdown counts visits per base language, with the full tags
+// (e.g. zh-CN, zh-TW) seen under it in Regions.
+type LanguageBreakdown struct {
+	Name    string           `json:"name"`
+	Count   int64            `json:"count"`
+	Regions []VisitBreakdown `json:"regions"`
+}
+
 type VisitRecord struct {
-	VisitedAt   time.Time `json:"visited_at"`
-	IPMasked    string    `json:"ip_masked"`
-	Referer     string    `json:"referer"`
-	RefererHost string    `json:"referer_host"`
-	UserAgent   string    `json:"user_agent"`
-	ClientName  string    `json:"client_name"`
-	ClientType  string    `json:"client_type"`
-	DeviceType  string    `json:"device_type"`
-	OS          string    `json:"os"`
+	VisitedAt     time.Time `json:"visited_at"`
+	IPMasked      string    `json:"ip_masked"`
+	Referer       string    `json:"referer"`
+	RefererHost   string    `json:"referer_host"`
+	UserAgent     string    `json:"user_agent"`
+	ClientName    string    `json:"client_name"`
+	ClientVersion string    `json:"client_version"`
+	ClientType    string    `json:"client_type"`
+	DeviceType    string    `json:"device_type"`
+	DeviceBrand   string    `json:"device_brand"`
+	DeviceModel   string    `json:"device_model"`
+	OS            string    `json:"os"`
+	OSVersion     string    `json:"os_version"`
+	Language      string    `json:"language,omitempty"`
+	UTMSource     string    `json:"utm_source,omitempty"`
+	UTMMedium     string    `json:"utm_medium,omitempty"`
+	UTMCampaign   string    `json:"utm_campaign,omitempty"`
+	UTMTerm       string    `json:"utm_term,omitempty"`
+	UTMContent    string    `json:"utm_content,omitempty"`
+	TrafficType   string    `json:"traffic_type"`
+	GeoLocation
 }
 
 type LinkAnalytics struct {
-	Link          Link             `json:"link"`
-	RangeDays     int              `json:"range_days"`
-	RecentClicks  int64            `json:"recent_clicks"`
-	UniqueIPs     int64            `json:"unique_ips"`
-	LastVisitedAt *time.Time       `json:"last_visited_at,omitempty"`
-	TimeSeries    []VisitPoint     `json:"time_series"`
-	TopReferrers  []VisitBreakdown `json:"top_referrers"`
-	TopClients    []VisitBreakdown `json:"top_clients"`
-	RecentVisits  []VisitRecord    `json:"recent_visits"`
+	AnalyticsRange
+	Link              Link                `json:"link"`
+	RecentClicks      int64               `json:"recent_clicks"`
+	UniqueIPs         int64               `json:"unique_ips"`
+	UniqueVisitors    int64               `json:"unique_visitors"`
+	NewVisitors       int64               `json:"new_visitors"`
+	ReturningVisitors int64               `json:"returning_visitors"`
+	LastVisitedAt     *time.Time          `json:"last_visited_at,omitempty"`
+	TimeSeries        []VisitPoint        `json:"time_series"`
+	TopReferrers      []VisitBreakdown    `json:"top_referrers"`
+	TopClients        []VisitBreakdown    `json:"top_clients"`
+	TopClientTypes    []VisitBreakdown    `json:"top_client_types"`
+	TopDevices        []VisitBreakdown    `json:"top_devices"`
+	TopOS             []VisitBreakdown    `json:"top_os"`
+	TopClientVersions []VisitBreakdown    `json:"top_client_versions"`
+	TopOSVersions     []VisitBreakdown    `json:"top_os_versions"`
+	TopDeviceModels   []VisitBreakdown    `json:"top_device_models"`
+	TopLanguages      []LanguageBreakdown `json:"top_languages"`
+	Conversions       ConversionSummary   `json:"conversions"`
+	// ConversionsByReferrer ranks referrer hosts by conversions.
+	ConversionsByReferrer []ConversionBreakdown       `json:"conversions_by_referrer"`
+	Campaigns             map[string][]VisitBreakdown `json:"campaigns"`
+	TopCountries          []VisitBreakdown            `json:"top_countries"`
+	TopCities             []VisitBreakdown            `json:"top_cities"`
+	RecentVisits          []VisitRecord               `json:"recent_visits"`
+	BotTraffic            BotTraffic                  `json:"bot_traffic"`
+	Comparison            *AnalyticsComparison        `json:"comparison,omitempty"`
+}
+
+// BotTraffic summarises the non-human visits in the window. It is reported
+// whether or not the headline metrics include them.
+type BotTraffic struct {
+	Total     int64            `json:"total"`
+	ByType    []VisitBreakdown `json:"by_type"`
+	TopAgents []VisitBreakdown `json:"top_agents"`
+}
+
+// AnalyticsRange echoes the resolved window back to the client.
+type AnalyticsRange struct {
+	RangeDays   int         `json:"range_days"`
+	From        time.Time   `json:"from"`
+	To          time.Time   `json:"to"`
+	Granularity Granularity `json:"granularity"`
+	Timezone    string      `json:"timezone"`
+	IncludeBots bool        `json:"include_bots"`
+}
+
+// Overview aggregates visits across every link in the window.
+type Overview struct {
+	AnalyticsRange
+	TotalClicks    int64               `json:"total_clicks"`
+	UniqueVisitors int64               `json:"unique_visitors"`
+	NewLinks       int64               `json:"new_links"`
+	TimeSeries     []VisitPoint        `json:"time_series"`
+	TopLinks       []VisitBreakdown    `json:"top_links"`
+	TopReferrers   []VisitBreakdown    `json:"top_referrers"`
+	TopClients     []VisitBreakdown    `json:"top_clients"`
+	TopDevices     []VisitBreakdown    `json:"top_devices"`
+	TopOS          []VisitBreakdown    `json:"top_os"`
+	TopLanguages   []LanguageBreakdown `json:"top_languages"`
+	Conversions    ConversionSummary   `json:"conversions"`
+	// ConversionsByLink ranks short codes by conversions.
+	ConversionsByLink []ConversionBreakdown `json:"conversions_by_link"`
+}
+
+// TagAnalytics aggregates visits across every link carrying Tag.
+type TagAnalytics struct {
+	AnalyticsRange
+	Tag            string              `json:"tag"`
+	LinkCount      int64               `json:"link_count"`
+	TotalClicks    int64               `json:"total_clicks"`
+	UniqueVisitors int64               `json:"unique_visitors"`
+	TimeSeries     []VisitPoint        `json:"time_series"`
+	TopLinks       []VisitBreakdown    `json:"top_links"`
+	TopReferrers   []VisitBreakdown    `json:"top_referrers"`
+	TopLanguages   []LanguageBreakdown `json:"top_languages"`
+}
+
+// TagMatrix cross-tabulates clicks per tag against the window's buckets.
+// Each row's Clicks lines up with Buckets.
+type TagMatrix struct {
+	AnalyticsRange
+	Buckets []string    `json:"buckets"`
+	Rows    []TagSeries `json:"rows"`
+}
+
+type TagSeries struct {
+	Tag    string  `json:"tag"`
+	Total  int64   `json:"total"`
+	Clicks []int64 `json:"clicks"`
 }
 
 type Repository interface {
 	ListLinks(ctx context.Context, limit int) ([]Link, error)
 	GetLinkByID(ctx context.Context, id int64) (Link, error)
 	GetLinkByCode(ctx context.Context, code string) (Link, error)
-	CreateLink(ctx context.Context, code string, targetURL string, remark string, tags []string) (Link, error)
+	CreateLink(ctx context.Context, link Link) (Link, error)
 	UpdateLink(ctx context.Context, link Link) (Link, error)
 	DeleteLink(ctx context.Context, id int64) error
+	// ClaimExpiredLinks returns the links that expired by now and were not
+	// returned before, so each expiry is reported once.
+	ClaimExpiredLinks(ctx context.Context, now time.Time) ([]Link, error)
 	IncrementClick(ctx context.Context, id int64) error
 	RecordVisit(ctx context.Context, linkID int64, meta VisitMeta) error
-	GetLinkAnalytics(ctx context.Context, id int64, since time.Time, limit int) (LinkAnalytics, error)
+	GetLinkAnalytics(ctx context.Context, id int64, window AnalyticsWindow, limit int) (LinkAnalytics, error)
+	GetOverview(ctx context.Context, window AnalyticsWindow) (Overview, error)
+	GetTagAnalytics(ctx context.Context, tag string, window AnalyticsWindow) (TagAnalytics, error)
+	GetTagMatrix(ctx context.Context, tags []string, window AnalyticsWindow, limit int) (TagMatrix, error)
+	GetBreakdown(ctx context.Context, request BreakdownRequest, window AnalyticsWindow) (Breakdown, error)
+	GetPeriodMetrics(ctx context.Context, id int64, window AnalyticsWindow, referrers []string, clients []string) (PeriodMetrics, error)
+	StreamVisits(ctx context.Context, filter VisitExportFilter, window AnalyticsWindow, fn func(ExportedVisit) error) error
+	RecordConversion(ctx context.Context, input ConversionInput) (bool, error)
+	EraseVisits(ctx context.Context, match ErasureMatch) (int64, error)
+	ReparseVisits(ctx context.Context, rulesVersion string, describe func(userAgent string) ClientInfo) (int64, error)
 }
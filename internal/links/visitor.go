@@ -0,0 +1,47 @@
+package links
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"time"
+)
+
+const visitorIDLength = 22
+
+// DailyVisitorID derives a visitor id from IP and user agent with a salt that
+// changes every UTC day, so cookieless visitors cannot be followed across days.
+// It is only stored for visits that are not given a visitor cookie.
+func (s *Service) DailyVisitorID(ip string, userAgent string, now time.Time) string {
+	mac := hmac.New(sha256.New, s.dailySalt("visitor-salt", now))
+	mac.Write([]byte(ip))
+	mac.Write([]byte{0})
+	mac.Write([]byte(userAgent))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))[:visitorIDLength]
+}
+
+// dailySalt derives a per-purpose key from the visitor secret that changes
+// every UTC day.
+func (s *Service) dailySalt(purpose string, now time.Time) []byte {
+	salt := hmac.New(sha256.New, s.visitorSecret)
+	salt.Write([]byte(purpose + ":" + now.UTC().Format(bucketLabelDay)))
+	return salt.Sum(nil)
+}
+
+func IsVisitorID(raw string) bool {
+	return isRandomID(raw)
+}
+
+// isRandomID matches the 22-character base64url ids used for visitors and
+// clicks, and the daily visitor hashes of the same shape.
+func isRandomID(raw string) bool {
+	if len(raw) != visitorIDLength {
+		return false
+	}
+	for _, r := range raw {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
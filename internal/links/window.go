@@ -0,0 +1,276 @@
+package links
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+type Granularity string
+
+const (
+	GranularityFiveMinutes Granularity = "5m"
+	GranularityHour        Granularity = "hour"
+	GranularityDay         Granularity = "day"
+)
+
+// maxBuckets bounds each granularity so a single response stays small: one
+// day of 5-minute buckets, a month of hours or a year of days.
+var maxBuckets = map[Granularity]int{
+	GranularityFiveMinutes: 288,
+	GranularityHour:        24 * 31,
+	GranularityDay:         366,
+}
+
+const (
+	bucketLabelDay  = "2006-01-02"
+	bucketLabelTime = time.RFC3339
+)
+
+// AnalyticsQuery is the raw request for an analytics window. From and To
+// accept RFC3339 timestamps or YYYY-MM-DD dates, where a date in To is
+// inclusive. Without From the window covers the last Days days. Timezone is an
+// IANA name; dates and day/hour buckets are computed in that zone.
+type AnalyticsQuery struct {
+	Days        int
+	From        string
+	To          string
+	Granularity string
+	Timezone    string
+	IncludeBots bool
+	// Compare is "previous" for the preceding window of equal length.
+	// CompareFrom instead starts an equal-length comparison window there.
+	Compare     string
+	CompareFrom string
+}
+
+// AnalyticsWindow holds From and To in Location, so bucket edges follow local
+// midnights and DST changes instead of UTC.
+type AnalyticsWindow struct {
+	From        time.Time
+	To          time.Time
+	Granularity Granularity
+	Location    *time.Location
+	IncludeBots bool
+}
+
+func (w AnalyticsWindow) Range() AnalyticsRange {
+	return AnalyticsRange{
+		RangeDays:   int(math.Ceil(w.To.Sub(w.From).Hours() / 24)),
+		From:        w.From,
+		To:          w.To,
+		Granularity: w.Granularity,
+		Timezone:    w.Location.String(),
+		IncludeBots: w.IncludeBots,
+	}
+}
+
+type Bucket struct {
+	Label string
+	Start time.Time
+	End   time.Time
+}
+
+func ParseGranularity(raw string) (Granularity, bool) {
+	switch Granularity(strings.ToLower(strings.TrimSpace(raw))) {
+	case "", GranularityDay:
+		return GranularityDay, true
+	case GranularityHour:
+		return GranularityHour, true
+	case GranularityFiveMinutes:
+		return GranularityFiveMinutes, true
+	default:
+		return "", false
+	}
+}
+
+func resolveAnalyticsWindow(query AnalyticsQuery, now time.Time, defaultLocation *time.Location) (AnalyticsWindow, error) {
+	granularity, ok := ParseGranularity(query.Granularity)
+	if !ok {
+		return AnalyticsWindow{}, fmt.Errorf("%w: invalid granularity", ErrValidation)
+	}
+
+	location := defaultLocation
+	if name := strings.TrimSpace(query.Timezone); name != "" {
+		loaded, err := time.LoadLocation(name)
+		if err != nil {
+			return AnalyticsWindow{}, fmt.Errorf("%w: invalid timezone %q", ErrValidation, name)
+		}
+		location = loaded
+	}
+	if location == nil {
+		location = time.UTC
+	}
+
+	window := AnalyticsWindow{To: now.In(location), Granularity: granularity, Location: location, IncludeBots: query.IncludeBots}
+	if strings.TrimSpace(query.To) != "" {
+		to, err := parseWindowBound(query.To, true, location)
+		if err != nil {
+			return AnalyticsWindow{}, err
+		}
+		window.To = to
+	}
+
+	if strings.TrimSpace(query.From) != "" {
+		from, err := parseWindowBound(query.From, false, location)
+		if err != nil {
+			return AnalyticsWindow{}, err
+		}
+		window.From = window.truncate(from)
+	} else {
+		days := normalizeAnalyticsDays(query.Days)
+		window.From = startOfDay(window.To).AddDate(0, 0, -days+1)
+		if query.Days <= 0 && window.bucketCount() > maxBuckets[granularity] {
+			// Only a fine granularity was asked for: show the latest buckets
+			// that fit instead of rejecting the default range.
+			latest := window
+			latest.From = window.truncate(window.To)
+			window.From = latest.shift(-(maxBuckets[granularity] - 1)).From
+		}
+	}
+
+	if !window.From.Before(window.To) {
+		return AnalyticsWindow{}, fmt.Errorf("%w: from must be before to", ErrValidation)
+	}
+	if window.bucketCount() > maxBuckets[granularity] {
+		return AnalyticsWindow{}, fmt.Errorf("%w: range too large for %s buckets", ErrValidation, granularity)
+	}
+
+	return window, nil
+}
+
+// comparisonWindow resolves the window the current one is compared with, if
+// any. It always spans the same number of buckets so the series line up.
+func comparisonWindow(query AnalyticsQuery, window AnalyticsWindow) (AnalyticsWindow, bool, error) {
+	compare := strings.TrimSpace(query.Compare)
+	compareFrom := strings.TrimSpace(query.CompareFrom)
+	switch {
+	case compareFrom != "":
+		start, err := parseWindowBound(compareFrom, false, window.Location)
+		if err != nil {
+			return AnalyticsWindow{}, false, err
+		}
+		start = window.truncate(start)
+		if !start.Before(window.From) {
+			return AnalyticsWindow{}, false, fmt.Errorf("%w: compare_from must be before from", ErrValidation)
+		}
+		return window.shift(-window.bucketsBetween(start, window.From)), true, nil
+	case compare == "previous":
+		return window.shift(-window.bucketCount()), true, nil
+	case compare == "":
+		return AnalyticsWindow{}, false, nil
+	default:
+		return AnalyticsWindow{}, false, fmt.Errorf("%w: invalid compare", ErrValidation)
+	}
+}
+
+func parseWindowBound(raw string, inclusiveDate bool, location *time.Location) (time.Time, error) {
+	raw = strings.TrimSpace(raw)
+	if value, err := time.Parse(time.RFC3339, raw); err == nil {
+		return value.In(location), nil
+	}
+	if value, err := time.ParseInLocation(bucketLabelDay, raw, location); err == nil {
+		if inclusiveDate {
+			value = value.AddDate(0, 0, 1)
+		}
+		return value, nil
+	}
+	return time.Time{}, fmt.Errorf("%w: invalid time %q", ErrValidation, raw)
+}
+
+// Buckets splits the window into consecutive half-open intervals; the last one
+// is cut short at To.
+func (w AnalyticsWindow) Buckets() []Bucket {
+	buckets := make([]Bucket, 0, w.bucketCount())
+	for start := w.From; start.Before(w.To); {
+		end := w.next(start)
+		label := start.Format(bucketLabelTime)
+		if w.Granularity == GranularityDay {
+			label = start.Format(bucketLabelDay)
+		}
+		buckets = append(buckets, Bucket{Label: label, Start: start, End: minTime(end, w.To)})
+		start = end
+	}
+	return buckets
+}
+
+// shift moves the window by whole buckets. Day buckets move by calendar days
+// so both windows start at local midnight.
+func (w AnalyticsWindow) shift(buckets int) AnalyticsWindow {
+	shifted := w
+	switch w.Granularity {
+	case GranularityFiveMinutes:
+		shifted.From = w.From.Add(time.Duration(buckets) * 5 * time.Minute)
+		shifted.To = w.To.Add(time.Duration(buckets) * 5 * time.Minute)
+	case GranularityHour:
+		shifted.From = w.From.Add(time.Duration(buckets) * time.Hour)
+		shifted.To = w.To.Add(time.Duration(buckets) * time.Hour)
+	default:
+		shifted.From = w.From.AddDate(0, 0, buckets)
+		shifted.To = w.To.AddDate(0, 0, buckets)
+	}
+	return shifted
+}
+
+// bucketsBetween counts whole buckets from start to end, both bucket-aligned.
+func (w AnalyticsWindow) bucketsBetween(start time.Time, end time.Time) int {
+	switch w.Granularity {
+	case GranularityFiveMinutes:
+		return int(end.Sub(start) / (5 * time.Minute))
+	case GranularityHour:
+		return int(end.Sub(start) / time.Hour)
+	default:
+		// Rounding absorbs the 23 and 25 hour days around DST changes.
+		return int(math.Round(end.Sub(start).Hours() / 24))
+	}
+}
+
+func (w AnalyticsWindow) bucketCount() int {
+	count := 0
+	for start := w.From; start.Before(w.To); start = w.next(start) {
+		count++
+		if count > maxBuckets[w.Granularity] {
+			break
+		}
+	}
+	return count
+}
+
+// truncate works on the local wall clock so zones with half-hour offsets get
+// buckets on their own hour marks. Subtracting rather than rebuilding with
+// time.Date keeps t in the right half of a repeated DST hour.
+func (w AnalyticsWindow) truncate(t time.Time) time.Time {
+	sinceHour := time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second + time.Duration(t.Nanosecond())
+	switch w.Granularity {
+	case GranularityFiveMinutes:
+		return t.Add(-(sinceHour % (5 * time.Minute)))
+	case GranularityHour:
+		return t.Add(-sinceHour)
+	default:
+		return startOfDay(t)
+	}
+}
+
+func (w AnalyticsWindow) next(t time.Time) time.Time {
+	switch w.Granularity {
+	case GranularityFiveMinutes:
+		return t.Add(5 * time.Minute)
+	case GranularityHour:
+		return t.Add(time.Hour)
+	default:
+		// Calendar days, so a DST change yields a 23 or 25 hour bucket.
+		return time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+	}
+}
+
+func startOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+func minTime(a time.Time, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,170 @@
+package links
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func TestAnalyticsWindowDayBucketsFollowTimezone(t *testing.T) {
+	now := time.Date(2025, 3, 2, 3, 0, 0, 0, time.UTC) // 11:00 in Shanghai
+	window, err := resolveAnalyticsWindow(AnalyticsQuery{Days: 2, Timezone: "Asia/Shanghai"}, now, time.UTC)
+	if err != nil {
+		t.Fatalf("resolve window: %v", err)
+	}
+
+	buckets := window.Buckets()
+	if len(buckets) != 2 || buckets[0].Label != "2025-03-01" || buckets[1].Label != "2025-03-02" {
+		t.Fatalf("unexpected buckets %+v", buckets)
+	}
+	if want := time.Date(2025, 2, 28, 16, 0, 0, 0, time.UTC); !buckets[0].Start.Equal(want) {
+		t.Fatalf("expected window to start at Shanghai midnight %s, got %s", want, buckets[0].Start.UTC())
+	}
+}
+
+func TestAnalyticsWindowHandlesDSTTransitions(t *testing.T) {
+	now := time.Date(2025, 12, 1, 0, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		name        string
+		from        string
+		granularity string
+		buckets     int
+		firstHours  float64
+	}{
+		{name: "spring forward hours", from: "2025-03-09", granularity: "hour", buckets: 23},
+		{name: "fall back hours", from: "2025-11-02", granularity: "hour", buckets: 25},
+		{name: "spring forward day", from: "2025-03-09", granularity: "day", buckets: 1, firstHours: 23},
+		{name: "fall back day", from: "2025-11-02", granularity: "day", buckets: 1, firstHours: 25},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			window, err := resolveAnalyticsWindow(AnalyticsQuery{
+				From:        tc.from,
+				To:          tc.from,
+				Granularity: tc.granularity,
+				Timezone:    "America/New_York",
+			}, now, time.UTC)
+			if err != nil {
+				t.Fatalf("resolve window: %v", err)
+			}
+
+			buckets := window.Buckets()
+			if len(buckets) != tc.buckets {
+				t.Fatalf("expected %d buckets, got %d", tc.buckets, len(buckets))
+			}
+			if tc.firstHours > 0 && buckets[0].End.Sub(buckets[0].Start).Hours() != tc.firstHours {
+				t.Fatalf("expected a %v hour day, got %s", tc.firstHours, buckets[0].End.Sub(buckets[0].Start))
+			}
+
+			seen := make(map[string]struct{}, len(buckets))
+			for _, bucket := range buckets {
+				if _, ok := seen[bucket.Label]; ok {
+					t.Fatalf("duplicate bucket label %s", bucket.Label)
+				}
+				seen[bucket.Label] = struct{}{}
+			}
+		})
+	}
+}
+
+func TestAnalyticsWindowAlignsHalfHourOffsets(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	window, err := resolveAnalyticsWindow(AnalyticsQuery{
+		From:        "2025-03-01T10:17:00+05:30",
+		To:          "2025-03-01T12:00:00+05:30",
+		Granularity: "hour",
+		Timezone:    "Asia/Kolkata",
+	}, now, time.UTC)
+	if err != nil {
+		t.Fatalf("resolve window: %v", err)
+	}
+
+	buckets := window.Buckets()
+	if len(buckets) != 2 || buckets[0].Label != "2025-03-01T10:00:00+05:30" {
+		t.Fatalf("expected buckets on local hour marks, got %+v", buckets)
+	}
+}
+
+func TestAnalyticsWindowFitsDefaultRangeToFineGranularity(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 2, 0, 0, time.UTC)
+	window, err := resolveAnalyticsWindow(AnalyticsQuery{Granularity: "5m"}, now, time.UTC)
+	if err != nil {
+		t.Fatalf("resolve window: %v", err)
+	}
+	buckets := window.Buckets()
+	if len(buckets) != maxBuckets[GranularityFiveMinutes] || !buckets[len(buckets)-1].End.Equal(now) {
+		t.Fatalf("expected the latest %d buckets up to now, got %d ending %v", maxBuckets[GranularityFiveMinutes], len(buckets), buckets[len(buckets)-1].End)
+	}
+	if want := time.Date(2025, 2, 28, 12, 5, 0, 0, time.UTC); !window.From.Equal(want) {
+		t.Fatalf("expected window to start at %v, got %v", want, window.From)
+	}
+
+	if _, err := resolveAnalyticsWindow(AnalyticsQuery{Days: 7, Granularity: "5m"}, now, time.UTC); err == nil {
+		t.Fatalf("expected an explicit range that is too large to be rejected")
+	}
+}
+
+func TestAnalyticsWindowRejectsUnknownTimezone(t *testing.T) {
+	if _, err := resolveAnalyticsWindow(AnalyticsQuery{Timezone: "Mars/Olympus"}, time.Now(), time.UTC); err == nil {
+		t.Fatal("expected invalid timezone error")
+	}
+}
+
+func TestComparisonWindowAlignsBuckets(t *testing.T) {
+	now := time.Date(2025, 3, 12, 15, 30, 0, 0, time.UTC)
+
+	cases := []struct {
+		name  string
+		query AnalyticsQuery
+		from  string
+		to    string
+	}{
+		{
+			name:  "previous days across spring forward",
+			query: AnalyticsQuery{Days: 7, Timezone: "America/New_York", Compare: "previous"},
+			from:  "2025-02-27T00:00:00-05:00",
+			to:    "2025-03-05T11:30:00-05:00",
+		},
+		{
+			name:  "previous hours",
+			query: AnalyticsQuery{From: "2025-03-12T10:00:00Z", To: "2025-03-12T13:30:00Z", Granularity: "hour", Compare: "previous"},
+			from:  "2025-03-12T06:00:00Z",
+			to:    "2025-03-12T09:30:00Z",
+		},
+		{
+			name:  "custom start keeps length",
+			query: AnalyticsQuery{From: "2025-03-10", To: "2025-03-11", CompareFrom: "2025-02-10"},
+			from:  "2025-02-10T00:00:00Z",
+			to:    "2025-02-12T00:00:00Z",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			window, err := resolveAnalyticsWindow(tc.query, now, time.UTC)
+			if err != nil {
+				t.Fatalf("resolve window: %v", err)
+			}
+			compare, ok, err := comparisonWindow(tc.query, window)
+			if err != nil || !ok {
+				t.Fatalf("comparison window: ok=%v err=%v", ok, err)
+			}
+
+			wantFrom, _ := time.Parse(time.RFC3339, tc.from)
+			wantTo, _ := time.Parse(time.RFC3339, tc.to)
+			if !compare.From.Equal(wantFrom) || !compare.To.Equal(wantTo) {
+				t.Fatalf("expected %s - %s, got %s - %s", wantFrom, wantTo, compare.From, compare.To)
+			}
+			if len(compare.Buckets()) != len(window.Buckets()) {
+				t.Fatalf("expected %d comparison buckets, got %d", len(window.Buckets()), len(compare.Buckets()))
+			}
+		})
+	}
+
+	window, _ := resolveAnalyticsWindow(AnalyticsQuery{Days: 7}, now, time.UTC)
+	for _, query := range []AnalyticsQuery{{Compare: "yoy"}, {CompareFrom: "2025-03-12"}} {
+		if _, _, err := comparisonWindow(query, window); err == nil {
+			t.Fatalf("expected %+v to be rejected", query)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+// Package live fans recorded visits out to in-process subscribers such as the
+// admin SSE stream.
+package live
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/mine/shorturl/internal/links"
+)
+
+const DefaultBuffer = 64
+
+// Filter limits a subscription to one link or one tag. The zero Filter
+// matches human visits on every link.
+type Filter struct {
+	LinkID      int64
+	Tag         string
+	IncludeBots bool
+}
+
+func (f Filter) matches(visit links.LiveVisit) bool {
+	if !f.IncludeBots && visit.TrafficType != links.TrafficHuman {
+		return false
+	}
+	if f.LinkID != 0 && visit.LinkID != f.LinkID {
+		return false
+	}
+	if f.Tag != "" && !visit.HasTag(f.Tag) {
+		return false
+	}
+	return true
+}
+
+// Hub is a publish/subscribe fan-out. Publish never blocks: a subscriber whose
+// buffer is full misses the event and its Dropped count goes up.
+type Hub struct {
+	mu          sync.RWMutex
+	subscribers map[*Subscription]struct{}
+	closed      bool
+}
+
+func NewHub() *Hub {
+	return &Hub{subscribers: make(map[*Subscription]struct{})}
+}
+
+type Subscription struct {
+	hub     *Hub
+	filter  Filter
+	events  chan links.LiveVisit
+	dropped atomic.Int64
+	once    sync.Once
+}
+
+func (h *Hub) Subscribe(filter Filter, buffer int) *Subscription {
+	if buffer <= 0 {
+		buffer = DefaultBuffer
+	}
+	sub := &Subscription{hub: h, filter: filter, events: make(chan links.LiveVisit, buffer)}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		sub.once.Do(func() { close(sub.events) })
+		return sub
+	}
+	h.subscribers[sub] = struct{}{}
+	return sub
+}
+
+// Close ends every subscription, and any made later, so that open streams
+// return when the server shuts down.
+func (h *Hub) Close() {
+	h.mu.Lock()
+	h.closed = true
+	subs := make([]*Subscription, 0, len(h.subscribers))
+	for sub := range h.subscribers {
+		subs = append(subs, sub)
+	}
+	h.mu.Unlock()
+
+	for _, sub := range subs {
+		sub.Close()
+	}
+}
+
+func (h *Hub) Publish(visit links.LiveVisit) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for sub := range h.subscribers {
+		if !sub.filter.matches(visit) {
+			continue
+		}
+		select {
+		case sub.events <- visit:
+		default:
+			sub.dropped.Add(1)
+		}
+	}
+}
+
+func (h *Hub) Subscribers() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.subscribers)
+}
+
+// Events is closed once the subscription is closed.
+func (s *Subscription) Events() <-chan links.LiveVisit {
+	return s.events
+}
+
+// Dropped reports how many events were skipped because the buffer was full.
+func (s *Subscription) Dropped() int64 {
+	return s.dropped.Load()
+}
+
+func (s *Subscription) Close() {
+	s.once.Do(func() {
+		s.hub.mu.Lock()
+		delete(s.hub.subscribers, s)
+		close(s.events)
+		s.hub.mu.Unlock()
+	})
+}
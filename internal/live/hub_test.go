@@ -0,0 +1,63 @@
+package live
+
+import (
+	"testing"
+
+	"github.com/mine/shorturl/internal/links"
+)
+
+func TestHubFiltersAndDropsForSlowSubscribers(t *testing.T) {
+	hub := NewHub()
+	all := hub.Subscribe(Filter{}, 1)
+	tagged := hub.Subscribe(Filter{Tag: "ads"}, 8)
+	defer tagged.Close()
+
+	visits := []links.LiveVisit{
+		{LinkID: 1, Code: "a", Tags: []string{"ads"}, TrafficType: links.TrafficHuman},
+		{LinkID: 2, Code: "b", TrafficType: links.TrafficHuman},
+		{LinkID: 1, Code: "a", Tags: []string{"ads"}, TrafficType: links.TrafficBot},
+	}
+	for _, visit := range visits {
+		hub.Publish(visit)
+	}
+
+	// The unfiltered subscriber has room for one event; the second human visit
+	// is dropped rather than blocking Publish.
+	if got := (<-all.Events()).Code; got != "a" {
+		t.Fatalf("expected first event for a, got %q", got)
+	}
+	if all.Dropped() != 1 {
+		t.Fatalf("expected 1 dropped event, got %d", all.Dropped())
+	}
+
+	if got := len(tagged.Events()); got != 1 {
+		t.Fatalf("expected only the human ads visit, got %d events", got)
+	}
+
+	all.Close()
+	all.Close()
+	if _, ok := <-all.Events(); ok {
+		t.Fatal("expected closed subscription channel")
+	}
+	if hub.Subscribers() != 1 {
+		t.Fatalf("expected 1 remaining subscriber, got %d", hub.Subscribers())
+	}
+	hub.Publish(visits[0])
+}
+
+func TestHubCloseEndsSubscriptions(t *testing.T) {
+	hub := NewHub()
+	open := hub.Subscribe(Filter{}, 1)
+
+	hub.Close()
+	if _, ok := <-open.Events(); ok {
+		t.Fatal("expected open subscription to end")
+	}
+	if _, ok := <-hub.Subscribe(Filter{}, 1).Events(); ok {
+		t.Fatal("expected subscription after Close to end at once")
+	}
+	if hub.Subscribers() != 0 {
+		t.Fatalf("expected no subscribers, got %d", hub.Subscribers())
+	}
+	open.Close()
+}
@@ -0,0 +1,124 @@
+// Package metrics collects the server's Prometheus metrics. A nil *Metrics is
+// valid and records nothing, so instrumented code does not need to check.
+package metrics
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Redirect outcomes.
+const (
+	RedirectHit      = "hit"
+	RedirectNotFound = "not_found"
+	RedirectDisabled = "disabled"
+	RedirectExpired  = "expired"
+	RedirectError    = "error"
+)
+
+// UnmatchedRoute labels requests that matched no route, so probes for random
+// paths cannot blow up the route label.
+const UnmatchedRoute = "unmatched"
+
+type Metrics struct {
+	registry        *prometheus.Registry
+	redirects       *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	apiErrors       *prometheus.CounterVec
+	queryDuration   *prometheus.HistogramVec
+}
+
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		redirects: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "shorturl_redirects_total",
+			Help: "Short link redirects by outcome.",
+		}, []string{"outcome"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "shorturl_http_request_duration_seconds",
+			Help:    "HTTP request duration by route template, method and status.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"route", "method", "status"}),
+		apiErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "shorturl_admin_api_errors_total",
+			Help: "Admin API error responses by error code.",
+		}, []string{"code"}),
+		queryDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "shorturl_db_query_duration_seconds",
+			Help:    "Database statement duration by operation.",
+			Buckets: prometheus.ExponentialBuckets(0.0005, 2, 14),
+		}, []string{"statement"}),
+	}
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.redirects,
+		m.requestDuration,
+		m.apiErrors,
+		m.queryDuration,
+	)
+	// Report every outcome from the start so rate() works before the first
+	// miss or error.
+	for _, outcome := range []string{RedirectHit, RedirectNotFound, RedirectDisabled, RedirectExpired, RedirectError} {
+		m.redirects.WithLabelValues(outcome)
+	}
+	return m
+}
+
+func (m *Metrics) Redirect(outcome string) {
+	if m == nil {
+		return
+	}
+	m.redirects.WithLabelValues(outcome).Inc()
+}
+
+// ObserveRequest records a request under its route template, e.g. "/:code".
+func (m *Metrics) ObserveRequest(route string, method string, status int, elapsed time.Duration) {
+	if m == nil {
+		return
+	}
+	if route == "" {
+		route = UnmatchedRoute
+	}
+	m.requestDuration.WithLabelValues(route, method, strconv.Itoa(status)).Observe(elapsed.Seconds())
+}
+
+func (m *Metrics) APIError(code string) {
+	if m == nil {
+		return
+	}
+	m.apiErrors.WithLabelValues(code).Inc()
+}
+
+// ObserveQuery records a database statement by operation, e.g. "select".
+func (m *Metrics) ObserveQuery(operation string, elapsed time.Duration) {
+	if m == nil {
+		return
+	}
+	m.queryDuration.WithLabelValues(operation).Observe(elapsed.Seconds())
+}
+
+// Handler serves the metrics in the Prometheus text format. A non-empty token
+// must be presented as a bearer token.
+func (m *Metrics) Handler(token string) http.Handler {
+	metricsHandler := promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token != "" {
+			presented, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(strings.TrimSpace(presented)), []byte(token)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		metricsHandler.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,54 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandlerRequiresToken(t *testing.T) {
+	m := New()
+	m.Redirect(RedirectHit)
+	m.ObserveQuery("select", time.Millisecond)
+	m.ObserveQuery("other", time.Millisecond)
+	handler := m.Handler("scrape-me")
+
+	for _, header := range []string{"", "Bearer wrong"} {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		if recorder.Code != http.StatusUnauthorized {
+			t.Fatalf("expected 401 for %q, got %d", header, recorder.Code)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Authorization", "Bearer scrape-me")
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	body := recorder.Body.String()
+	for _, want := range []string{
+		`shorturl_redirects_total{outcome="hit"} 1`,
+		`shorturl_redirects_total{outcome="error"} 0`,
+		`shorturl_db_query_duration_seconds_count{statement="select"} 1`,
+		`shorturl_db_query_duration_seconds_count{statement="other"} 1`,
+		"go_goroutines",
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("expected %q in metrics, got %s", want, body)
+		}
+	}
+}
+
+func TestNilMetricsRecordNothing(t *testing.T) {
+	var m *Metrics
+	m.Redirect(RedirectHit)
+	m.ObserveRequest("/:code", http.MethodGet, http.StatusFound, time.Millisecond)
+	m.APIError("not_found")
+	m.ObserveQuery("select", time.Millisecond)
+}
@@ -4,15 +4,24 @@ import (
 	"crypto/rand"
 )
 
+// reserved are the top-level paths served next to /:code, which a link with
+// the same code would never reach.
+var reserved = map[string]bool{
+	"admin":       true,
+	"conversions": true,
+	"healthz":     true,
+	"metrics":     true,
+}
+
 func IsValidAuto(code string) bool {
-	if len(code) < 4 || len(code) > 16 {
+	if len(code) < 4 || len(code) > 16 || reserved[code] {
 		return false
 	}
 	return isBase62(code)
 }
 
 func IsValidCustom(code string) bool {
-	if len(code) < 1 || len(code) > 32 {
+	if len(code) < 1 || len(code) > 32 || reserved[code] {
 		return false
 	}
 	for i := 0; i < len(code); i++ {
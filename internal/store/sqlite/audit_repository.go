@@ -0,0 +1,175 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strings"
+
+	"github.com/mine/shorturl/internal/audit"
+)
+
+type AuditRepository struct {
+	db *sql.DB
+}
+
+func NewAuditRepository(db *sql.DB) *AuditRepository {
+	return &AuditRepository{db: db}
+}
+
+func (r *AuditRepository) AppendAuditEntry(ctx context.Context, entry audit.Entry) error {
+	_, err := r.db.ExecContext(
+		ctx,
+		`INSERT INTO audit_logs(actor, action, target_type, target_id, before_json, after_json, ip, user_agent, created_at)
+		 VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		entry.Actor,
+		entry.Action,
+		entry.TargetType,
+		entry.TargetID,
+		nullableJSON(entry.Before),
+		nullableJSON(entry.After),
+		entry.IP,
+		entry.UserAgent,
+		formatSQLiteTime(entry.CreatedAt),
+	)
+	return err
+}
+
+func (r *AuditRepository) ListAuditEntries(ctx context.Context, filter audit.Filter) ([]audit.Entry, int64, error) {
+	where, args := auditWhere(filter)
+
+	var total int64
+	if err := r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM audit_logs`+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := r.db.QueryContext(
+		ctx,
+		`SELECT id, actor, action, target_type, target_id, before_json, after_json, ip, user_agent, created_at
+		 FROM audit_logs`+where+`
+		 ORDER BY id DESC
+		 LIMIT ? OFFSET ?`,
+		append(args, filter.PageSize, (filter.Page-1)*filter.PageSize)...,
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	result := make([]audit.Entry, 0, filter.PageSize)
+	for rows.Next() {
+		entry, err := scanAuditEntry(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		result = append(result, entry)
+	}
+
+	return result, total, rows.Err()
+}
+
+func (r *AuditRepository) StreamAuditEntries(ctx context.Context, filter audit.Filter, fn func(audit.Entry) error) error {
+	where, args := auditWhere(filter)
+
+	rows, err := r.db.QueryContext(
+		ctx,
+		`SELECT id, actor, action, target_type, target_id, before_json, after_json, ip, user_agent, created_at
+		 FROM audit_logs`+where+`
+		 ORDER BY id ASC`,
+		args...,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		entry, err := scanAuditEntry(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+func auditWhere(filter audit.Filter) (string, []any) {
+	clauses := make([]string, 0, 6)
+	args := make([]any, 0, 6)
+
+	for _, condition := range []struct {
+		column string
+		value  string
+	}{
+		{"actor", filter.Actor},
+		{"action", filter.Action},
+		{"target_type", filter.TargetType},
+		{"target_id", filter.TargetID},
+	} {
+		if condition.value == "" {
+			continue
+		}
+		clauses = append(clauses, condition.column+" = ?")
+		args = append(args, condition.value)
+	}
+	if !filter.From.IsZero() {
+		clauses = append(clauses, "created_at >= ?")
+		args = append(args, formatSQLiteTime(filter.From))
+	}
+	if !filter.To.IsZero() {
+		clauses = append(clauses, "created_at < ?")
+		args = append(args, formatSQLiteTime(filter.To))
+	}
+
+	if len(clauses) == 0 {
+		return "", args
+	}
+	return " WHERE " + strings.Join(clauses, " AND "), args
+}
+
+func scanAuditEntry(scanTarget scanner) (audit.Entry, error) {
+	var (
+		entry      audit.Entry
+		beforeJSON sql.NullString
+		afterJSON  sql.NullString
+		rawCreated string
+	)
+	if err := scanTarget.Scan(
+		&entry.ID,
+		&entry.Actor,
+		&entry.Action,
+		&entry.TargetType,
+		&entry.TargetID,
+		&beforeJSON,
+		&afterJSON,
+		&entry.IP,
+		&entry.UserAgent,
+		&rawCreated,
+	); err != nil {
+		return audit.Entry{}, err
+	}
+
+	createdAt, err := parseSQLiteTime(rawCreated)
+	if err != nil {
+		return audit.Entry{}, err
+	}
+	entry.CreatedAt = createdAt
+	if beforeJSON.Valid {
+		entry.Before = json.RawMessage(beforeJSON.String)
+	}
+	if afterJSON.Valid {
+		entry.After = json.RawMessage(afterJSON.String)
+	}
+
+	return entry, nil
+}
+
+func nullableJSON(raw json.RawMessage) sql.NullString {
+	if len(raw) == 0 {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: string(raw), Valid: true}
+}
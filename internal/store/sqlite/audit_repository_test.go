@@ -0,0 +1,42 @@
+package sqlite
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/mine/shorturl/internal/audit"
+)
+
+func TestAuditLogIsAppendOnly(t *testing.T) {
+	database, err := Open(filepath.Join(t.TempDir(), "audit-test.db"))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	t.Cleanup(func() { _ = database.Close() })
+
+	ctx := context.Background()
+	if err := Init(ctx, database); err != nil {
+		t.Fatalf("init db: %v", err)
+	}
+
+	repo := NewAuditRepository(database)
+	if err := repo.AppendAuditEntry(ctx, audit.Entry{Actor: "admin", Action: audit.ActionLogin, TargetType: audit.TargetUser, TargetID: "1"}); err != nil {
+		t.Fatalf("append audit entry: %v", err)
+	}
+
+	if _, err := database.ExecContext(ctx, `UPDATE audit_logs SET actor = 'mallory'`); err == nil {
+		t.Fatal("expected update on audit_logs to fail")
+	}
+	if _, err := database.ExecContext(ctx, `DELETE FROM audit_logs`); err == nil {
+		t.Fatal("expected delete on audit_logs to fail")
+	}
+
+	entries, total, err := repo.ListAuditEntries(ctx, audit.Filter{Page: 1, PageSize: 10})
+	if err != nil {
+		t.Fatalf("list audit entries: %v", err)
+	}
+	if total != 1 || entries[0].Actor != "admin" {
+		t.Fatalf("expected untouched entry, got total=%d entries=%+v", total, entries)
+	}
+}
@@ -7,11 +7,17 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
-	_ "modernc.org/sqlite"
+	moderncsqlite "modernc.org/sqlite"
 )
 
-func Open(path string) (*sql.DB, error) {
+func Open(path string, opts ...OpenOption) (*sql.DB, error) {
+	var options openOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
 	dir := filepath.Dir(path)
 	if dir != "." {
 		if err := os.MkdirAll(dir, 0o755); err != nil {
@@ -19,11 +25,9 @@ func Open(path string) (*sql.DB, error) {
 		}
 	}
 
-	dsn := fmt.Sprintf("file:%s?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)", path)
-	database, err := sql.Open("sqlite", dsn)
-	if err != nil {
-		return nil, err
-	}
+	// WAL lets redirects keep writing visits while exports and analytics read.
+	dsn := fmt.Sprintf("file:%s?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)", path)
+	database := sql.OpenDB(observedConnector{dsn: dsn, driver: &moderncsqlite.Driver{}, observer: options.observer})
 	if err := database.Ping(); err != nil {
 		_ = database.Close()
 		return nil, err
@@ -38,6 +42,10 @@ func Init(ctx context.Context, db *sql.DB) error {
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
 			username TEXT NOT NULL UNIQUE,
 			password_hash TEXT NOT NULL,
+			email TEXT NOT NULL DEFAULT '',
+			role TEXT NOT NULL DEFAULT 'admin',
+			source TEXT NOT NULL DEFAULT 'local',
+			disabled INTEGER NOT NULL DEFAULT 0,
 			created_at DATETIME NOT NULL DEFAULT (CURRENT_TIMESTAMP)
 		);`,
 		`CREATE TABLE IF NOT EXISTS links (
@@ -47,6 +55,9 @@ func Init(ctx context.Context, db *sql.DB) error {
 			remark TEXT NOT NULL DEFAULT '',
 			tags_json TEXT NOT NULL DEFAULT '[]',
 			enabled INTEGER NOT NULL DEFAULT 1,
+			no_tracking INTEGER NOT NULL DEFAULT 0,
+			expires_at TEXT,
+			expiry_claimed INTEGER NOT NULL DEFAULT 0,
 			click_count INTEGER NOT NULL DEFAULT 0,
 			created_at DATETIME NOT NULL DEFAULT (CURRENT_TIMESTAMP),
 			updated_at DATETIME NOT NULL DEFAULT (CURRENT_TIMESTAMP)
@@ -59,16 +70,118 @@ func Init(ctx context.Context, db *sql.DB) error {
 			referer_host TEXT NOT NULL DEFAULT '',
 			user_agent TEXT NOT NULL DEFAULT '',
 			client_name TEXT NOT NULL DEFAULT '',
+			client_version TEXT NOT NULL DEFAULT '',
 			client_type TEXT NOT NULL DEFAULT '',
 			device_type TEXT NOT NULL DEFAULT '',
+			device_brand TEXT NOT NULL DEFAULT '',
+			device_model TEXT NOT NULL DEFAULT '',
 			os TEXT NOT NULL DEFAULT '',
+			os_version TEXT NOT NULL DEFAULT '',
+			utm_source TEXT NOT NULL DEFAULT '',
+			utm_medium TEXT NOT NULL DEFAULT '',
+			utm_campaign TEXT NOT NULL DEFAULT '',
+			utm_term TEXT NOT NULL DEFAULT '',
+			utm_content TEXT NOT NULL DEFAULT '',
+			extra_params TEXT NOT NULL DEFAULT '{}',
+			country_code TEXT NOT NULL DEFAULT '',
+			region TEXT NOT NULL DEFAULT '',
+			city TEXT NOT NULL DEFAULT '',
+			asn INTEGER NOT NULL DEFAULT 0,
+			as_org TEXT NOT NULL DEFAULT '',
+			visitor_id TEXT NOT NULL DEFAULT '',
+			traffic_type TEXT NOT NULL DEFAULT 'human',
+			ua_rules_version TEXT NOT NULL DEFAULT '',
+			language TEXT NOT NULL DEFAULT '',
+			click_id TEXT NOT NULL DEFAULT '',
 			visited_at DATETIME NOT NULL DEFAULT (CURRENT_TIMESTAMP),
 			FOREIGN KEY (link_id) REFERENCES links(id) ON DELETE CASCADE
 		);`,
+		`CREATE TABLE IF NOT EXISTS conversions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			visit_id INTEGER NOT NULL UNIQUE,
+			value REAL NOT NULL DEFAULT 0,
+			converted_at TEXT NOT NULL,
+			FOREIGN KEY (visit_id) REFERENCES link_visits(id) ON DELETE CASCADE
+		);`,
+		`CREATE TABLE IF NOT EXISTS login_attempts (
+			scope TEXT NOT NULL,
+			key TEXT NOT NULL,
+			failures INTEGER NOT NULL DEFAULT 0,
+			last_failed_at TEXT NOT NULL,
+			locked_until TEXT,
+			PRIMARY KEY (scope, key)
+		);`,
+		`CREATE TABLE IF NOT EXISTS sessions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			token TEXT NOT NULL UNIQUE,
+			username TEXT NOT NULL DEFAULT '',
+			data TEXT NOT NULL,
+			ip TEXT NOT NULL DEFAULT '',
+			user_agent TEXT NOT NULL DEFAULT '',
+			created_at TEXT NOT NULL,
+			last_seen_at TEXT NOT NULL,
+			expires_at TEXT NOT NULL
+		);`,
+		`CREATE TABLE IF NOT EXISTS link_tags (
+			link_id INTEGER NOT NULL,
+			tag TEXT NOT NULL,
+			PRIMARY KEY (tag, link_id),
+			FOREIGN KEY (link_id) REFERENCES links(id) ON DELETE CASCADE
+		);`,
+		`CREATE TABLE IF NOT EXISTS audit_logs (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			actor TEXT NOT NULL DEFAULT '',
+			action TEXT NOT NULL,
+			target_type TEXT NOT NULL DEFAULT '',
+			target_id TEXT NOT NULL DEFAULT '',
+			before_json TEXT,
+			after_json TEXT,
+			ip TEXT NOT NULL DEFAULT '',
+			user_agent TEXT NOT NULL DEFAULT '',
+			created_at TEXT NOT NULL
+		);`,
+		`CREATE TABLE IF NOT EXISTS webhooks (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			url TEXT NOT NULL,
+			secret TEXT NOT NULL,
+			events_json TEXT NOT NULL DEFAULT '[]',
+			enabled INTEGER NOT NULL DEFAULT 1,
+			created_at TEXT NOT NULL,
+			updated_at TEXT NOT NULL
+		);`,
+		`CREATE TABLE IF NOT EXISTS webhook_deliveries (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			webhook_id INTEGER NOT NULL,
+			event_id TEXT NOT NULL,
+			event_type TEXT NOT NULL,
+			payload TEXT NOT NULL,
+			status TEXT NOT NULL,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			next_attempt_at TEXT,
+			response_status INTEGER NOT NULL DEFAULT 0,
+			last_error TEXT NOT NULL DEFAULT '',
+			created_at TEXT NOT NULL,
+			delivered_at TEXT,
+			FOREIGN KEY (webhook_id) REFERENCES webhooks(id) ON DELETE CASCADE
+		);`,
+		`CREATE TRIGGER IF NOT EXISTS trg_audit_logs_no_update BEFORE UPDATE ON audit_logs
+		 BEGIN SELECT RAISE(ABORT, 'audit_logs is append-only'); END;`,
+		`CREATE TRIGGER IF NOT EXISTS trg_audit_logs_no_delete BEFORE DELETE ON audit_logs
+		 BEGIN SELECT RAISE(ABORT, 'audit_logs is append-only'); END;`,
 		`CREATE INDEX IF NOT EXISTS idx_links_code ON links(code);`,
 		`CREATE INDEX IF NOT EXISTS idx_link_visits_link_visited_at ON link_visits(link_id, visited_at DESC);`,
+		`CREATE INDEX IF NOT EXISTS idx_link_visits_visited_at ON link_visits(visited_at);`,
 		`CREATE INDEX IF NOT EXISTS idx_link_visits_link_referer_host ON link_visits(link_id, referer_host);`,
 		`CREATE INDEX IF NOT EXISTS idx_link_visits_link_client_name ON link_visits(link_id, client_name);`,
+		`CREATE INDEX IF NOT EXISTS idx_link_tags_link ON link_tags(link_id);`,
+		`CREATE INDEX IF NOT EXISTS idx_login_attempts_locked_until ON login_attempts(locked_until);`,
+		`CREATE INDEX IF NOT EXISTS idx_sessions_username ON sessions(username, last_seen_at DESC);`,
+		`CREATE INDEX IF NOT EXISTS idx_sessions_expires_at ON sessions(expires_at);`,
+		`CREATE INDEX IF NOT EXISTS idx_audit_logs_created_at ON audit_logs(created_at);`,
+		`CREATE INDEX IF NOT EXISTS idx_audit_logs_actor ON audit_logs(actor, id);`,
+		`CREATE INDEX IF NOT EXISTS idx_audit_logs_target ON audit_logs(target_type, target_id, id);`,
+		`CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries(status, next_attempt_at);`,
+		`CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_webhook ON webhook_deliveries(webhook_id, id);`,
 	}
 
 	for _, statement := range statements {
@@ -77,16 +190,72 @@ func Init(ctx context.Context, db *sql.DB) error {
 		}
 	}
 
-	if err := ensureColumn(ctx, db, "links", "remark", `ALTER TABLE links ADD COLUMN remark TEXT NOT NULL DEFAULT ''`); err != nil {
-		return err
+	columns := []struct {
+		table    string
+		column   string
+		alterSQL string
+	}{
+		{"users", "email", `ALTER TABLE users ADD COLUMN email TEXT NOT NULL DEFAULT ''`},
+		{"users", "role", `ALTER TABLE users ADD COLUMN role TEXT NOT NULL DEFAULT 'admin'`},
+		{"users", "source", `ALTER TABLE users ADD COLUMN source TEXT NOT NULL DEFAULT 'local'`},
+		{"users", "disabled", `ALTER TABLE users ADD COLUMN disabled INTEGER NOT NULL DEFAULT 0`},
+		{"links", "remark", `ALTER TABLE links ADD COLUMN remark TEXT NOT NULL DEFAULT ''`},
+		{"links", "tags_json", `ALTER TABLE links ADD COLUMN tags_json TEXT NOT NULL DEFAULT '[]'`},
+		{"links", "no_tracking", `ALTER TABLE links ADD COLUMN no_tracking INTEGER NOT NULL DEFAULT 0`},
+		{"links", "expires_at", `ALTER TABLE links ADD COLUMN expires_at TEXT`},
+		{"links", "expiry_claimed", `ALTER TABLE links ADD COLUMN expiry_claimed INTEGER NOT NULL DEFAULT 0`},
+		{"link_visits", "utm_source", `ALTER TABLE link_visits ADD COLUMN utm_source TEXT NOT NULL DEFAULT ''`},
+		{"link_visits", "utm_medium", `ALTER TABLE link_visits ADD COLUMN utm_medium TEXT NOT NULL DEFAULT ''`},
+		{"link_visits", "utm_campaign", `ALTER TABLE link_visits ADD COLUMN utm_campaign TEXT NOT NULL DEFAULT ''`},
+		{"link_visits", "utm_term", `ALTER TABLE link_visits ADD COLUMN utm_term TEXT NOT NULL DEFAULT ''`},
+		{"link_visits", "utm_content", `ALTER TABLE link_visits ADD COLUMN utm_content TEXT NOT NULL DEFAULT ''`},
+		{"link_visits", "extra_params", `ALTER TABLE link_visits ADD COLUMN extra_params TEXT NOT NULL DEFAULT '{}'`},
+		{"link_visits", "country_code", `ALTER TABLE link_visits ADD COLUMN country_code TEXT NOT NULL DEFAULT ''`},
+		{"link_visits", "region", `ALTER TABLE link_visits ADD COLUMN region TEXT NOT NULL DEFAULT ''`},
+		{"link_visits", "city", `ALTER TABLE link_visits ADD COLUMN city TEXT NOT NULL DEFAULT ''`},
+		{"link_visits", "asn", `ALTER TABLE link_visits ADD COLUMN asn INTEGER NOT NULL DEFAULT 0`},
+		{"link_visits", "as_org", `ALTER TABLE link_visits ADD COLUMN as_org TEXT NOT NULL DEFAULT ''`},
+		{"link_visits", "visitor_id", `ALTER TABLE link_visits ADD COLUMN visitor_id TEXT NOT NULL DEFAULT ''`},
+		{"link_visits", "traffic_type", `ALTER TABLE link_visits ADD COLUMN traffic_type TEXT NOT NULL DEFAULT 'human'`},
+		{"link_visits", "client_version", `ALTER TABLE link_visits ADD COLUMN client_version TEXT NOT NULL DEFAULT ''`},
+		{"link_visits", "device_brand", `ALTER TABLE link_visits ADD COLUMN device_brand TEXT NOT NULL DEFAULT ''`},
+		{"link_visits", "device_model", `ALTER TABLE link_visits ADD COLUMN device_model TEXT NOT NULL DEFAULT ''`},
+		{"link_visits", "os_version", `ALTER TABLE link_visits ADD COLUMN os_version TEXT NOT NULL DEFAULT ''`},
+		{"link_visits", "ua_rules_version", `ALTER TABLE link_visits ADD COLUMN ua_rules_version TEXT NOT NULL DEFAULT ''`},
+		{"link_visits", "language", `ALTER TABLE link_visits ADD COLUMN language TEXT NOT NULL DEFAULT ''`},
+		{"link_visits", "click_id", `ALTER TABLE link_visits ADD COLUMN click_id TEXT NOT NULL DEFAULT ''`},
 	}
-	if err := ensureColumn(ctx, db, "links", "tags_json", `ALTER TABLE links ADD COLUMN tags_json TEXT NOT NULL DEFAULT '[]'`); err != nil {
-		return err
+	for _, column := range columns {
+		if err := ensureColumn(ctx, db, column.table, column.column, column.alterSQL); err != nil {
+			return err
+		}
+	}
+
+	// Indexes on migrated columns can only be created once the columns exist.
+	// link_tags mirrors links.tags_json; the backfill is idempotent and picks up
+	// links written before the table existed.
+	for _, statement := range []string{
+		`CREATE INDEX IF NOT EXISTS idx_links_expires_at ON links(expires_at) WHERE expires_at IS NOT NULL;`,
+		`CREATE INDEX IF NOT EXISTS idx_link_visits_link_visitor ON link_visits(link_id, visitor_id, visited_at);`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_link_visits_click_id ON link_visits(click_id) WHERE click_id <> '';`,
+		`INSERT OR IGNORE INTO link_tags(link_id, tag)
+		 SELECT l.id, t.value FROM links l, json_each(l.tags_json) t WHERE t.type = 'text' AND t.value <> '';`,
+	} {
+		if _, err := db.ExecContext(ctx, statement); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
+// sqliteTimeLayout is fixed-width so stored timestamps compare correctly as text.
+const sqliteTimeLayout = "2006-01-02T15:04:05.000000000Z"
+
+func formatSQLiteTime(t time.Time) string {
+	return t.UTC().Format(sqliteTimeLayout)
+}
+
 func isUniqueConstraintError(err error) bool {
 	if err == nil {
 		return false
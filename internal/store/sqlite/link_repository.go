@@ -6,6 +6,8 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/mine/shorturl/internal/links"
@@ -22,7 +24,7 @@ func NewLinkRepository(db *sql.DB) *LinkRepository {
 func (r *LinkRepository) ListLinks(ctx context.Context, limit int) ([]links.Link, error) {
 	rows, err := r.db.QueryContext(
 		ctx,
-		`SELECT id, code, target_url, remark, tags_json, enabled, click_count, created_at, updated_at
+		`SELECT id, code, target_url, remark, tags_json, enabled, no_tracking, expires_at, click_count, created_at, updated_at
 		 FROM links
 		 ORDER BY id DESC
 		 LIMIT ?`,
@@ -48,7 +50,7 @@ func (r *LinkRepository) ListLinks(ctx context.Context, limit int) ([]links.Link
 func (r *LinkRepository) GetLinkByID(ctx context.Context, id int64) (links.Link, error) {
 	row := r.db.QueryRowContext(
 		ctx,
-		`SELECT id, code, target_url, remark, tags_json, enabled, click_count, created_at, updated_at
+		`SELECT id, code, target_url, remark, tags_json, enabled, no_tracking, expires_at, click_count, created_at, updated_at
 		 FROM links
 		 WHERE id = ?`,
 		id,
@@ -68,7 +70,7 @@ func (r *LinkRepository) GetLinkByID(ctx context.Context, id int64) (links.Link,
 func (r *LinkRepository) GetLinkByCode(ctx context.Context, code string) (links.Link, error) {
 	row := r.db.QueryRowContext(
 		ctx,
-		`SELECT id, code, target_url, remark, tags_json, enabled, click_count, created_at, updated_at
+		`SELECT id, code, target_url, remark, tags_json, enabled, no_tracking, expires_at, click_count, created_at, updated_at
 		 FROM links
 		 WHERE code = ?`,
 		code,
@@ -85,19 +87,28 @@ func (r *LinkRepository) GetLinkByCode(ctx context.Context, code string) (links.
 	return link, nil
 }
 
-func (r *LinkRepository) CreateLink(ctx context.Context, code string, targetURL string, remark string, tags []string) (links.Link, error) {
-	tagsJSON, err := json.Marshal(tags)
+func (r *LinkRepository) CreateLink(ctx context.Context, link links.Link) (links.Link, error) {
+	tagsJSON, err := json.Marshal(link.Tags)
 	if err != nil {
 		return links.Link{}, fmt.Errorf("marshal link tags: %w", err)
 	}
 
-	result, err := r.db.ExecContext(
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return links.Link{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	result, err := tx.ExecContext(
 		ctx,
-		`INSERT INTO links(code, target_url, remark, tags_json, enabled) VALUES(?, ?, ?, ?, 1)`,
-		code,
-		targetURL,
-		remark,
+		`INSERT INTO links(code, target_url, remark, tags_json, enabled, no_tracking, expires_at) VALUES(?, ?, ?, ?, ?, ?, ?)`,
+		link.Code,
+		link.TargetURL,
+		link.Remark,
 		string(tagsJSON),
+		boolToInt(link.Enabled),
+		boolToInt(link.NoTracking),
+		nullableTime(link.ExpiresAt),
 	)
 	if err != nil {
 		if isUniqueConstraintError(err) {
@@ -110,31 +121,43 @@ func (r *LinkRepository) CreateLink(ctx context.Context, code string, targetURL
 	if err != nil {
 		return links.Link{}, err
 	}
+	if err := replaceLinkTags(ctx, tx, id, link.Tags); err != nil {
+		return links.Link{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return links.Link{}, err
+	}
 
 	return r.GetLinkByID(ctx, id)
 }
 
 func (r *LinkRepository) UpdateLink(ctx context.Context, link links.Link) (links.Link, error) {
-	enabled := 0
-	if link.Enabled {
-		enabled = 1
-	}
-
 	tagsJSON, err := json.Marshal(link.Tags)
 	if err != nil {
 		return links.Link{}, fmt.Errorf("marshal link tags: %w", err)
 	}
 
-	result, err := r.db.ExecContext(
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return links.Link{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	result, err := tx.ExecContext(
 		ctx,
 		`UPDATE links
-		 SET code = ?, target_url = ?, remark = ?, tags_json = ?, enabled = ?, updated_at = CURRENT_TIMESTAMP
+		 SET code = ?, target_url = ?, remark = ?, tags_json = ?, enabled = ?, no_tracking = ?,
+			expiry_claimed = CASE WHEN expires_at IS ? THEN expiry_claimed ELSE 0 END, expires_at = ?,
+			updated_at = CURRENT_TIMESTAMP
 		 WHERE id = ?`,
 		link.Code,
 		link.TargetURL,
 		link.Remark,
 		string(tagsJSON),
-		enabled,
+		boolToInt(link.Enabled),
+		boolToInt(link.NoTracking),
+		nullableTime(link.ExpiresAt),
+		nullableTime(link.ExpiresAt),
 		link.ID,
 	)
 	if err != nil {
@@ -151,10 +174,29 @@ func (r *LinkRepository) UpdateLink(ctx context.Context, link links.Link) (links
 	if rowsAffected == 0 {
 		return links.Link{}, links.ErrLinkNotFound
 	}
+	if err := replaceLinkTags(ctx, tx, link.ID, link.Tags); err != nil {
+		return links.Link{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return links.Link{}, err
+	}
 
 	return r.GetLinkByID(ctx, link.ID)
 }
 
+// replaceLinkTags keeps link_tags in step with links.tags_json.
+func replaceLinkTags(ctx context.Context, tx *sql.Tx, linkID int64, tags []string) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM link_tags WHERE link_id = ?`, linkID); err != nil {
+		return err
+	}
+	for _, tag := range tags {
+		if _, err := tx.ExecContext(ctx, `INSERT OR IGNORE INTO link_tags(link_id, tag) VALUES(?, ?)`, linkID, tag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (r *LinkRepository) DeleteLink(ctx context.Context, id int64) error {
 	result, err := r.db.ExecContext(ctx, `DELETE FROM links WHERE id = ?`, id)
 	if err != nil {
@@ -172,31 +214,113 @@ func (r *LinkRepository) DeleteLink(ctx context.Context, id int64) error {
 	return nil
 }
 
+// ClaimExpiredLinks marks expired links as reported in the same transaction
+// that reads them. Moving expires_at clears the mark, see UpdateLink.
+func (r *LinkRepository) ClaimExpiredLinks(ctx context.Context, now time.Time) ([]links.Link, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	rows, err := tx.QueryContext(
+		ctx,
+		`SELECT id, code, target_url, remark, tags_json, enabled, no_tracking, expires_at, click_count, created_at, updated_at
+		 FROM links
+		 WHERE expires_at IS NOT NULL AND expires_at <= ? AND expiry_claimed = 0
+		 ORDER BY expires_at, id`,
+		formatSQLiteTime(now),
+	)
+	if err != nil {
+		return nil, err
+	}
+	expired := []links.Link{}
+	for rows.Next() {
+		link, err := scanLink(rows)
+		if err != nil {
+			_ = rows.Close()
+			return nil, err
+		}
+		expired = append(expired, link)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, link := range expired {
+		if _, err := tx.ExecContext(ctx, `UPDATE links SET expiry_claimed = 1 WHERE id = ?`, link.ID); err != nil {
+			return nil, err
+		}
+	}
+	return expired, tx.Commit()
+}
+
 func (r *LinkRepository) IncrementClick(ctx context.Context, id int64) error {
 	_, err := r.db.ExecContext(ctx, `UPDATE links SET click_count = click_count + 1 WHERE id = ?`, id)
 	return err
 }
 
 func (r *LinkRepository) RecordVisit(ctx context.Context, linkID int64, meta links.VisitMeta) error {
-	_, err := r.db.ExecContext(
+	extraParams := meta.ExtraParams
+	if extraParams == nil {
+		extraParams = map[string]string{}
+	}
+	extraJSON, err := json.Marshal(extraParams)
+	if err != nil {
+		return err
+	}
+	trafficType := meta.TrafficType
+	if trafficType == "" {
+		trafficType = links.TrafficHuman
+	}
+
+	_, err = r.db.ExecContext(
 		ctx,
-		`INSERT INTO link_visits(link_id, ip, referer, referer_host, user_agent, client_name, client_type, device_type, os, visited_at)
-		 VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		`INSERT INTO link_visits(
+			link_id, ip, referer, referer_host, user_agent, client_name, client_version, client_type,
+			device_type, device_brand, device_model, os, os_version, ua_rules_version, language, click_id,
+			utm_source, utm_medium, utm_campaign, utm_term, utm_content, extra_params,
+			country_code, region, city, asn, as_org, visitor_id, traffic_type, visited_at
+		 )
+		 VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
 		linkID,
 		meta.IP,
 		meta.Referer,
 		meta.RefererHost,
 		meta.UserAgent,
 		meta.ClientName,
+		meta.ClientVersion,
 		meta.ClientType,
 		meta.DeviceType,
+		meta.DeviceBrand,
+		meta.DeviceModel,
 		meta.OS,
+		meta.OSVersion,
+		meta.RulesVersion,
+		meta.Language,
+		meta.ClickID,
+		meta.UTMSource,
+		meta.UTMMedium,
+		meta.UTMCampaign,
+		meta.UTMTerm,
+		meta.UTMContent,
+		string(extraJSON),
+		meta.Geo.CountryCode,
+		meta.Geo.Region,
+		meta.Geo.City,
+		meta.Geo.ASN,
+		meta.Geo.ASOrg,
+		meta.VisitorID,
+		trafficType,
 		meta.VisitedAt.UTC().Format(time.RFC3339Nano),
 	)
 	return err
 }
 
-func (r *LinkRepository) GetLinkAnalytics(ctx context.Context, id int64, since time.Time, limit int) (links.LinkAnalytics, error) {
+func (r *LinkRepository) GetLinkAnalytics(ctx context.Context, id int64, window links.AnalyticsWindow, limit int) (links.LinkAnalytics, error) {
 	link, err := r.GetLinkByID(ctx, id)
 	if err != nil {
 		return links.LinkAnalytics{}, err
@@ -209,15 +333,18 @@ func (r *LinkRepository) GetLinkAnalytics(ctx context.Context, id int64, since t
 		TopClients:   []links.VisitBreakdown{},
 		RecentVisits: []links.VisitRecord{},
 	}
+	scope := visitScope{linkID: id, from: formatSQLiteTime(window.From), to: formatSQLiteTime(window.To)}
+	if !window.IncludeBots {
+		scope.filter = humanTrafficFilter
+	}
 
 	var lastVisitedAt sql.NullString
 	if err := r.db.QueryRowContext(
 		ctx,
 		`SELECT COUNT(*), COUNT(DISTINCT CASE WHEN ip <> '' THEN ip END), COALESCE(MAX(strftime('%Y-%m-%dT%H:%M:%fZ', visited_at)), '')
 		 FROM link_visits
-		 WHERE link_id = ? AND visited_at >= ?`,
-		id,
-		since.UTC(),
+		 WHERE `+scope.where(),
+		scope.args()...,
 	).Scan(&analytics.RecentClicks, &analytics.UniqueIPs, &lastVisitedAt); err != nil {
 		return links.LinkAnalytics{}, err
 	}
@@ -229,101 +356,84 @@ func (r *LinkRepository) GetLinkAnalytics(ctx context.Context, id int64, since t
 		analytics.LastVisitedAt = &value
 	}
 
-	timeSeriesRows, err := r.db.QueryContext(
+	// A visitor is returning when they visited this link before the window.
+	if err := r.db.QueryRowContext(
 		ctx,
-		`SELECT DATE(visited_at) AS bucket, COUNT(*)
-		 FROM link_visits
-		 WHERE link_id = ? AND visited_at >= ?
-		 GROUP BY DATE(visited_at)
-		 ORDER BY bucket ASC`,
-		id,
-		since.UTC(),
-	)
+		`WITH window_visitors AS (
+			SELECT DISTINCT visitor_id
+			FROM link_visits
+			WHERE `+scope.where()+` AND visitor_id <> ''
+		 )
+		 SELECT COUNT(*), COALESCE(SUM(CASE WHEN EXISTS (
+			SELECT 1 FROM link_visits p WHERE p.link_id = ? AND p.visitor_id = w.visitor_id AND p.visited_at < ?`+scope.filter+`
+		 ) THEN 1 ELSE 0 END), 0)
+		 FROM window_visitors w`,
+		append(scope.args(), id, scope.from)...,
+	).Scan(&analytics.UniqueVisitors, &analytics.ReturningVisitors); err != nil {
+		return links.LinkAnalytics{}, err
+	}
+	analytics.NewVisitors = analytics.UniqueVisitors - analytics.ReturningVisitors
+
+	timeSeries, err := r.bucketVisits(ctx, scope, window.Buckets())
 	if err != nil {
 		return links.LinkAnalytics{}, err
 	}
-	defer timeSeriesRows.Close()
+	analytics.TimeSeries = timeSeries
 
-	seriesByDate := make(map[string]int64)
-	for timeSeriesRows.Next() {
-		var point links.VisitPoint
-		if err := timeSeriesRows.Scan(&point.Bucket, &point.Clicks); err != nil {
-			return links.LinkAnalytics{}, err
-		}
-		seriesByDate[point.Bucket] = point.Clicks
+	if analytics.TopReferrers, err = r.topBreakdown(ctx, scope, "referer_host", "直接访问"); err != nil {
+		return links.LinkAnalytics{}, err
 	}
-	if err := timeSeriesRows.Err(); err != nil {
+	if analytics.TopClients, err = r.topBreakdown(ctx, scope, "client_name", "未知客户端"); err != nil {
 		return links.LinkAnalytics{}, err
 	}
-
-	totalDays := max(int(time.Now().UTC().Sub(since.UTC()).Hours()/24)+1, 1)
-	for day := 0; day < totalDays; day++ {
-		bucket := since.UTC().AddDate(0, 0, day).Format("2006-01-02")
-		analytics.TimeSeries = append(analytics.TimeSeries, links.VisitPoint{
-			Bucket: bucket,
-			Clicks: seriesByDate[bucket],
-		})
+	if analytics.TopClientTypes, err = r.topBreakdown(ctx, scope, "client_type", "unknown"); err != nil {
+		return links.LinkAnalytics{}, err
 	}
-
-	referrerRows, err := r.db.QueryContext(
-		ctx,
-		`SELECT CASE WHEN referer_host = '' THEN '直接访问' ELSE referer_host END AS referrer_name, COUNT(*) AS total
-		 FROM link_visits
-		 WHERE link_id = ? AND visited_at >= ?
-		 GROUP BY referrer_name
-		 ORDER BY total DESC, referrer_name ASC
-		 LIMIT 8`,
-		id,
-		since.UTC(),
-	)
-	if err != nil {
+	if analytics.TopDevices, err = r.topBreakdown(ctx, scope, "device_type", "unknown"); err != nil {
 		return links.LinkAnalytics{}, err
 	}
-	defer referrerRows.Close()
-
-	for referrerRows.Next() {
-		var item links.VisitBreakdown
-		if err := referrerRows.Scan(&item.Name, &item.Count); err != nil {
-			return links.LinkAnalytics{}, err
-		}
-		analytics.TopReferrers = append(analytics.TopReferrers, item)
+	if analytics.TopOS, err = r.topBreakdown(ctx, scope, "os", "未知系统"); err != nil {
+		return links.LinkAnalytics{}, err
 	}
-	if err := referrerRows.Err(); err != nil {
+	if analytics.TopClientVersions, err = r.topBreakdown(ctx, scope, "TRIM(client_name || ' ' || client_version)", "未知客户端"); err != nil {
 		return links.LinkAnalytics{}, err
 	}
-
-	clientRows, err := r.db.QueryContext(
-		ctx,
-		`SELECT CASE WHEN client_name = '' THEN '未知客户端' ELSE client_name END AS client_name, COUNT(*) AS total
-		 FROM link_visits
-		 WHERE link_id = ? AND visited_at >= ?
-		 GROUP BY client_name
-		 ORDER BY total DESC, client_name ASC
-		 LIMIT 8`,
-		id,
-		since.UTC(),
-	)
-	if err != nil {
+	if analytics.TopOSVersions, err = r.topBreakdown(ctx, scope, "TRIM(os || ' ' || os_version)", "未知系统"); err != nil {
 		return links.LinkAnalytics{}, err
 	}
-	defer clientRows.Close()
-
-	for clientRows.Next() {
-		var item links.VisitBreakdown
-		if err := clientRows.Scan(&item.Name, &item.Count); err != nil {
-			return links.LinkAnalytics{}, err
-		}
-		analytics.TopClients = append(analytics.TopClients, item)
+	if analytics.TopDeviceModels, err = r.topBreakdown(ctx, scope, "TRIM(device_brand || ' ' || device_model)", "未知设备"); err != nil {
+		return links.LinkAnalytics{}, err
+	}
+	if analytics.TopLanguages, err = r.languageBreakdown(ctx, scope); err != nil {
+		return links.LinkAnalytics{}, err
+	}
+	if analytics.Conversions, err = r.conversionSummary(ctx, scope, analytics.RecentClicks); err != nil {
+		return links.LinkAnalytics{}, err
+	}
+	if analytics.ConversionsByReferrer, err = r.conversionBreakdown(ctx, scope, "referer_host", "直接访问"); err != nil {
+		return links.LinkAnalytics{}, err
+	}
+	if analytics.Campaigns, err = r.campaignBreakdowns(ctx, scope); err != nil {
+		return links.LinkAnalytics{}, err
+	}
+	if analytics.TopCountries, err = r.topBreakdown(ctx, scope, "country_code", "未知"); err != nil {
+		return links.LinkAnalytics{}, err
+	}
+	if analytics.TopCities, err = r.topBreakdown(ctx, scope, "CASE WHEN city = '' THEN '' ELSE city || ', ' || country_code END", "未知"); err != nil {
+		return links.LinkAnalytics{}, err
 	}
-	if err := clientRows.Err(); err != nil {
+	if analytics.BotTraffic, err = r.botTraffic(ctx, scope); err != nil {
 		return links.LinkAnalytics{}, err
 	}
 
 	visitRows, err := r.db.QueryContext(
 		ctx,
-		`SELECT strftime('%Y-%m-%dT%H:%M:%fZ', visited_at), ip, referer, referer_host, user_agent, client_name, client_type, device_type, os
+		`SELECT strftime('%Y-%m-%dT%H:%M:%fZ', visited_at), ip, referer, referer_host, user_agent,
+			client_name, client_version, client_type, device_type, device_brand, device_model, os, os_version, language,
+			utm_source, utm_medium, utm_campaign, utm_term, utm_content,
+			country_code, region, city, asn, as_org, traffic_type
 		 FROM link_visits
-		 WHERE link_id = ?
+		 WHERE link_id = ?`+scope.filter+`
 		 ORDER BY visited_at DESC
 		 LIMIT ?`,
 		id,
@@ -347,9 +457,25 @@ func (r *LinkRepository) GetLinkAnalytics(ctx context.Context, id int64, since t
 			&record.RefererHost,
 			&record.UserAgent,
 			&record.ClientName,
+			&record.ClientVersion,
 			&record.ClientType,
 			&record.DeviceType,
+			&record.DeviceBrand,
+			&record.DeviceModel,
 			&record.OS,
+			&record.OSVersion,
+			&record.Language,
+			&record.UTMSource,
+			&record.UTMMedium,
+			&record.UTMCampaign,
+			&record.UTMTerm,
+			&record.UTMContent,
+			&record.CountryCode,
+			&record.Region,
+			&record.City,
+			&record.ASN,
+			&record.ASOrg,
+			&record.TrafficType,
 		); err != nil {
 			return links.LinkAnalytics{}, err
 		}
@@ -368,6 +494,931 @@ func (r *LinkRepository) GetLinkAnalytics(ctx context.Context, id int64, since t
 	return analytics, nil
 }
 
+const humanTrafficFilter = ` AND traffic_type = '` + links.TrafficHuman + `'`
+
+// visitScope selects visits in [from, to) for one link, for the links carrying
+// tag, or for every link when neither is set. filter is an extra trusted
+// condition appended to the WHERE clause, such as humanTrafficFilter, and
+// filterArgs are its placeholders' values. Queries that splice filter into a
+// subquery of their own only work with argument-free filters.
+type visitScope struct {
+	linkID     int64
+	tag        string
+	from       string
+	to         string
+	filter     string
+	filterArgs []any
+}
+
+// links returns the condition restricting column to the scope's links.
+func (s visitScope) links(column string) (string, []any) {
+	switch {
+	case s.linkID != 0:
+		return column + ` = ? AND `, []any{s.linkID}
+	case s.tag != "":
+		return column + ` IN (SELECT link_id FROM link_tags WHERE tag = ?) AND `, []any{s.tag}
+	default:
+		return "", nil
+	}
+}
+
+func (s visitScope) where() string {
+	condition, _ := s.links("link_id")
+	return condition + `visited_at >= ? AND visited_at < ?` + s.filter
+}
+
+func (s visitScope) args() []any {
+	_, args := s.links("link_id")
+	return append(append(args, s.from, s.to), s.filterArgs...)
+}
+
+func (r *LinkRepository) GetOverview(ctx context.Context, window links.AnalyticsWindow) (links.Overview, error) {
+	scope := visitScope{from: formatSQLiteTime(window.From), to: formatSQLiteTime(window.To)}
+	if !window.IncludeBots {
+		scope.filter = humanTrafficFilter
+	}
+
+	var overview links.Overview
+	if err := r.db.QueryRowContext(
+		ctx,
+		`SELECT COUNT(*), COUNT(DISTINCT NULLIF(visitor_id, '')) FROM link_visits WHERE `+scope.where(),
+		scope.args()...,
+	).Scan(&overview.TotalClicks, &overview.UniqueVisitors); err != nil {
+		return links.Overview{}, err
+	}
+
+	// links.created_at is written by CURRENT_TIMESTAMP with whole seconds, so
+	// compare in that format and keep the second holding To.
+	if err := r.db.QueryRowContext(
+		ctx,
+		`SELECT COUNT(*) FROM links WHERE created_at >= ? AND created_at <= ?`,
+		window.From.UTC().Format(time.DateTime),
+		window.To.UTC().Format(time.DateTime),
+	).Scan(&overview.NewLinks); err != nil {
+		return links.Overview{}, err
+	}
+
+	var err error
+	if overview.TimeSeries, err = r.bucketVisits(ctx, scope, window.Buckets()); err != nil {
+		return links.Overview{}, err
+	}
+	if overview.TopLinks, err = r.topBreakdown(ctx, scope, "(SELECT code FROM links WHERE links.id = link_visits.link_id)", "已删除"); err != nil {
+		return links.Overview{}, err
+	}
+	if overview.TopReferrers, err = r.topBreakdown(ctx, scope, "referer_host", "直接访问"); err != nil {
+		return links.Overview{}, err
+	}
+	if overview.TopClients, err = r.topBreakdown(ctx, scope, "client_name", "未知客户端"); err != nil {
+		return links.Overview{}, err
+	}
+	if overview.TopDevices, err = r.topBreakdown(ctx, scope, "device_type", "unknown"); err != nil {
+		return links.Overview{}, err
+	}
+	if overview.TopOS, err = r.topBreakdown(ctx, scope, "os", "未知系统"); err != nil {
+		return links.Overview{}, err
+	}
+	if overview.TopLanguages, err = r.languageBreakdown(ctx, scope); err != nil {
+		return links.Overview{}, err
+	}
+	if overview.Conversions, err = r.conversionSummary(ctx, scope, overview.TotalClicks); err != nil {
+		return links.Overview{}, err
+	}
+	if overview.ConversionsByLink, err = r.conversionBreakdown(ctx, scope, "(SELECT code FROM links WHERE links.id = link_visits.link_id)", "已删除"); err != nil {
+		return links.Overview{}, err
+	}
+
+	return overview, nil
+}
+
+func (r *LinkRepository) GetTagAnalytics(ctx context.Context, tag string, window links.AnalyticsWindow) (links.TagAnalytics, error) {
+	scope := visitScope{tag: tag, from: formatSQLiteTime(window.From), to: formatSQLiteTime(window.To)}
+	if !window.IncludeBots {
+		scope.filter = humanTrafficFilter
+	}
+
+	analytics := links.TagAnalytics{Tag: tag}
+	if err := r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM link_tags WHERE tag = ?`, tag).Scan(&analytics.LinkCount); err != nil {
+		return links.TagAnalytics{}, err
+	}
+	if err := r.db.QueryRowContext(
+		ctx,
+		`SELECT COUNT(*), COUNT(DISTINCT NULLIF(visitor_id, '')) FROM link_visits WHERE `+scope.where(),
+		scope.args()...,
+	).Scan(&analytics.TotalClicks, &analytics.UniqueVisitors); err != nil {
+		return links.TagAnalytics{}, err
+	}
+
+	var err error
+	if analytics.TimeSeries, err = r.bucketVisits(ctx, scope, window.Buckets()); err != nil {
+		return links.TagAnalytics{}, err
+	}
+	if analytics.TopLinks, err = r.topBreakdown(ctx, scope, "(SELECT code FROM links WHERE links.id = link_visits.link_id)", "已删除"); err != nil {
+		return links.TagAnalytics{}, err
+	}
+	if analytics.TopReferrers, err = r.topBreakdown(ctx, scope, "referer_host", "直接访问"); err != nil {
+		return links.TagAnalytics{}, err
+	}
+	if analytics.TopLanguages, err = r.languageBreakdown(ctx, scope); err != nil {
+		return links.TagAnalytics{}, err
+	}
+
+	return analytics, nil
+}
+
+// GetTagMatrix counts clicks per tag and bucket in one pass. Without tags it
+// returns the limit busiest tags in the window.
+func (r *LinkRepository) GetTagMatrix(ctx context.Context, tags []string, window links.AnalyticsWindow, limit int) (links.TagMatrix, error) {
+	buckets := window.Buckets()
+	edges := make([][2]string, 0, len(buckets))
+	matrix := links.TagMatrix{Buckets: make([]string, 0, len(buckets)), Rows: []links.TagSeries{}}
+	for _, bucket := range buckets {
+		edges = append(edges, [2]string{formatSQLiteTime(bucket.Start), formatSQLiteTime(bucket.End)})
+		matrix.Buckets = append(matrix.Buckets, bucket.Label)
+	}
+	edgesJSON, err := json.Marshal(edges)
+	if err != nil {
+		return links.TagMatrix{}, err
+	}
+
+	filter := ""
+	if !window.IncludeBots {
+		filter = humanTrafficFilter
+	}
+	args := []any{string(edgesJSON)}
+	tagCondition := ""
+	if len(tags) > 0 {
+		tagCondition = ` AND t.tag IN (?` + strings.Repeat(", ?", len(tags)-1) + `)`
+		for _, tag := range tags {
+			args = append(args, tag)
+		}
+	}
+
+	rows, err := r.db.QueryContext(
+		ctx,
+		`WITH buckets(idx, start_at, end_at) AS (
+			SELECT CAST(key AS INTEGER), json_extract(value, '$[0]'), json_extract(value, '$[1]') FROM json_each(?)
+		 )
+		 SELECT t.tag, b.idx, COUNT(*)
+		 FROM buckets b
+		 JOIN link_visits v ON v.visited_at >= b.start_at AND v.visited_at < b.end_at`+filter+`
+		 JOIN link_tags t ON t.link_id = v.link_id`+tagCondition+`
+		 GROUP BY t.tag, b.idx`,
+		args...,
+	)
+	if err != nil {
+		return links.TagMatrix{}, err
+	}
+	defer rows.Close()
+
+	byTag := make(map[string]*links.TagSeries)
+	for _, tag := range tags {
+		byTag[tag] = &links.TagSeries{Tag: tag, Clicks: make([]int64, len(buckets))}
+	}
+	for rows.Next() {
+		var (
+			tag    string
+			index  int
+			clicks int64
+		)
+		if err := rows.Scan(&tag, &index, &clicks); err != nil {
+			return links.TagMatrix{}, err
+		}
+		series, ok := byTag[tag]
+		if !ok {
+			series = &links.TagSeries{Tag: tag, Clicks: make([]int64, len(buckets))}
+			byTag[tag] = series
+		}
+		if index >= 0 && index < len(buckets) {
+			series.Clicks[index] = clicks
+			series.Total += clicks
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return links.TagMatrix{}, err
+	}
+
+	for _, series := range byTag {
+		matrix.Rows = append(matrix.Rows, *series)
+	}
+	sort.Slice(matrix.Rows, func(i, j int) bool {
+		if matrix.Rows[i].Total != matrix.Rows[j].Total {
+			return matrix.Rows[i].Total > matrix.Rows[j].Total
+		}
+		return matrix.Rows[i].Tag < matrix.Rows[j].Tag
+	})
+	if len(matrix.Rows) > limit {
+		matrix.Rows = matrix.Rows[:limit]
+	}
+
+	return matrix, nil
+}
+
+// exportBatchSize bounds how many visits StreamVisits holds at once.
+const exportBatchSize = 500
+
+// StreamVisits walks the matching visits in keyset-paginated batches. No
+// statement stays open while fn runs, so a slow download never holds a read
+// transaction across visit inserts.
+func (r *LinkRepository) StreamVisits(ctx context.Context, filter links.VisitExportFilter, window links.AnalyticsWindow, fn func(links.ExportedVisit) error) error {
+	scope := visitScope{linkID: filter.LinkID, tag: filter.Tag, from: formatSQLiteTime(window.From), to: formatSQLiteTime(window.To)}
+	if !window.IncludeBots {
+		scope.filter = humanTrafficFilter
+	}
+
+	var cursor *visitCursor
+	for {
+		batch, next, err := r.exportBatch(ctx, scope, cursor)
+		if err != nil {
+			return err
+		}
+		for _, visit := range batch {
+			if err := fn(visit); err != nil {
+				return err
+			}
+		}
+		if len(batch) < exportBatchSize {
+			return nil
+		}
+		cursor = &next
+	}
+}
+
+// visitCursor is the (visited_at, id) of the last exported visit.
+type visitCursor struct {
+	visitedAt string
+	id        int64
+}
+
+func (r *LinkRepository) exportBatch(ctx context.Context, scope visitScope, after *visitCursor) ([]links.ExportedVisit, visitCursor, error) {
+	where, args := scope.where(), scope.args()
+	if after != nil {
+		where += ` AND (visited_at, id) > (?, ?)`
+		args = append(args, after.visitedAt, after.id)
+	}
+	rows, err := r.db.QueryContext(
+		ctx,
+		`SELECT id, visited_at, strftime('%Y-%m-%dT%H:%M:%fZ', visited_at), link_id, COALESCE((SELECT code FROM links WHERE links.id = link_visits.link_id), ''),
+			ip, visitor_id, traffic_type, referer, referer_host, user_agent,
+			client_name, client_version, client_type, device_type, device_brand, device_model, os, os_version, language,
+			utm_source, utm_medium, utm_campaign, utm_term, utm_content, extra_params,
+			country_code, region, city, asn, as_org
+		 FROM link_visits
+		 WHERE `+where+`
+		 ORDER BY visited_at ASC, id ASC
+		 LIMIT ?`,
+		append(args, exportBatchSize)...,
+	)
+	if err != nil {
+		return nil, visitCursor{}, err
+	}
+	defer rows.Close()
+
+	var (
+		batch []links.ExportedVisit
+		last  visitCursor
+	)
+	for rows.Next() {
+		var (
+			visit     links.ExportedVisit
+			rawTime   string
+			extraJSON string
+		)
+		if err := rows.Scan(
+			&last.id,
+			&last.visitedAt,
+			&rawTime,
+			&visit.LinkID,
+			&visit.Code,
+			&visit.IP,
+			&visit.VisitorID,
+			&visit.TrafficType,
+			&visit.Referer,
+			&visit.RefererHost,
+			&visit.UserAgent,
+			&visit.ClientName,
+			&visit.ClientVersion,
+			&visit.ClientType,
+			&visit.DeviceType,
+			&visit.DeviceBrand,
+			&visit.DeviceModel,
+			&visit.OS,
+			&visit.OSVersion,
+			&visit.Language,
+			&visit.UTMSource,
+			&visit.UTMMedium,
+			&visit.UTMCampaign,
+			&visit.UTMTerm,
+			&visit.UTMContent,
+			&extraJSON,
+			&visit.CountryCode,
+			&visit.Region,
+			&visit.City,
+			&visit.ASN,
+			&visit.ASOrg,
+		); err != nil {
+			return nil, visitCursor{}, err
+		}
+		if visit.VisitedAt, err = parseSQLiteTime(rawTime); err != nil {
+			return nil, visitCursor{}, err
+		}
+		if err := json.Unmarshal([]byte(extraJSON), &visit.ExtraParams); err != nil {
+			return nil, visitCursor{}, fmt.Errorf("decode extra params: %w", err)
+		}
+		batch = append(batch, visit)
+	}
+
+	return batch, last, rows.Err()
+}
+
+// EraseVisits deletes matching visits. Hashed addresses depend on the day, so
+// HashIP is evaluated once per UTC day that has hashed rows.
+func (r *LinkRepository) EraseVisits(ctx context.Context, match links.ErasureMatch) (int64, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var deleted int64
+	remove := func(query string, args ...any) error {
+		result, err := tx.ExecContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		affected, err := result.RowsAffected()
+		deleted += affected
+		return err
+	}
+
+	for _, ip := range match.IPs {
+		if err := remove(`DELETE FROM link_visits WHERE ip = ?`, ip); err != nil {
+			return 0, err
+		}
+	}
+	if match.VisitorID != "" {
+		if err := remove(`DELETE FROM link_visits WHERE visitor_id = ?`, match.VisitorID); err != nil {
+			return 0, err
+		}
+	}
+	if match.HashIP != nil {
+		rows, err := tx.QueryContext(ctx, `SELECT DISTINCT substr(visited_at, 1, 10) FROM link_visits WHERE ip LIKE 'h:%'`)
+		if err != nil {
+			return 0, err
+		}
+		var days []string
+		for rows.Next() {
+			var day string
+			if err := rows.Scan(&day); err != nil {
+				rows.Close()
+				return 0, err
+			}
+			days = append(days, day)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return 0, err
+		}
+		for _, day := range days {
+			parsed, err := time.Parse(time.DateOnly, day)
+			if err != nil {
+				continue
+			}
+			if err := remove(`DELETE FROM link_visits WHERE ip = ? AND substr(visited_at, 1, 10) = ?`, match.HashIP(parsed), day); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return deleted, nil
+}
+
+const reparseBatchSize = 500
+
+// ReparseVisits walks visits whose ua_rules_version differs from rulesVersion
+// in id order and rewrites their client fields. Each batch commits on its own,
+// so an interrupted run resumes where it stopped. Prefetches keep their
+// traffic type unless the agent is a crawler or link preview.
+func (r *LinkRepository) ReparseVisits(ctx context.Context, rulesVersion string, describe func(userAgent string) links.ClientInfo) (int64, error) {
+	type pending struct {
+		id        int64
+		userAgent string
+	}
+	cache := make(map[string]links.ClientInfo)
+	var (
+		updated int64
+		lastID  int64
+	)
+	for {
+		rows, err := r.db.QueryContext(
+			ctx,
+			// Anonymous visits have no user agent to parse; their traffic type
+			// was decided when they were recorded.
+			`SELECT id, user_agent FROM link_visits WHERE id > ? AND ua_rules_version <> ? AND user_agent <> '' ORDER BY id LIMIT ?`,
+			lastID,
+			rulesVersion,
+			reparseBatchSize,
+		)
+		if err != nil {
+			return updated, err
+		}
+		var batch []pending
+		for rows.Next() {
+			var item pending
+			if err := rows.Scan(&item.id, &item.userAgent); err != nil {
+				rows.Close()
+				return updated, err
+			}
+			batch = append(batch, item)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return updated, err
+		}
+		if len(batch) == 0 {
+			return updated, nil
+		}
+
+		tx, err := r.db.BeginTx(ctx, nil)
+		if err != nil {
+			return updated, err
+		}
+		for _, item := range batch {
+			info, ok := cache[item.userAgent]
+			if !ok {
+				info = describe(item.userAgent)
+				cache[item.userAgent] = info
+			}
+			if _, err := tx.ExecContext(
+				ctx,
+				`UPDATE link_visits
+				 SET client_name = ?, client_version = ?, client_type = ?, device_type = ?, device_brand = ?, device_model = ?,
+					os = ?, os_version = ?, ua_rules_version = ?,
+					traffic_type = CASE WHEN traffic_type = ? AND ? = ? THEN traffic_type ELSE ? END
+				 WHERE id = ?`,
+				info.ClientName,
+				info.ClientVersion,
+				info.ClientType,
+				info.DeviceType,
+				info.DeviceBrand,
+				info.DeviceModel,
+				info.OS,
+				info.OSVersion,
+				rulesVersion,
+				links.TrafficPrefetch,
+				info.TrafficType,
+				links.TrafficHuman,
+				info.TrafficType,
+				item.id,
+			); err != nil {
+				_ = tx.Rollback()
+				return updated, err
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			return updated, err
+		}
+		updated += int64(len(batch))
+		lastID = batch[len(batch)-1].id
+	}
+}
+
+func (r *LinkRepository) GetPeriodMetrics(ctx context.Context, id int64, window links.AnalyticsWindow, referrers []string, clients []string) (links.PeriodMetrics, error) {
+	scope := visitScope{linkID: id, from: formatSQLiteTime(window.From), to: formatSQLiteTime(window.To)}
+	if !window.IncludeBots {
+		scope.filter = humanTrafficFilter
+	}
+
+	var metrics links.PeriodMetrics
+	if err := r.db.QueryRowContext(
+		ctx,
+		`SELECT COUNT(*), COUNT(DISTINCT NULLIF(visitor_id, '')) FROM link_visits WHERE `+scope.where(),
+		scope.args()...,
+	).Scan(&metrics.Clicks, &metrics.UniqueVisitors); err != nil {
+		return links.PeriodMetrics{}, err
+	}
+
+	var err error
+	if metrics.TimeSeries, err = r.bucketVisits(ctx, scope, window.Buckets()); err != nil {
+		return links.PeriodMetrics{}, err
+	}
+	if metrics.Referrers, err = r.breakdownCounts(ctx, scope, "referer_host", "直接访问", referrers); err != nil {
+		return links.PeriodMetrics{}, err
+	}
+	if metrics.Clients, err = r.breakdownCounts(ctx, scope, "client_name", "未知客户端", clients); err != nil {
+		return links.PeriodMetrics{}, err
+	}
+
+	return metrics, nil
+}
+
+// breakdownCounts counts the scope's visits for the given names only, labelled
+// the same way as topBreakdown.
+func (r *LinkRepository) breakdownCounts(ctx context.Context, scope visitScope, expr string, fallback string, names []string) (map[string]int64, error) {
+	counts := make(map[string]int64, len(names))
+	if len(names) == 0 {
+		return counts, nil
+	}
+
+	args := append([]any{fallback}, scope.args()...)
+	for _, name := range names {
+		args = append(args, name)
+	}
+	rows, err := r.db.QueryContext(
+		ctx,
+		`SELECT name, COUNT(*) FROM (
+			SELECT CASE WHEN COALESCE(`+expr+`, '') = '' THEN ? ELSE `+expr+` END AS name
+			FROM link_visits
+			WHERE `+scope.where()+`
+		 )
+		 WHERE name IN (?`+strings.Repeat(", ?", len(names)-1)+`)
+		 GROUP BY name`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			name  string
+			count int64
+		)
+		if err := rows.Scan(&name, &count); err != nil {
+			return nil, err
+		}
+		counts[name] = count
+	}
+	return counts, rows.Err()
+}
+
+// topBreakdown groups the scope's visits by expr, a trusted SQL expression,
+// and labels empty values with fallback.
+func (r *LinkRepository) topBreakdown(ctx context.Context, scope visitScope, expr string, fallback string) ([]links.VisitBreakdown, error) {
+	return r.limitedBreakdown(ctx, scope, expr, fallback, 8)
+}
+
+func (r *LinkRepository) limitedBreakdown(ctx context.Context, scope visitScope, expr string, fallback string, limit int) ([]links.VisitBreakdown, error) {
+	rows, err := r.db.QueryContext(
+		ctx,
+		`SELECT CASE WHEN COALESCE(`+expr+`, '') = '' THEN ? ELSE `+expr+` END AS name, COUNT(*) AS total
+		 FROM link_visits
+		 WHERE `+scope.where()+`
+		 GROUP BY name
+		 ORDER BY total DESC, name ASC
+		 LIMIT ?`,
+		append(append([]any{fallback}, scope.args()...), limit)...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []links.VisitBreakdown{}
+	for rows.Next() {
+		var item links.VisitBreakdown
+		if err := rows.Scan(&item.Name, &item.Count); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// dimensionColumn is how a links.BreakdownDimensions entry is read from
+// link_visits, with the name reported when the value is empty.
+type dimensionColumn struct {
+	expr     string
+	fallback string
+}
+
+var dimensionColumns = map[string]dimensionColumn{
+	"link":           {"(SELECT code FROM links WHERE links.id = link_visits.link_id)", "已删除"},
+	"referer_host":   {"referer_host", "直接访问"},
+	"client":         {"client_name", "未知客户端"},
+	"client_version": {"TRIM(client_name || ' ' || client_version)", "未知客户端"},
+	"client_type":    {"client_type", "unknown"},
+	"device":         {"device_type", "unknown"},
+	"device_model":   {"TRIM(device_brand || ' ' || device_model)", "未知设备"},
+	"os":             {"os", "未知系统"},
+	"os_version":     {"TRIM(os || ' ' || os_version)", "未知系统"},
+	"language":       {"language", "未知"},
+	"country":        {"country_code", "未知"},
+	"city":           {"CASE WHEN city = '' THEN '' ELSE city || ', ' || country_code END", "未知"},
+	"traffic_type":   {"traffic_type", links.TrafficHuman},
+	"utm_source":     {"utm_source", "未标记"},
+	"utm_medium":     {"utm_medium", "未标记"},
+	"utm_campaign":   {"utm_campaign", "未标记"},
+	"utm_term":       {"utm_term", "未标记"},
+	"utm_content":    {"utm_content", "未标记"},
+}
+
+// GetBreakdown groups by one dimension after narrowing by the others. Filters
+// compare the same fallback-substituted names the groups are reported under.
+func (r *LinkRepository) GetBreakdown(ctx context.Context, request links.BreakdownRequest, window links.AnalyticsWindow) (links.Breakdown, error) {
+	column, ok := dimensionColumns[request.Dimension]
+	if !ok {
+		return links.Breakdown{}, fmt.Errorf("%w: unknown dimension %q", links.ErrValidation, request.Dimension)
+	}
+	scope := visitScope{linkID: request.LinkID, tag: request.Tag, from: formatSQLiteTime(window.From), to: formatSQLiteTime(window.To)}
+	if !window.IncludeBots {
+		scope.filter = humanTrafficFilter
+	}
+	for _, filter := range request.Filters {
+		filterColumn, ok := dimensionColumns[filter.Dimension]
+		if !ok {
+			return links.Breakdown{}, fmt.Errorf("%w: unknown filter dimension %q", links.ErrValidation, filter.Dimension)
+		}
+		scope.filter += ` AND (CASE WHEN COALESCE(` + filterColumn.expr + `, '') = '' THEN ? ELSE ` + filterColumn.expr + ` END) = ?`
+		scope.filterArgs = append(scope.filterArgs, filterColumn.fallback, filter.Value)
+	}
+
+	var breakdown links.Breakdown
+	if err := r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM link_visits WHERE `+scope.where(), scope.args()...).Scan(&breakdown.Total); err != nil {
+		return links.Breakdown{}, err
+	}
+	items, err := r.limitedBreakdown(ctx, scope, column.expr, column.fallback, request.Limit)
+	if err != nil {
+		return links.Breakdown{}, err
+	}
+	breakdown.Items = items
+	return breakdown, nil
+}
+
+// RecordConversion stores at most one conversion per click and reports
+// whether this call added it.
+func (r *LinkRepository) RecordConversion(ctx context.Context, input links.ConversionInput) (bool, error) {
+	var visitID int64
+	err := r.db.QueryRowContext(ctx, `SELECT id FROM link_visits WHERE click_id = ?`, input.ClickID).Scan(&visitID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, links.ErrClickNotFound
+		}
+		return false, err
+	}
+
+	result, err := r.db.ExecContext(
+		ctx,
+		`INSERT OR IGNORE INTO conversions(visit_id, value, converted_at) VALUES(?, ?, ?)`,
+		visitID,
+		input.Value,
+		formatSQLiteTime(input.ConvertedAt),
+	)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected == 1, err
+}
+
+// conversionSummary counts conversions of the clicks in scope; clicks is the
+// scope's visit count, used for the rate.
+func (r *LinkRepository) conversionSummary(ctx context.Context, scope visitScope, clicks int64) (links.ConversionSummary, error) {
+	var summary links.ConversionSummary
+	if err := r.db.QueryRowContext(
+		ctx,
+		`SELECT COUNT(c.id), COALESCE(SUM(c.value), 0)
+		 FROM link_visits JOIN conversions c ON c.visit_id = link_visits.id
+		 WHERE `+scope.where(),
+		scope.args()...,
+	).Scan(&summary.Conversions, &summary.Value); err != nil {
+		return links.ConversionSummary{}, err
+	}
+	summary.Rate = links.ConversionRate(summary.Conversions, clicks)
+	return summary, nil
+}
+
+// conversionBreakdown is topBreakdown with conversions, rate and value per
+// group, ranked by conversions and then clicks.
+func (r *LinkRepository) conversionBreakdown(ctx context.Context, scope visitScope, expr string, fallback string) ([]links.ConversionBreakdown, error) {
+	rows, err := r.db.QueryContext(
+		ctx,
+		`SELECT CASE WHEN COALESCE(`+expr+`, '') = '' THEN ? ELSE `+expr+` END AS name,
+			COUNT(*) AS clicks, COUNT(c.id) AS converted, COALESCE(SUM(c.value), 0)
+		 FROM link_visits LEFT JOIN conversions c ON c.visit_id = link_visits.id
+		 WHERE `+scope.where()+`
+		 GROUP BY name
+		 ORDER BY converted DESC, clicks DESC, name ASC
+		 LIMIT 8`,
+		append([]any{fallback}, scope.args()...)...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []links.ConversionBreakdown{}
+	for rows.Next() {
+		var item links.ConversionBreakdown
+		if err := rows.Scan(&item.Name, &item.Clicks, &item.Conversions, &item.Value); err != nil {
+			return nil, err
+		}
+		item.Rate = links.ConversionRate(item.Conversions, item.Clicks)
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// languageBreakdown groups visits by base language, keeping the top 8 bases
+// and up to 8 full tags under each. Visits without a language count as 未知.
+func (r *LinkRepository) languageBreakdown(ctx context.Context, scope visitScope) ([]links.LanguageBreakdown, error) {
+	rows, err := r.db.QueryContext(
+		ctx,
+		`SELECT language, COUNT(*) FROM link_visits WHERE `+scope.where()+` GROUP BY language`,
+		scope.args()...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := make(map[string]*links.LanguageBreakdown)
+	for rows.Next() {
+		var (
+			tag   string
+			count int64
+		)
+		if err := rows.Scan(&tag, &count); err != nil {
+			return nil, err
+		}
+		if tag == "" {
+			tag = "未知"
+		}
+		base := links.LanguageBase(tag)
+		group, ok := groups[base]
+		if !ok {
+			group = &links.LanguageBreakdown{Name: base}
+			groups[base] = group
+		}
+		group.Count += count
+		group.Regions = append(group.Regions, links.VisitBreakdown{Name: tag, Count: count})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	items := make([]links.LanguageBreakdown, 0, len(groups))
+	for _, group := range groups {
+		sortBreakdown(group.Regions)
+		if len(group.Regions) > 8 {
+			group.Regions = group.Regions[:8]
+		}
+		items = append(items, *group)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Count != items[j].Count {
+			return items[i].Count > items[j].Count
+		}
+		return items[i].Name < items[j].Name
+	})
+	if len(items) > 8 {
+		items = items[:8]
+	}
+	return items, nil
+}
+
+func sortBreakdown(items []links.VisitBreakdown) {
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Count != items[j].Count {
+			return items[i].Count > items[j].Count
+		}
+		return items[i].Name < items[j].Name
+	})
+}
+
+// campaignBreakdowns reports each utm_* column plus every extra parameter key
+// that appears in the window.
+func (r *LinkRepository) campaignBreakdowns(ctx context.Context, scope visitScope) (map[string][]links.VisitBreakdown, error) {
+	campaigns := make(map[string][]links.VisitBreakdown, len(links.UTMParams))
+	for _, param := range links.UTMParams {
+		items, err := r.topBreakdown(ctx, scope, param, "未标记")
+		if err != nil {
+			return nil, err
+		}
+		campaigns[param] = items
+	}
+
+	condition, args := scope.links("v.link_id")
+	rows, err := r.db.QueryContext(
+		ctx,
+		`SELECT DISTINCT p.key
+		 FROM link_visits v, json_each(v.extra_params) p
+		 WHERE `+condition+`v.visited_at >= ? AND v.visited_at < ?`+scope.filter+`
+		 ORDER BY p.key`,
+		append(args, scope.from, scope.to)...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if links.IsTrackableParam(key) {
+			keys = append(keys, key)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, key := range keys {
+		items, err := r.topBreakdown(ctx, scope, `json_extract(extra_params, '$."`+key+`"')`, "未标记")
+		if err != nil {
+			return nil, err
+		}
+		campaigns[key] = items
+	}
+
+	return campaigns, nil
+}
+
+// bucketVisits counts visits per bucket in one query. The bucket edges are sent
+// as a JSON array so every bucket comes back, including empty ones.
+func (r *LinkRepository) bucketVisits(ctx context.Context, scope visitScope, buckets []links.Bucket) ([]links.VisitPoint, error) {
+	edges := make([][2]string, 0, len(buckets))
+	for _, bucket := range buckets {
+		edges = append(edges, [2]string{formatSQLiteTime(bucket.Start), formatSQLiteTime(bucket.End)})
+	}
+	edgesJSON, err := json.Marshal(edges)
+	if err != nil {
+		return nil, err
+	}
+
+	condition, linkArgs := scope.links("v.link_id")
+	join := condition + `v.visited_at >= b.start_at AND v.visited_at < b.end_at` + scope.filter
+	args := append([]any{string(edgesJSON)}, linkArgs...)
+
+	rows, err := r.db.QueryContext(
+		ctx,
+		`WITH buckets(idx, start_at, end_at) AS (
+			SELECT CAST(key AS INTEGER), json_extract(value, '$[0]'), json_extract(value, '$[1]') FROM json_each(?)
+		 )
+		 SELECT b.idx, COUNT(v.id), COUNT(DISTINCT NULLIF(v.visitor_id, ''))
+		 FROM buckets b
+		 LEFT JOIN link_visits v ON `+join+`
+		 GROUP BY b.idx
+		 ORDER BY b.idx ASC`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	points := make([]links.VisitPoint, len(buckets))
+	for index, bucket := range buckets {
+		points[index].Bucket = bucket.Label
+	}
+	for rows.Next() {
+		var (
+			index    int
+			clicks   int64
+			visitors int64
+		)
+		if err := rows.Scan(&index, &clicks, &visitors); err != nil {
+			return nil, err
+		}
+		if index >= 0 && index < len(points) {
+			points[index].Clicks = clicks
+			points[index].Visitors = visitors
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return points, nil
+}
+
+// botTraffic always reports the non-human visits in the scope's window,
+// regardless of whether the headline metrics include them.
+func (r *LinkRepository) botTraffic(ctx context.Context, scope visitScope) (links.BotTraffic, error) {
+	scope.filter = ` AND traffic_type <> '` + links.TrafficHuman + `'`
+
+	var (
+		report links.BotTraffic
+		err    error
+	)
+	if err := r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM link_visits WHERE `+scope.where(), scope.args()...).Scan(&report.Total); err != nil {
+		return links.BotTraffic{}, err
+	}
+	if report.ByType, err = r.topBreakdown(ctx, scope, "traffic_type", links.TrafficBot); err != nil {
+		return links.BotTraffic{}, err
+	}
+	if report.TopAgents, err = r.topBreakdown(ctx, scope, "client_name", "未知客户端"); err != nil {
+		return links.BotTraffic{}, err
+	}
+	return report, nil
+}
+
 func parseSQLiteTime(raw string) (time.Time, error) {
 	layouts := []string{
 		time.RFC3339Nano,
@@ -394,7 +1445,8 @@ type scanner interface {
 func scanLink(scanTarget scanner) (links.Link, error) {
 	var link links.Link
 	var tagsJSON string
-	var enabled int
+	var enabled, noTracking int
+	var expiresAt sql.NullString
 
 	err := scanTarget.Scan(
 		&link.ID,
@@ -403,6 +1455,8 @@ func scanLink(scanTarget scanner) (links.Link, error) {
 		&link.Remark,
 		&tagsJSON,
 		&enabled,
+		&noTracking,
+		&expiresAt,
 		&link.ClickCount,
 		&link.CreatedAt,
 		&link.UpdatedAt,
@@ -419,5 +1473,16 @@ func scanLink(scanTarget scanner) (links.Link, error) {
 	}
 
 	link.Enabled = enabled != 0
+	link.NoTracking = noTracking != 0
+	if link.ExpiresAt, err = parseNullableTime(expiresAt); err != nil {
+		return links.Link{}, err
+	}
 	return link, nil
 }
+
+func nullableTime(value *time.Time) sql.NullString {
+	if value == nil {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: formatSQLiteTime(*value), Valid: true}
+}
@@ -3,6 +3,7 @@ package sqlite
 import (
 	"context"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -23,7 +24,7 @@ func TestGetLinkAnalytics(t *testing.T) {
 	}
 
 	repo := NewLinkRepository(database)
-	link, err := repo.CreateLink(ctx, "demo", "https://example.com/demo", "", nil)
+	link, err := repo.CreateLink(ctx, links.Link{Code: "demo", TargetURL: "https://example.com/demo", Enabled: true})
 	if err != nil {
 		t.Fatalf("create link: %v", err)
 	}
@@ -38,11 +39,16 @@ func TestGetLinkAnalytics(t *testing.T) {
 		ClientType:  "app",
 		DeviceType:  "mobile",
 		OS:          "iOS",
+		Geo:         links.GeoLocation{CountryCode: "CN", Region: "上海", City: "上海", ASN: 4812},
 	}); err != nil {
 		t.Fatalf("record visit: %v", err)
 	}
 
-	analytics, err := repo.GetLinkAnalytics(ctx, link.ID, time.Now().UTC().Add(-24*time.Hour), 20)
+	analytics, err := repo.GetLinkAnalytics(ctx, link.ID, links.AnalyticsWindow{
+		From:        time.Now().UTC().Add(-24 * time.Hour),
+		To:          time.Now().UTC().Add(time.Minute),
+		Granularity: links.GranularityDay,
+	}, 20)
 	if err != nil {
 		t.Fatalf("get analytics: %v", err)
 	}
@@ -53,6 +59,66 @@ func TestGetLinkAnalytics(t *testing.T) {
 	if len(analytics.RecentVisits) != 1 {
 		t.Fatalf("expected 1 recent visit, got %d", len(analytics.RecentVisits))
 	}
+	if len(analytics.TopCountries) != 1 || analytics.TopCountries[0].Name != "CN" || analytics.RecentVisits[0].ASN != 4812 {
+		t.Fatalf("expected geo fields to round-trip, got countries=%+v visit=%+v", analytics.TopCountries, analytics.RecentVisits[0])
+	}
+}
+
+func TestGetLinkAnalyticsHourlyBucketsAreGapFilled(t *testing.T) {
+	database, err := Open(filepath.Join(t.TempDir(), "hourly-test.db"))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	t.Cleanup(func() { _ = database.Close() })
+
+	ctx := context.Background()
+	if err := Init(ctx, database); err != nil {
+		t.Fatalf("init db: %v", err)
+	}
+
+	repo := NewLinkRepository(database)
+	link, err := repo.CreateLink(ctx, links.Link{Code: "launch", TargetURL: "https://example.com/launch", Enabled: true})
+	if err != nil {
+		t.Fatalf("create link: %v", err)
+	}
+
+	from := time.Date(2025, 3, 1, 8, 0, 0, 0, time.UTC)
+	for _, visitedAt := range []time.Time{
+		from,
+		from.Add(30 * time.Minute),
+		from.Add(2*time.Hour + 59*time.Minute),
+		from.Add(3 * time.Hour),
+	} {
+		if err := repo.RecordVisit(ctx, link.ID, links.VisitMeta{VisitedAt: visitedAt}); err != nil {
+			t.Fatalf("record visit: %v", err)
+		}
+	}
+
+	analytics, err := repo.GetLinkAnalytics(ctx, link.ID, links.AnalyticsWindow{
+		From:        from,
+		To:          from.Add(3 * time.Hour),
+		Granularity: links.GranularityHour,
+	}, 20)
+	if err != nil {
+		t.Fatalf("get analytics: %v", err)
+	}
+
+	expected := []links.VisitPoint{
+		{Bucket: "2025-03-01T08:00:00Z", Clicks: 2},
+		{Bucket: "2025-03-01T09:00:00Z", Clicks: 0},
+		{Bucket: "2025-03-01T10:00:00Z", Clicks: 1},
+	}
+	if len(analytics.TimeSeries) != len(expected) {
+		t.Fatalf("expected %d buckets, got %+v", len(expected), analytics.TimeSeries)
+	}
+	for index, point := range expected {
+		if analytics.TimeSeries[index] != point {
+			t.Fatalf("bucket %d: expected %+v, got %+v", index, point, analytics.TimeSeries[index])
+		}
+	}
+	if analytics.RecentClicks != 3 {
+		t.Fatalf("expected visits at the window end to be excluded, got %d", analytics.RecentClicks)
+	}
 }
 
 func TestDeleteLink(t *testing.T) {
@@ -69,7 +135,7 @@ func TestDeleteLink(t *testing.T) {
 	}
 
 	repo := NewLinkRepository(database)
-	link, err := repo.CreateLink(ctx, "delete-me", "https://example.com/delete", "", nil)
+	link, err := repo.CreateLink(ctx, links.Link{Code: "delete-me", TargetURL: "https://example.com/delete", Enabled: true})
 	if err != nil {
 		t.Fatalf("create link: %v", err)
 	}
@@ -86,3 +152,412 @@ func TestDeleteLink(t *testing.T) {
 		t.Fatalf("expected ErrLinkNotFound for missing delete, got %v", err)
 	}
 }
+
+func TestInitBackfillsLinkTags(t *testing.T) {
+	database, err := Open(filepath.Join(t.TempDir(), "tags-test.db"))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	t.Cleanup(func() { _ = database.Close() })
+
+	ctx := context.Background()
+	if err := Init(ctx, database); err != nil {
+		t.Fatalf("init db: %v", err)
+	}
+	// Simulate a link written before link_tags existed.
+	if _, err := database.ExecContext(ctx, `INSERT INTO links(code, target_url, tags_json) VALUES('legacy', 'https://example.com', '["wechat","spring"]')`); err != nil {
+		t.Fatalf("insert legacy link: %v", err)
+	}
+	if err := Init(ctx, database); err != nil {
+		t.Fatalf("re-init db: %v", err)
+	}
+
+	var count int
+	if err := database.QueryRowContext(ctx, `SELECT COUNT(*) FROM link_tags WHERE tag IN ('wechat', 'spring')`).Scan(&count); err != nil {
+		t.Fatalf("count tags: %v", err)
+	}
+	if count != 2 {
+		t.Fatalf("expected 2 backfilled tags, got %d", count)
+	}
+}
+
+func TestAnalyticsComparesWithPreviousWindow(t *testing.T) {
+	database, err := Open(filepath.Join(t.TempDir(), "compare-test.db"))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	t.Cleanup(func() { _ = database.Close() })
+
+	ctx := context.Background()
+	if err := Init(ctx, database); err != nil {
+		t.Fatalf("init db: %v", err)
+	}
+
+	repo := NewLinkRepository(database)
+	link, err := repo.CreateLink(ctx, links.Link{Code: "compare", TargetURL: "https://example.com/compare", Enabled: true})
+	if err != nil {
+		t.Fatalf("create link: %v", err)
+	}
+
+	now := time.Now().UTC()
+	visits := []struct {
+		at      time.Time
+		referer string
+	}{
+		{now, "news.example.org"},
+		{now, "news.example.org"},
+		{now, "news.example.org"},
+		{now, ""},
+		{now.AddDate(0, 0, -7), "news.example.org"},
+		{now.AddDate(0, 0, -7), "mail.example.org"},
+	}
+	for _, visit := range visits {
+		if err := repo.RecordVisit(ctx, link.ID, links.VisitMeta{VisitedAt: visit.at, RefererHost: visit.referer, ClientName: "Chrome"}); err != nil {
+			t.Fatalf("record visit: %v", err)
+		}
+	}
+
+	analytics, err := links.NewService(repo).Analytics(ctx, link.ID, links.AnalyticsQuery{Days: 7, Compare: "previous"})
+	if err != nil {
+		t.Fatalf("analytics: %v", err)
+	}
+	comparison := analytics.Comparison
+	if comparison == nil {
+		t.Fatal("expected comparison")
+	}
+	if comparison.Clicks.Current != 4 || comparison.Clicks.Previous != 2 || comparison.Clicks.Change != 2 || *comparison.Clicks.Percent != 100 {
+		t.Fatalf("unexpected click delta %+v", comparison.Clicks)
+	}
+	if len(comparison.TimeSeries) != len(analytics.TimeSeries) || comparison.TimeSeries[len(comparison.TimeSeries)-1].Clicks != 2 {
+		t.Fatalf("expected aligned comparison series, got %+v", comparison.TimeSeries)
+	}
+
+	referrers := map[string]links.Delta{}
+	for _, item := range comparison.TopReferrers {
+		referrers[item.Name] = item.Delta
+	}
+	if news := referrers["news.example.org"]; news.Previous != 1 || news.Change != 2 || *news.Percent != 200 {
+		t.Fatalf("unexpected news referrer delta %+v", news)
+	}
+	if direct := referrers["直接访问"]; direct.Previous != 0 || direct.Percent != nil {
+		t.Fatalf("expected direct traffic with no previous value, got %+v", direct)
+	}
+}
+
+func TestReparseVisitsBackfillsClientFields(t *testing.T) {
+	database, err := Open(filepath.Join(t.TempDir(), "reparse-test.db"))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	t.Cleanup(func() { _ = database.Close() })
+
+	ctx := context.Background()
+	if err := Init(ctx, database); err != nil {
+		t.Fatalf("init db: %v", err)
+	}
+
+	repo := NewLinkRepository(database)
+	link, err := repo.CreateLink(ctx, links.Link{Code: "reparse", TargetURL: "https://example.com/reparse", Enabled: true})
+	if err != nil {
+		t.Fatalf("create link: %v", err)
+	}
+
+	// Rows as the old substring matcher stored them, without a rules version.
+	samsung := "Mozilla/5.0 (Linux; Android 14; SM-S918B) AppleWebKit/537.36 (KHTML, like Gecko) SamsungBrowser/25.0 Chrome/121.0.0.0 Mobile Safari/537.36"
+	now := time.Now().UTC()
+	for i, meta := range []links.VisitMeta{
+		{UserAgent: samsung, ClientName: "Chrome", ClientType: "browser", DeviceType: "mobile", OS: "Android"},
+		{UserAgent: samsung, ClientName: "Chrome", ClientType: "browser", DeviceType: "mobile", OS: "Android", TrafficType: links.TrafficPrefetch},
+		{UserAgent: "Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)", ClientName: "Bot", ClientType: "bot", DeviceType: "bot", OS: "未知系统"},
+		// A DNT crawler visit, stored without its user agent.
+		{ClientName: "未知客户端", ClientType: "unknown", DeviceType: "unknown", OS: "未知系统", TrafficType: links.TrafficBot, DoNotTrack: true},
+	} {
+		meta.VisitedAt = now.Add(time.Duration(i) * time.Second)
+		if err := repo.RecordVisit(ctx, link.ID, meta); err != nil {
+			t.Fatalf("record visit: %v", err)
+		}
+	}
+
+	service := links.NewService(repo)
+	updated, err := service.ReparseVisits(ctx)
+	if err != nil {
+		t.Fatalf("reparse visits: %v", err)
+	}
+	if updated != 3 {
+		t.Fatalf("expected 3 re-parsed visits, got %d", updated)
+	}
+
+	var visits []links.ExportedVisit
+	window := links.AnalyticsWindow{From: now.Add(-time.Minute), To: now.Add(time.Minute), IncludeBots: true}
+	if err := repo.StreamVisits(ctx, links.VisitExportFilter{LinkID: link.ID}, window, func(visit links.ExportedVisit) error {
+		visits = append(visits, visit)
+		return nil
+	}); err != nil {
+		t.Fatalf("stream visits: %v", err)
+	}
+	if len(visits) != 4 {
+		t.Fatalf("expected 4 visits, got %d", len(visits))
+	}
+	first := visits[0]
+	if first.ClientName != "Samsung Internet" || first.ClientVersion != "25" || first.OSVersion != "14" || first.DeviceBrand != "Samsung" || first.DeviceModel != "SM-S918B" {
+		t.Fatalf("expected samsung details, got %+v", first)
+	}
+	if visits[1].TrafficType != links.TrafficPrefetch || visits[1].ClientName != "Samsung Internet" {
+		t.Fatalf("expected prefetch to keep its traffic type, got %+v", visits[1])
+	}
+	if visits[2].TrafficType != links.TrafficPreview || visits[2].ClientName != "Slack" {
+		t.Fatalf("expected slack preview, got %+v", visits[2])
+	}
+	if visits[3].TrafficType != links.TrafficBot || visits[3].ClientName != "未知客户端" {
+		t.Fatalf("expected the anonymous visit to be left alone, got %+v", visits[3])
+	}
+
+	if updated, err := service.ReparseVisits(ctx); err != nil || updated != 0 {
+		t.Fatalf("expected nothing left to re-parse, got %d, %v", updated, err)
+	}
+}
+
+func TestEraseVisitsMatchesHashedAddresses(t *testing.T) {
+	database, err := Open(filepath.Join(t.TempDir(), "erase-test.db"))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	t.Cleanup(func() { _ = database.Close() })
+
+	ctx := context.Background()
+	if err := Init(ctx, database); err != nil {
+		t.Fatalf("init db: %v", err)
+	}
+
+	repo := NewLinkRepository(database)
+	link, err := repo.CreateLink(ctx, links.Link{Code: "hashed", TargetURL: "https://example.com/hashed", Enabled: true})
+	if err != nil {
+		t.Fatalf("create link: %v", err)
+	}
+
+	hashed := links.NewService(repo, links.WithIPMode(links.IPModeHash))
+	yesterday := time.Now().UTC().Add(-24 * time.Hour)
+	for _, visit := range []struct {
+		ip string
+		at time.Time
+	}{
+		{"198.51.100.9", yesterday},
+		{"198.51.100.9", yesterday.Add(24 * time.Hour)},
+		{"198.51.100.10", yesterday},
+	} {
+		if _, _, err := hashed.Resolve(ctx, "hashed", links.VisitMeta{IP: visit.ip, VisitedAt: visit.at, UserAgent: "Mozilla/5.0"}); err != nil {
+			t.Fatalf("resolve: %v", err)
+		}
+	}
+	truncated := links.NewService(repo, links.WithIPMode(links.IPModeTruncate))
+	if _, _, err := truncated.Resolve(ctx, "hashed", links.VisitMeta{IP: "198.51.100.9", UserAgent: "Mozilla/5.0"}); err != nil {
+		t.Fatalf("resolve: %v", err)
+	}
+
+	var stored []string
+	rows, err := database.QueryContext(ctx, `SELECT ip FROM link_visits WHERE link_id = ? ORDER BY id`, link.ID)
+	if err != nil {
+		t.Fatalf("query ips: %v", err)
+	}
+	for rows.Next() {
+		var ip string
+		if err := rows.Scan(&ip); err != nil {
+			t.Fatalf("scan ip: %v", err)
+		}
+		stored = append(stored, ip)
+	}
+	rows.Close()
+	if len(stored) != 4 || !strings.HasPrefix(stored[0], "h:") || stored[0] == stored[1] || stored[3] != "198.51.100.0" {
+		t.Fatalf("unexpected stored addresses %q", stored)
+	}
+
+	deleted, err := hashed.EraseVisits(ctx, links.VisitErasure{IP: "198.51.100.9"})
+	if err != nil {
+		t.Fatalf("erase visits: %v", err)
+	}
+	if deleted != 2 {
+		t.Fatalf("expected both hashed visits of the address to be erased, got %d", deleted)
+	}
+}
+
+func TestGetBreakdownFiltersByOtherDimensions(t *testing.T) {
+	database, err := Open(filepath.Join(t.TempDir(), "breakdown-test.db"))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	t.Cleanup(func() { _ = database.Close() })
+
+	ctx := context.Background()
+	if err := Init(ctx, database); err != nil {
+		t.Fatalf("init db: %v", err)
+	}
+
+	repo := NewLinkRepository(database)
+	link, err := repo.CreateLink(ctx, links.Link{Code: "pivot", TargetURL: "https://example.com/pivot", Enabled: true})
+	if err != nil {
+		t.Fatalf("create link: %v", err)
+	}
+
+	now := time.Now().UTC()
+	for _, visit := range []struct {
+		referer string
+		device  string
+		os      string
+	}{
+		{"news.example.com", "mobile", "iOS"},
+		{"news.example.com", "mobile", "iOS"},
+		{"", "mobile", "iOS"},
+		{"news.example.com", "mobile", "Android"},
+		{"search.example.net", "desktop", "iOS"},
+	} {
+		if err := repo.RecordVisit(ctx, link.ID, links.VisitMeta{
+			VisitedAt:   now,
+			RefererHost: visit.referer,
+			DeviceType:  visit.device,
+			OS:          visit.os,
+			TrafficType: links.TrafficHuman,
+		}); err != nil {
+			t.Fatalf("record visit: %v", err)
+		}
+	}
+
+	window := links.AnalyticsWindow{From: now.Add(-time.Hour), To: now.Add(time.Minute), Granularity: links.GranularityDay}
+	for _, dimension := range links.BreakdownDimensions {
+		if _, err := repo.GetBreakdown(ctx, links.BreakdownRequest{LinkID: link.ID, Dimension: dimension, Limit: 10}, window); err != nil {
+			t.Fatalf("dimension %s: %v", dimension, err)
+		}
+	}
+
+	breakdown, err := repo.GetBreakdown(ctx, links.BreakdownRequest{
+		LinkID:    link.ID,
+		Dimension: "referer_host",
+		Filters:   []links.DimensionFilter{{Dimension: "device", Value: "mobile"}, {Dimension: "os", Value: "iOS"}},
+		Limit:     10,
+	}, window)
+	if err != nil {
+		t.Fatalf("get breakdown: %v", err)
+	}
+	want := []links.VisitBreakdown{{Name: "news.example.com", Count: 2}, {Name: "直接访问", Count: 1}}
+	if breakdown.Total != 3 || len(breakdown.Items) != len(want) || breakdown.Items[0] != want[0] || breakdown.Items[1] != want[1] {
+		t.Fatalf("expected referrers of mobile iOS visits %+v, got total=%d items=%+v", want, breakdown.Total, breakdown.Items)
+	}
+
+	direct, err := repo.GetBreakdown(ctx, links.BreakdownRequest{
+		LinkID:    link.ID,
+		Dimension: "os",
+		Filters:   []links.DimensionFilter{{Dimension: "referer_host", Value: "直接访问"}},
+		Limit:     1,
+	}, window)
+	if err != nil {
+		t.Fatalf("get breakdown: %v", err)
+	}
+	if direct.Total != 1 || len(direct.Items) != 1 || direct.Items[0].Name != "iOS" {
+		t.Fatalf("expected the fallback name to filter empty referrers, got %+v", direct)
+	}
+}
+
+func TestClaimExpiredLinksReportsEachExpiryOnce(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "expiry-test.db")
+	database, err := Open(dbPath)
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	t.Cleanup(func() { _ = database.Close() })
+
+	ctx := context.Background()
+	if err := Init(ctx, database); err != nil {
+		t.Fatalf("init db: %v", err)
+	}
+
+	repo := NewLinkRepository(database)
+	now := time.Now().UTC()
+	past := now.Add(-time.Minute)
+	future := now.Add(time.Hour)
+	expired, err := repo.CreateLink(ctx, links.Link{Code: "gone", TargetURL: "https://example.com/gone", Enabled: true, ExpiresAt: &past})
+	if err != nil {
+		t.Fatalf("create link: %v", err)
+	}
+	if _, err := repo.CreateLink(ctx, links.Link{Code: "later", TargetURL: "https://example.com/later", Enabled: true, ExpiresAt: &future}); err != nil {
+		t.Fatalf("create link: %v", err)
+	}
+	if _, err := repo.CreateLink(ctx, links.Link{Code: "forever", TargetURL: "https://example.com/forever", Enabled: true}); err != nil {
+		t.Fatalf("create link: %v", err)
+	}
+
+	claimed, err := repo.ClaimExpiredLinks(ctx, now)
+	if err != nil {
+		t.Fatalf("claim expired links: %v", err)
+	}
+	if len(claimed) != 1 || claimed[0].Code != "gone" || claimed[0].ExpiresAt == nil {
+		t.Fatalf("expected only the expired link, got %+v", claimed)
+	}
+	if claimed, err = repo.ClaimExpiredLinks(ctx, now); err != nil || len(claimed) != 0 {
+		t.Fatalf("expected the expiry to be claimed once, got %+v err=%v", claimed, err)
+	}
+
+	// Moving expires_at makes the link expire again later.
+	again := now.Add(-time.Second)
+	expired.ExpiresAt = &again
+	if _, err := repo.UpdateLink(ctx, expired); err != nil {
+		t.Fatalf("update link: %v", err)
+	}
+	if claimed, err = repo.ClaimExpiredLinks(ctx, now); err != nil || len(claimed) != 1 {
+		t.Fatalf("expected a rescheduled expiry to be claimed again, got %+v err=%v", claimed, err)
+	}
+	if claimed, err = repo.ClaimExpiredLinks(ctx, now.Add(2*time.Hour)); err != nil || len(claimed) != 1 || claimed[0].Code != "later" {
+		t.Fatalf("expected the later link once its time comes, got %+v err=%v", claimed, err)
+	}
+}
+
+func TestStreamVisitsPagesThroughEveryVisit(t *testing.T) {
+	database, err := Open(filepath.Join(t.TempDir(), "export-test.db"))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	t.Cleanup(func() { _ = database.Close() })
+
+	ctx := context.Background()
+	if err := Init(ctx, database); err != nil {
+		t.Fatalf("init db: %v", err)
+	}
+	var journalMode string
+	if err := database.QueryRowContext(ctx, `PRAGMA journal_mode`).Scan(&journalMode); err != nil || journalMode != "wal" {
+		t.Fatalf("expected WAL journal mode, got %q err=%v", journalMode, err)
+	}
+
+	repo := NewLinkRepository(database)
+	link, err := repo.CreateLink(ctx, links.Link{Code: "export", TargetURL: "https://example.com/export", Enabled: true})
+	if err != nil {
+		t.Fatalf("create link: %v", err)
+	}
+
+	// Pairs of visits share a timestamp so the cursor has to break ties on id.
+	start := time.Now().UTC().Add(-time.Hour).Truncate(time.Second)
+	total := exportBatchSize*2 + 3
+	for i := range total {
+		if err := repo.RecordVisit(ctx, link.ID, links.VisitMeta{VisitedAt: start.Add(time.Duration(i/2) * time.Second), IP: "10.0.0.1", UserAgent: "Mozilla/5.0"}); err != nil {
+			t.Fatalf("record visit: %v", err)
+		}
+	}
+
+	var seen []time.Time
+	err = repo.StreamVisits(ctx, links.VisitExportFilter{LinkID: link.ID}, links.AnalyticsWindow{From: start.Add(-time.Minute), To: time.Now().UTC().Add(time.Minute)}, func(visit links.ExportedVisit) error {
+		seen = append(seen, visit.VisitedAt)
+		// Writes must not wait on the export.
+		if len(seen) == 1 {
+			return repo.RecordVisit(ctx, link.ID, links.VisitMeta{VisitedAt: start.Add(-time.Hour), IP: "10.0.0.2", UserAgent: "Mozilla/5.0"})
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("stream visits: %v", err)
+	}
+	if len(seen) != total {
+		t.Fatalf("expected %d visits, got %d", total, len(seen))
+	}
+	for i := 1; i < len(seen); i++ {
+		if seen[i].Before(seen[i-1]) {
+			t.Fatalf("visits out of order at %d: %v before %v", i, seen[i], seen[i-1])
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/mine/shorturl/internal/auth"
+)
+
+type LoginAttemptRepository struct {
+	db *sql.DB
+}
+
+func NewLoginAttemptRepository(db *sql.DB) *LoginAttemptRepository {
+	return &LoginAttemptRepository{db: db}
+}
+
+func (r *LoginAttemptRepository) GetLoginAttempt(ctx context.Context, scope string, key string) (auth.LoginAttempt, error) {
+	row := r.db.QueryRowContext(
+		ctx,
+		`SELECT scope, key, failures, last_failed_at, locked_until
+		 FROM login_attempts
+		 WHERE scope = ? AND key = ?`,
+		scope,
+		key,
+	)
+
+	attempt, err := scanLoginAttempt(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return auth.LoginAttempt{Scope: scope, Key: key}, nil
+		}
+		return auth.LoginAttempt{}, err
+	}
+
+	return attempt, nil
+}
+
+func (r *LoginAttemptRepository) IncrementLoginAttempt(ctx context.Context, scope string, key string, now time.Time, resetBefore time.Time) (int, error) {
+	var failures int
+	err := r.db.QueryRowContext(
+		ctx,
+		`INSERT INTO login_attempts(scope, key, failures, last_failed_at, locked_until)
+		 VALUES(?, ?, 1, ?, NULL)
+		 ON CONFLICT(scope, key) DO UPDATE SET
+			failures = CASE WHEN last_failed_at < ? THEN 1 ELSE failures + 1 END,
+			locked_until = CASE WHEN last_failed_at < ? THEN NULL ELSE locked_until END,
+			last_failed_at = excluded.last_failed_at
+		 RETURNING failures`,
+		scope,
+		key,
+		formatSQLiteTime(now),
+		formatSQLiteTime(resetBefore),
+		formatSQLiteTime(resetBefore),
+	).Scan(&failures)
+	return failures, err
+}
+
+func (r *LoginAttemptRepository) LockLoginAttempt(ctx context.Context, scope string, key string, until time.Time) error {
+	_, err := r.db.ExecContext(
+		ctx,
+		`UPDATE login_attempts SET locked_until = ?
+		 WHERE scope = ? AND key = ? AND (locked_until IS NULL OR locked_until < ?)`,
+		formatSQLiteTime(until),
+		scope,
+		key,
+		formatSQLiteTime(until),
+	)
+	return err
+}
+
+func (r *LoginAttemptRepository) DeleteLoginAttempt(ctx context.Context, scope string, key string) error {
+	_, err := r.db.ExecContext(ctx, `DELETE FROM login_attempts WHERE scope = ? AND key = ?`, scope, key)
+	return err
+}
+
+func (r *LoginAttemptRepository) ListLockedAttempts(ctx context.Context, now time.Time) ([]auth.LoginAttempt, error) {
+	rows, err := r.db.QueryContext(
+		ctx,
+		`SELECT scope, key, failures, last_failed_at, locked_until
+		 FROM login_attempts
+		 WHERE locked_until IS NOT NULL AND locked_until > ?
+		 ORDER BY locked_until DESC`,
+		formatSQLiteTime(now),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]auth.LoginAttempt, 0)
+	for rows.Next() {
+		attempt, err := scanLoginAttempt(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, attempt)
+	}
+
+	return result, rows.Err()
+}
+
+func scanLoginAttempt(scanTarget scanner) (auth.LoginAttempt, error) {
+	var (
+		attempt        auth.LoginAttempt
+		rawLastFailed  string
+		rawLockedUntil sql.NullString
+	)
+	if err := scanTarget.Scan(&attempt.Scope, &attempt.Key, &attempt.Failures, &rawLastFailed, &rawLockedUntil); err != nil {
+		return auth.LoginAttempt{}, err
+	}
+
+	lastFailedAt, err := parseSQLiteTime(rawLastFailed)
+	if err != nil {
+		return auth.LoginAttempt{}, err
+	}
+	attempt.LastFailedAt = lastFailedAt
+
+	if rawLockedUntil.Valid && rawLockedUntil.String != "" {
+		lockedUntil, err := parseSQLiteTime(rawLockedUntil.String)
+		if err != nil {
+			return auth.LoginAttempt{}, err
+		}
+		attempt.LockedUntil = &lockedUntil
+	}
+
+	return attempt, nil
+}
@@ -0,0 +1,95 @@
+package sqlite
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/mine/shorturl/internal/auth"
+)
+
+func TestLoginLockoutSurvivesReopen(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "lockout-test.db")
+	database, err := Open(dbPath)
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+
+	ctx := context.Background()
+	if err := Init(ctx, database); err != nil {
+		t.Fatalf("init db: %v", err)
+	}
+
+	policy := auth.DefaultThrottlePolicy()
+	policy.MaxUsernameFailures = 2
+	throttle := auth.NewThrottle(NewLoginAttemptRepository(database), policy)
+	for range 2 {
+		if err := throttle.RecordFailure(ctx, "admin", "10.0.0.1"); err != nil {
+			t.Fatalf("record failure: %v", err)
+		}
+	}
+	_ = database.Close()
+
+	reopened, err := Open(dbPath)
+	if err != nil {
+		t.Fatalf("reopen db: %v", err)
+	}
+	t.Cleanup(func() { _ = reopened.Close() })
+
+	throttle = auth.NewThrottle(NewLoginAttemptRepository(reopened), policy)
+	err = throttle.Check(ctx, "admin", "10.0.0.2")
+	var locked *auth.LockedError
+	if !errors.As(err, &locked) {
+		t.Fatalf("expected lockout after reopen, got %v", err)
+	}
+	if locked.Scope != auth.ScopeUsername || locked.RetryAfter <= 0 {
+		t.Fatalf("unexpected lockout %+v", locked)
+	}
+
+	if err := throttle.Unlock(ctx, auth.ScopeUsername, "admin"); err != nil {
+		t.Fatalf("unlock: %v", err)
+	}
+	if err := throttle.Check(ctx, "admin", "10.0.0.2"); err != nil {
+		t.Fatalf("expected unlocked account, got %v", err)
+	}
+}
+
+func TestConcurrentFailuresAreAllCounted(t *testing.T) {
+	database, err := Open(filepath.Join(t.TempDir(), "lockout-race-test.db"))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	t.Cleanup(func() { _ = database.Close() })
+
+	ctx := context.Background()
+	if err := Init(ctx, database); err != nil {
+		t.Fatalf("init db: %v", err)
+	}
+
+	repo := NewLoginAttemptRepository(database)
+	throttle := auth.NewThrottle(repo, auth.DefaultThrottlePolicy())
+	const guesses = 20
+	var wg sync.WaitGroup
+	for range guesses {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := throttle.RecordFailure(ctx, "admin", "10.0.0.1"); err != nil {
+				t.Errorf("record failure: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for scope, key := range map[string]string{auth.ScopeUsername: "admin", auth.ScopeIP: "10.0.0.1"} {
+		attempt, err := repo.GetLoginAttempt(ctx, scope, key)
+		if err != nil {
+			t.Fatalf("get attempt: %v", err)
+		}
+		if attempt.Failures != guesses || attempt.LockedUntil == nil {
+			t.Fatalf("%s: expected %d failures and a lockout, got %+v", scope, guesses, attempt)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql/driver"
+	"slices"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	moderncsqlite "modernc.org/sqlite"
+)
+
+const tracerName = "github.com/mine/shorturl/internal/store/sqlite"
+
+// QueryObserver is told how long each statement took, by operation such as
+// "select" or "insert". Queries are timed until their first row is ready, not
+// until the caller has read them all.
+type QueryObserver func(operation string, elapsed time.Duration)
+
+type OpenOption func(*openOptions)
+
+type openOptions struct {
+	observer QueryObserver
+}
+
+func WithQueryObserver(observer QueryObserver) OpenOption {
+	return func(o *openOptions) {
+		o.observer = observer
+	}
+}
+
+// observedConnector times every statement and traces it as a child of the
+// span in its context.
+type observedConnector struct {
+	dsn      string
+	driver   *moderncsqlite.Driver
+	observer QueryObserver
+}
+
+func (c observedConnector) Connect(context.Context) (driver.Conn, error) {
+	conn, err := c.driver.Open(c.dsn)
+	if err != nil {
+		return nil, err
+	}
+	inner, ok := conn.(sqliteConn)
+	if !ok {
+		return conn, nil
+	}
+	return &observedConn{sqliteConn: inner, observer: c.observer}, nil
+}
+
+func (c observedConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+type sqliteConn interface {
+	driver.Conn
+	driver.ConnBeginTx
+	driver.ConnPrepareContext
+	driver.ExecerContext
+	driver.QueryerContext
+	driver.Pinger
+	driver.SessionResetter
+	driver.Validator
+}
+
+type observedConn struct {
+	sqliteConn
+	observer QueryObserver
+}
+
+func (c *observedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	done := c.observe(ctx, query)
+	result, err := c.sqliteConn.ExecContext(ctx, query, args)
+	done(err)
+	return result, err
+}
+
+func (c *observedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	done := c.observe(ctx, query)
+	rows, err := c.sqliteConn.QueryContext(ctx, query, args)
+	done(err)
+	return rows, err
+}
+
+// observe only traces statements run on behalf of a traced caller, so polling
+// such as the webhook dispatcher's does not start root spans of its own.
+func (c *observedConn) observe(ctx context.Context, query string) func(error) {
+	operation := queryOperation(query)
+	started := time.Now()
+
+	var span trace.Span
+	if trace.SpanContextFromContext(ctx).IsValid() {
+		_, span = otel.Tracer(tracerName).Start(ctx, "sqlite "+operation,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(semconv.DBSystemSqlite, semconv.DBOperationName(operation), semconv.DBQueryText(strings.TrimSpace(query))),
+		)
+	}
+	return func(err error) {
+		if c.observer != nil {
+			c.observer(operation, time.Since(started))
+		}
+		if span == nil {
+			return
+		}
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+}
+
+var queryOperations = []string{"select", "insert", "update", "delete", "create", "begin", "commit", "rollback", "pragma", "with"}
+
+func queryOperation(query string) string {
+	fields := strings.Fields(query)
+	if len(fields) == 0 {
+		return "other"
+	}
+	if operation := strings.ToLower(fields[0]); slices.Contains(queryOperations, operation) {
+		return operation
+	}
+	return "other"
+}
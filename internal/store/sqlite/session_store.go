@@ -0,0 +1,280 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gorilla/securecookie"
+	gsessions "github.com/gorilla/sessions"
+
+	"github.com/mine/shorturl/internal/auth"
+)
+
+const sessionTouchInterval = time.Minute
+
+var sessionTokenEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// SessionStore keeps session values in the sessions table and only a signed
+// session token in the cookie, so sessions can be listed and revoked.
+type SessionStore struct {
+	db      *sql.DB
+	codecs  []securecookie.Codec
+	options *gsessions.Options
+	userKey any
+	now     func() time.Time
+}
+
+func NewSessionStore(db *sql.DB, userKey any, keyPairs ...[]byte) *SessionStore {
+	store := &SessionStore{
+		db:      db,
+		codecs:  securecookie.CodecsFromPairs(keyPairs...),
+		options: &gsessions.Options{Path: "/", MaxAge: int((14 * 24 * time.Hour).Seconds())},
+		userKey: userKey,
+		now:     time.Now,
+	}
+	store.setMaxAge(store.options.MaxAge)
+	return store
+}
+
+func (s *SessionStore) Options(options sessions.Options) {
+	s.options = options.ToGorillaOptions()
+	s.setMaxAge(s.options.MaxAge)
+}
+
+func (s *SessionStore) Get(r *http.Request, name string) (*gsessions.Session, error) {
+	return gsessions.GetRegistry(r).Get(s, name)
+}
+
+func (s *SessionStore) New(r *http.Request, name string) (*gsessions.Session, error) {
+	session := gsessions.NewSession(s, name)
+	options := *s.options
+	session.Options = &options
+	session.IsNew = true
+
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+
+	var token string
+	if err := securecookie.DecodeMulti(name, cookie.Value, &token, s.codecs...); err != nil {
+		return session, nil
+	}
+
+	found, err := s.load(r, name, token, session)
+	if err != nil {
+		return session, err
+	}
+	if found {
+		session.ID = token
+		session.IsNew = false
+	}
+	return session, nil
+}
+
+// Save deletes the row when MaxAge <= 0, which is how logout ends a session.
+func (s *SessionStore) Save(r *http.Request, w http.ResponseWriter, session *gsessions.Session) error {
+	ctx := r.Context()
+	if session.Options.MaxAge <= 0 {
+		if session.ID != "" {
+			if _, err := s.db.ExecContext(ctx, `DELETE FROM sessions WHERE token = ?`, session.ID); err != nil {
+				return err
+			}
+		}
+		http.SetCookie(w, gsessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+
+	username, _ := session.Values[s.userKey].(string)
+	if session.ID != "" && username != "" {
+		// A token handed out before login must not become the signed-in
+		// session, or whoever planted it would share the login.
+		var previous string
+		switch err := s.db.QueryRowContext(ctx, `SELECT username FROM sessions WHERE token = ?`, session.ID).Scan(&previous); {
+		case errors.Is(err, sql.ErrNoRows):
+			previous = username
+		case err != nil:
+			return err
+		}
+		if previous != username {
+			if _, err := s.db.ExecContext(ctx, `DELETE FROM sessions WHERE token = ?`, session.ID); err != nil {
+				return err
+			}
+			session.ID = ""
+		}
+	}
+	if session.ID == "" {
+		session.ID = sessionTokenEncoding.EncodeToString(securecookie.GenerateRandomKey(32))
+	}
+
+	data, err := securecookie.EncodeMulti(session.Name(), session.Values, s.codecs...)
+	if err != nil {
+		return err
+	}
+
+	now := s.now().UTC()
+	_, err = s.db.ExecContext(
+		ctx,
+		`INSERT INTO sessions(token, username, data, ip, user_agent, created_at, last_seen_at, expires_at)
+		 VALUES(?, ?, ?, ?, ?, ?, ?, ?)
+		 ON CONFLICT(token) DO UPDATE SET
+			username = excluded.username,
+			data = excluded.data,
+			ip = excluded.ip,
+			user_agent = excluded.user_agent,
+			last_seen_at = excluded.last_seen_at,
+			expires_at = excluded.expires_at`,
+		session.ID,
+		username,
+		data,
+		requestIP(r),
+		r.UserAgent(),
+		formatSQLiteTime(now),
+		formatSQLiteTime(now),
+		formatSQLiteTime(now.Add(time.Duration(session.Options.MaxAge)*time.Second)),
+	)
+	if err != nil {
+		return err
+	}
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM sessions WHERE expires_at <= ?`, formatSQLiteTime(now)); err != nil {
+		return err
+	}
+
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.codecs...)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, gsessions.NewCookie(session.Name(), encoded, session.Options))
+	return nil
+}
+
+func (s *SessionStore) ListUserSessions(ctx context.Context, username string, currentToken string) ([]auth.Session, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT id, token, username, ip, user_agent, created_at, last_seen_at, expires_at
+		 FROM sessions
+		 WHERE username = ? AND expires_at > ?
+		 ORDER BY last_seen_at DESC`,
+		username,
+		formatSQLiteTime(s.now()),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]auth.Session, 0)
+	for rows.Next() {
+		var (
+			item                                  auth.Session
+			rawCreated, rawLastSeen, rawExpiresAt string
+		)
+		if err := rows.Scan(&item.ID, &item.Token, &item.Username, &item.IP, &item.UserAgent, &rawCreated, &rawLastSeen, &rawExpiresAt); err != nil {
+			return nil, err
+		}
+		if item.CreatedAt, err = parseSQLiteTime(rawCreated); err != nil {
+			return nil, err
+		}
+		if item.LastSeenAt, err = parseSQLiteTime(rawLastSeen); err != nil {
+			return nil, err
+		}
+		if item.ExpiresAt, err = parseSQLiteTime(rawExpiresAt); err != nil {
+			return nil, err
+		}
+		item.Current = currentToken != "" && item.Token == currentToken
+		result = append(result, item)
+	}
+
+	return result, rows.Err()
+}
+
+func (s *SessionStore) RevokeUserSession(ctx context.Context, username string, id int64) error {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM sessions WHERE id = ? AND username = ?`, id, username)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return auth.ErrSessionNotFound
+	}
+	return nil
+}
+
+// RevokeUserSessions removes every session of username except exceptToken,
+// which may be empty to revoke all of them.
+func (s *SessionStore) RevokeUserSessions(ctx context.Context, username string, exceptToken string) (int64, error) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM sessions WHERE username = ? AND token <> ?`, username, exceptToken)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (s *SessionStore) load(r *http.Request, name string, token string, session *gsessions.Session) (bool, error) {
+	var (
+		data        string
+		rawLastSeen string
+	)
+	now := s.now().UTC()
+	err := s.db.QueryRowContext(
+		r.Context(),
+		`SELECT data, last_seen_at FROM sessions WHERE token = ? AND expires_at > ?`,
+		token,
+		formatSQLiteTime(now),
+	).Scan(&data, &rawLastSeen)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	if err := securecookie.DecodeMulti(name, data, &session.Values, s.codecs...); err != nil {
+		return false, nil
+	}
+
+	if lastSeen, err := parseSQLiteTime(rawLastSeen); err == nil && now.Sub(lastSeen) >= sessionTouchInterval {
+		_, err := s.db.ExecContext(
+			r.Context(),
+			`UPDATE sessions SET last_seen_at = ?, ip = ?, user_agent = ? WHERE token = ?`,
+			formatSQLiteTime(now),
+			requestIP(r),
+			r.UserAgent(),
+			token,
+		)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+func (s *SessionStore) setMaxAge(age int) {
+	for _, codec := range s.codecs {
+		if secure, ok := codec.(*securecookie.SecureCookie); ok {
+			secure.MaxAge(age)
+			secure.MaxLength(0)
+		}
+	}
+}
+
+func requestIP(r *http.Request) string {
+	if ip := auth.ClientIP(r.Context()); ip != "" {
+		return ip
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
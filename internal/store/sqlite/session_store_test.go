@@ -0,0 +1,120 @@
+package sqlite
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	gsessions "github.com/gorilla/sessions"
+
+	"github.com/mine/shorturl/internal/auth"
+)
+
+func TestDisablingUserRevokesSessions(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "session-test.db")
+	database, err := Open(dbPath)
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	t.Cleanup(func() { _ = database.Close() })
+
+	ctx := context.Background()
+	if err := Init(ctx, database); err != nil {
+		t.Fatalf("init db: %v", err)
+	}
+
+	users := NewUserRepository(database)
+	user, err := users.ProvisionExternalUser(ctx, auth.ExternalIdentity{
+		Source:   auth.SourceOIDC,
+		Username: "bob@example.com",
+		Email:    "bob@example.com",
+		Role:     auth.RoleViewer,
+	})
+	if err != nil {
+		t.Fatalf("provision user: %v", err)
+	}
+
+	store := NewSessionStore(database, "uid", []byte("01234567890123456789012345678901"))
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	session := gsessions.NewSession(store, "shorturl_session")
+	options := gsessions.Options{Path: "/", MaxAge: 3600}
+	session.Options = &options
+	session.Values["uid"] = user.Username
+	if err := store.Save(request, httptest.NewRecorder(), session); err != nil {
+		t.Fatalf("save session: %v", err)
+	}
+
+	active, err := store.ListUserSessions(ctx, user.Username, session.ID)
+	if err != nil {
+		t.Fatalf("list sessions: %v", err)
+	}
+	if len(active) != 1 || !active[0].Current || active[0].IP != "192.0.2.1" {
+		t.Fatalf("expected one current session, got %+v", active)
+	}
+
+	if _, err := users.UpdateUser(ctx, user.ID, user.Role, true); err != nil {
+		t.Fatalf("disable user: %v", err)
+	}
+
+	active, err = store.ListUserSessions(ctx, user.Username, session.ID)
+	if err != nil {
+		t.Fatalf("list sessions: %v", err)
+	}
+	if len(active) != 0 {
+		t.Fatalf("expected sessions to be revoked, got %+v", active)
+	}
+
+	if _, err := users.ProvisionExternalUser(ctx, auth.ExternalIdentity{
+		Source:   auth.SourceOIDC,
+		Username: "bob@example.com",
+		Role:     auth.RoleViewer,
+	}); err != auth.ErrAccessDenied {
+		t.Fatalf("expected disabled user to be denied, got %v", err)
+	}
+}
+
+func TestSaveRotatesTokenOnLogin(t *testing.T) {
+	database, err := Open(filepath.Join(t.TempDir(), "session-rotate-test.db"))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	t.Cleanup(func() { _ = database.Close() })
+
+	ctx := context.Background()
+	if err := Init(ctx, database); err != nil {
+		t.Fatalf("init db: %v", err)
+	}
+
+	store := NewSessionStore(database, "uid", []byte("01234567890123456789012345678901"))
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	session := gsessions.NewSession(store, "shorturl_session")
+	options := gsessions.Options{Path: "/", MaxAge: 3600}
+	session.Options = &options
+	session.Values["oidc_state"] = "state"
+	if err := store.Save(request, httptest.NewRecorder(), session); err != nil {
+		t.Fatalf("save anonymous session: %v", err)
+	}
+	anonymous := session.ID
+
+	session.Values["uid"] = "alice"
+	if err := store.Save(request, httptest.NewRecorder(), session); err != nil {
+		t.Fatalf("save login: %v", err)
+	}
+	if session.ID == anonymous {
+		t.Fatalf("expected login to issue a new token")
+	}
+	var remaining int
+	if err := database.QueryRowContext(ctx, `SELECT COUNT(*) FROM sessions WHERE token = ?`, anonymous).Scan(&remaining); err != nil || remaining != 0 {
+		t.Fatalf("expected the pre-login session to be deleted, got %d err=%v", remaining, err)
+	}
+
+	loggedIn := session.ID
+	if err := store.Save(request, httptest.NewRecorder(), session); err != nil {
+		t.Fatalf("save again: %v", err)
+	}
+	if session.ID != loggedIn {
+		t.Fatalf("expected the token to stay the same for the same user")
+	}
+}
@@ -7,6 +7,8 @@ import (
 	"strings"
 
 	"golang.org/x/crypto/bcrypt"
+
+	"github.com/mine/shorturl/internal/auth"
 )
 
 type UserRepository struct {
@@ -48,7 +50,7 @@ func (r *UserRepository) CheckPassword(ctx context.Context, username string, pas
 	var hash string
 	err := r.db.QueryRowContext(
 		ctx,
-		`SELECT password_hash FROM users WHERE username = ?`,
+		`SELECT password_hash FROM users WHERE username = ? AND source = 'local' AND disabled = 0`,
 		strings.TrimSpace(username),
 	).Scan(&hash)
 	if err != nil {
@@ -64,3 +66,189 @@ func (r *UserRepository) CheckPassword(ctx context.Context, username string, pas
 
 	return true, nil
 }
+
+func (r *UserRepository) GetUser(ctx context.Context, username string) (auth.User, error) {
+	row := r.db.QueryRowContext(
+		ctx,
+		`SELECT id, username, email, role, source, disabled, created_at FROM users WHERE username = ?`,
+		strings.TrimSpace(username),
+	)
+
+	user, err := scanUser(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return auth.User{}, auth.ErrUserNotFound
+		}
+		return auth.User{}, err
+	}
+
+	return user, nil
+}
+
+// ProvisionExternalUser creates the user on first login and re-syncs email and
+// role on later logins. It refuses to take over an account from another source.
+func (r *UserRepository) ProvisionExternalUser(ctx context.Context, identity auth.ExternalIdentity) (auth.User, error) {
+	existing, err := r.GetUser(ctx, identity.Username)
+	switch {
+	case errors.Is(err, auth.ErrUserNotFound):
+		_, err = r.db.ExecContext(
+			ctx,
+			`INSERT INTO users(username, password_hash, email, role, source) VALUES(?, '', ?, ?, ?)`,
+			strings.TrimSpace(identity.Username),
+			identity.Email,
+			string(identity.Role),
+			identity.Source,
+		)
+		if err != nil {
+			if isUniqueConstraintError(err) {
+				return auth.User{}, auth.ErrUserConflict
+			}
+			return auth.User{}, err
+		}
+	case err != nil:
+		return auth.User{}, err
+	case existing.Source != identity.Source:
+		return auth.User{}, auth.ErrUserConflict
+	case existing.Disabled:
+		return auth.User{}, auth.ErrAccessDenied
+	default:
+		_, err = r.db.ExecContext(
+			ctx,
+			`UPDATE users SET email = ?, role = ? WHERE id = ?`,
+			identity.Email,
+			string(identity.Role),
+			existing.ID,
+		)
+		if err != nil {
+			return auth.User{}, err
+		}
+	}
+
+	return r.GetUser(ctx, identity.Username)
+}
+
+func (r *UserRepository) GetUserByID(ctx context.Context, id int64) (auth.User, error) {
+	row := r.db.QueryRowContext(
+		ctx,
+		`SELECT id, username, email, role, source, disabled, created_at FROM users WHERE id = ?`,
+		id,
+	)
+
+	user, err := scanUser(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return auth.User{}, auth.ErrUserNotFound
+		}
+		return auth.User{}, err
+	}
+
+	return user, nil
+}
+
+func (r *UserRepository) ListUsers(ctx context.Context) ([]auth.User, error) {
+	rows, err := r.db.QueryContext(
+		ctx,
+		`SELECT id, username, email, role, source, disabled, created_at FROM users ORDER BY id ASC`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]auth.User, 0)
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, user)
+	}
+
+	return result, rows.Err()
+}
+
+// UpdateUser changes role and disabled state. Disabling a user revokes all of
+// their sessions in the same transaction.
+func (r *UserRepository) UpdateUser(ctx context.Context, id int64, role auth.Role, disabled bool) (auth.User, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return auth.User{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var username string
+	if err := tx.QueryRowContext(ctx, `SELECT username FROM users WHERE id = ?`, id).Scan(&username); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return auth.User{}, auth.ErrUserNotFound
+		}
+		return auth.User{}, err
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE users SET role = ?, disabled = ? WHERE id = ?`, string(role), boolToInt(disabled), id); err != nil {
+		return auth.User{}, err
+	}
+	if disabled {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM sessions WHERE username = ?`, username); err != nil {
+			return auth.User{}, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return auth.User{}, err
+	}
+
+	return r.GetUserByID(ctx, id)
+}
+
+// ChangePassword verifies the current password of a local user, stores the new
+// hash and revokes every session of that user. Callers that want to keep the
+// current session logged in must save it again afterwards.
+func (r *UserRepository) ChangePassword(ctx context.Context, username string, currentPassword string, newPassword string) error {
+	ok, err := r.CheckPassword(ctx, username, currentPassword)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return auth.ErrInvalidCredentials
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.ExecContext(ctx, `UPDATE users SET password_hash = ? WHERE username = ? AND source = 'local'`, string(hash), strings.TrimSpace(username)); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM sessions WHERE username = ?`, strings.TrimSpace(username)); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func scanUser(scanTarget scanner) (auth.User, error) {
+	var (
+		user     auth.User
+		role     string
+		disabled int
+	)
+	if err := scanTarget.Scan(&user.ID, &user.Username, &user.Email, &role, &user.Source, &disabled, &user.CreatedAt); err != nil {
+		return auth.User{}, err
+	}
+	user.Role = auth.Role(role)
+	user.Disabled = disabled != 0
+	return user, nil
+}
+
+func boolToInt(value bool) int {
+	if value {
+		return 1
+	}
+	return 0
+}
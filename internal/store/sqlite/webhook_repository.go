@@ -0,0 +1,312 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mine/shorturl/internal/webhooks"
+)
+
+type WebhookRepository struct {
+	db *sql.DB
+}
+
+func NewWebhookRepository(db *sql.DB) *WebhookRepository {
+	return &WebhookRepository{db: db}
+}
+
+const webhookColumns = `id, url, secret, events_json, enabled, created_at, updated_at`
+
+func (r *WebhookRepository) ListWebhooks(ctx context.Context) ([]webhooks.Subscription, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT `+webhookColumns+` FROM webhooks ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []webhooks.Subscription{}
+	for rows.Next() {
+		sub, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, sub)
+	}
+	return result, rows.Err()
+}
+
+func (r *WebhookRepository) GetWebhook(ctx context.Context, id int64) (webhooks.Subscription, error) {
+	sub, err := scanWebhook(r.db.QueryRowContext(ctx, `SELECT `+webhookColumns+` FROM webhooks WHERE id = ?`, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return webhooks.Subscription{}, webhooks.ErrNotFound
+		}
+		return webhooks.Subscription{}, err
+	}
+	return sub, nil
+}
+
+func (r *WebhookRepository) CreateWebhook(ctx context.Context, sub webhooks.Subscription) (webhooks.Subscription, error) {
+	eventsJSON, err := json.Marshal(sub.Events)
+	if err != nil {
+		return webhooks.Subscription{}, fmt.Errorf("marshal webhook events: %w", err)
+	}
+
+	now := formatSQLiteTime(time.Now())
+	result, err := r.db.ExecContext(
+		ctx,
+		`INSERT INTO webhooks(url, secret, events_json, enabled, created_at, updated_at) VALUES(?, ?, ?, ?, ?, ?)`,
+		sub.URL,
+		sub.Secret,
+		string(eventsJSON),
+		boolToInt(sub.Enabled),
+		now,
+		now,
+	)
+	if err != nil {
+		return webhooks.Subscription{}, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return webhooks.Subscription{}, err
+	}
+	return r.GetWebhook(ctx, id)
+}
+
+func (r *WebhookRepository) UpdateWebhook(ctx context.Context, sub webhooks.Subscription) (webhooks.Subscription, error) {
+	eventsJSON, err := json.Marshal(sub.Events)
+	if err != nil {
+		return webhooks.Subscription{}, fmt.Errorf("marshal webhook events: %w", err)
+	}
+
+	result, err := r.db.ExecContext(
+		ctx,
+		`UPDATE webhooks SET url = ?, secret = ?, events_json = ?, enabled = ?, updated_at = ? WHERE id = ?`,
+		sub.URL,
+		sub.Secret,
+		string(eventsJSON),
+		boolToInt(sub.Enabled),
+		formatSQLiteTime(time.Now()),
+		sub.ID,
+	)
+	if err != nil {
+		return webhooks.Subscription{}, err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return webhooks.Subscription{}, err
+	} else if affected == 0 {
+		return webhooks.Subscription{}, webhooks.ErrNotFound
+	}
+	return r.GetWebhook(ctx, sub.ID)
+}
+
+// DeleteWebhook also removes the subscription's delivery log and queue.
+func (r *WebhookRepository) DeleteWebhook(ctx context.Context, id int64) error {
+	result, err := r.db.ExecContext(ctx, `DELETE FROM webhooks WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return webhooks.ErrNotFound
+	}
+	return nil
+}
+
+func (r *WebhookRepository) EnqueueDeliveries(ctx context.Context, deliveries []webhooks.Delivery) ([]webhooks.Delivery, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	for i := range deliveries {
+		result, err := tx.ExecContext(
+			ctx,
+			`INSERT INTO webhook_deliveries(webhook_id, event_id, event_type, payload, status, attempts, next_attempt_at, created_at)
+			 VALUES(?, ?, ?, ?, ?, 0, ?, ?)`,
+			deliveries[i].SubscriptionID,
+			deliveries[i].EventID,
+			deliveries[i].EventType,
+			string(deliveries[i].Payload),
+			deliveries[i].Status,
+			nullableTime(deliveries[i].NextAttemptAt),
+			formatSQLiteTime(deliveries[i].CreatedAt),
+		)
+		if err != nil {
+			return nil, err
+		}
+		if deliveries[i].ID, err = result.LastInsertId(); err != nil {
+			return nil, err
+		}
+	}
+	return deliveries, tx.Commit()
+}
+
+const deliveryColumns = `id, webhook_id, event_id, event_type, payload, status, attempts, next_attempt_at,
+	response_status, last_error, created_at, delivered_at`
+
+func (r *WebhookRepository) ClaimDueDeliveries(ctx context.Context, now time.Time, leaseUntil time.Time, limit int) ([]webhooks.Delivery, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	rows, err := tx.QueryContext(
+		ctx,
+		`SELECT `+deliveryColumns+`
+		 FROM webhook_deliveries
+		 WHERE status = ? AND next_attempt_at <= ?
+		 ORDER BY next_attempt_at, id
+		 LIMIT ?`,
+		webhooks.StatusPending,
+		formatSQLiteTime(now),
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	due := []webhooks.Delivery{}
+	for rows.Next() {
+		delivery, err := scanDelivery(rows)
+		if err != nil {
+			_ = rows.Close()
+			return nil, err
+		}
+		due = append(due, delivery)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, delivery := range due {
+		if _, err := tx.ExecContext(ctx, `UPDATE webhook_deliveries SET next_attempt_at = ? WHERE id = ?`, formatSQLiteTime(leaseUntil), delivery.ID); err != nil {
+			return nil, err
+		}
+	}
+	return due, tx.Commit()
+}
+
+func (r *WebhookRepository) UpdateDelivery(ctx context.Context, delivery webhooks.Delivery) error {
+	_, err := r.db.ExecContext(
+		ctx,
+		`UPDATE webhook_deliveries
+		 SET status = ?, attempts = ?, next_attempt_at = ?, response_status = ?, last_error = ?, delivered_at = ?
+		 WHERE id = ?`,
+		delivery.Status,
+		delivery.Attempts,
+		nullableTime(delivery.NextAttemptAt),
+		delivery.ResponseStatus,
+		delivery.LastError,
+		nullableTime(delivery.DeliveredAt),
+		delivery.ID,
+	)
+	return err
+}
+
+func (r *WebhookRepository) ListDeliveries(ctx context.Context, subscriptionID int64, limit int) ([]webhooks.Delivery, error) {
+	rows, err := r.db.QueryContext(
+		ctx,
+		`SELECT `+deliveryColumns+` FROM webhook_deliveries WHERE webhook_id = ? ORDER BY id DESC LIMIT ?`,
+		subscriptionID,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []webhooks.Delivery{}
+	for rows.Next() {
+		delivery, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, delivery)
+	}
+	return result, rows.Err()
+}
+
+func scanWebhook(scanTarget scanner) (webhooks.Subscription, error) {
+	var (
+		sub                        webhooks.Subscription
+		eventsJSON                 string
+		enabled                    int
+		rawCreatedAt, rawUpdatedAt string
+	)
+	if err := scanTarget.Scan(&sub.ID, &sub.URL, &sub.Secret, &eventsJSON, &enabled, &rawCreatedAt, &rawUpdatedAt); err != nil {
+		return webhooks.Subscription{}, err
+	}
+	if err := json.Unmarshal([]byte(eventsJSON), &sub.Events); err != nil {
+		return webhooks.Subscription{}, fmt.Errorf("decode webhook events: %w", err)
+	}
+	sub.Enabled = enabled != 0
+
+	var err error
+	if sub.CreatedAt, err = parseSQLiteTime(rawCreatedAt); err != nil {
+		return webhooks.Subscription{}, err
+	}
+	if sub.UpdatedAt, err = parseSQLiteTime(rawUpdatedAt); err != nil {
+		return webhooks.Subscription{}, err
+	}
+	return sub, nil
+}
+
+func scanDelivery(scanTarget scanner) (webhooks.Delivery, error) {
+	var (
+		delivery                     webhooks.Delivery
+		payload, rawCreatedAt        string
+		rawNextAttempt, rawDelivered sql.NullString
+	)
+	if err := scanTarget.Scan(
+		&delivery.ID,
+		&delivery.SubscriptionID,
+		&delivery.EventID,
+		&delivery.EventType,
+		&payload,
+		&delivery.Status,
+		&delivery.Attempts,
+		&rawNextAttempt,
+		&delivery.ResponseStatus,
+		&delivery.LastError,
+		&rawCreatedAt,
+		&rawDelivered,
+	); err != nil {
+		return webhooks.Delivery{}, err
+	}
+	delivery.Payload = json.RawMessage(payload)
+
+	var err error
+	if delivery.CreatedAt, err = parseSQLiteTime(rawCreatedAt); err != nil {
+		return webhooks.Delivery{}, err
+	}
+	if delivery.NextAttemptAt, err = parseNullableTime(rawNextAttempt); err != nil {
+		return webhooks.Delivery{}, err
+	}
+	if delivery.DeliveredAt, err = parseNullableTime(rawDelivered); err != nil {
+		return webhooks.Delivery{}, err
+	}
+	return delivery, nil
+}
+
+func parseNullableTime(raw sql.NullString) (*time.Time, error) {
+	if !raw.Valid || raw.String == "" {
+		return nil, nil
+	}
+	value, err := parseSQLiteTime(raw.String)
+	if err != nil {
+		return nil, err
+	}
+	return &value, nil
+}
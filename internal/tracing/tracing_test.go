@@ -0,0 +1,35 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+)
+
+func TestSetupWithoutExporterOnlyInstallsPropagator(t *testing.T) {
+	previousProvider, previousPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previousProvider)
+		otel.SetTextMapPropagator(previousPropagator)
+	})
+
+	shutdown, err := Setup(context.Background(), Config{Exporter: ExporterNone, ServiceName: "shorturl"})
+	if err != nil {
+		t.Fatalf("setup: %v", err)
+	}
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+	if otel.GetTracerProvider() != previousProvider {
+		t.Fatalf("expected the tracer provider to be left alone")
+	}
+	fields := otel.GetTextMapPropagator().Fields()
+	if len(fields) == 0 || fields[0] != "traceparent" {
+		t.Fatalf("expected the W3C trace context propagator, got %v", fields)
+	}
+
+	if _, err := Setup(context.Background(), Config{Exporter: "zipkin"}); err == nil {
+		t.Fatalf("expected an unknown exporter to be rejected")
+	}
+}
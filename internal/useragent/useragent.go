@@ -0,0 +1,246 @@
+// Package useragent turns User-Agent strings into browser, OS and device
+// details using an ordered, regex-based rules file. The built-in rules are
+// embedded; deployments can point at their own copy to pick up new agents
+// without a rebuild.
+package useragent
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+//go:embed rules.json
+var defaultRules []byte
+
+// Client types reported by Parse.
+const (
+	TypeBrowser = "browser"
+	TypeApp     = "app"
+	TypeTool    = "tool"
+)
+
+// Device types reported by Parse.
+const (
+	DeviceDesktop = "desktop"
+	DeviceMobile  = "mobile"
+	DeviceTablet  = "tablet"
+)
+
+// Client is what a User-Agent says about the visitor. Fields the rules cannot
+// determine are left empty.
+type Client struct {
+	// Name and Version describe what the visitor used: the app for in-app
+	// browsers, otherwise the browser or tool.
+	Name    string
+	Version string
+	Type    string
+	InApp   bool
+
+	Browser        string
+	BrowserVersion string
+
+	OS        string
+	OSVersion string
+
+	DeviceType  string
+	DeviceBrand string
+	DeviceModel string
+}
+
+// Rule matches the first rule in its list whose pattern matches. Version is a
+// regexp.Expand template and defaults to the first non-empty capture group.
+type Rule struct {
+	Name    string `json:"name"`
+	Pattern string `json:"pattern"`
+	Version string `json:"version,omitempty"`
+
+	re *regexp.Regexp
+}
+
+// DeviceRule fills brand and model; Model is a regexp.Expand template. Type,
+// when set, overrides the device type guessed from the User-Agent.
+type DeviceRule struct {
+	Brand   string `json:"brand"`
+	Model   string `json:"model"`
+	Type    string `json:"type,omitempty"`
+	Pattern string `json:"pattern"`
+
+	re *regexp.Regexp
+}
+
+// Rules is the on-disk format. Patterns are matched case-insensitively and
+// each list is checked in order, so specific entries go first.
+type Rules struct {
+	Version  string       `json:"version"`
+	Apps     []Rule       `json:"apps"`
+	Tools    []Rule       `json:"tools"`
+	Browsers []Rule       `json:"browsers"`
+	OS       []Rule       `json:"os"`
+	Devices  []DeviceRule `json:"devices"`
+}
+
+type Parser struct {
+	rules Rules
+}
+
+var (
+	defaultOnce   sync.Once
+	defaultParser *Parser
+)
+
+// Default returns a parser for the embedded rules.
+func Default() *Parser {
+	defaultOnce.Do(func() {
+		parser, err := New(defaultRules)
+		if err != nil {
+			panic(fmt.Sprintf("useragent: embedded rules: %v", err))
+		}
+		defaultParser = parser
+	})
+	return defaultParser
+}
+
+// Load reads a rules file. An empty path means the embedded rules.
+func Load(path string) (*Parser, error) {
+	if path == "" {
+		return Default(), nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read user agent rules: %w", err)
+	}
+	return New(data)
+}
+
+func New(data []byte) (*Parser, error) {
+	var rules Rules
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("decode user agent rules: %w", err)
+	}
+	if strings.TrimSpace(rules.Version) == "" {
+		return nil, fmt.Errorf("user agent rules: version is required")
+	}
+
+	for _, list := range [][]Rule{rules.Apps, rules.Tools, rules.Browsers, rules.OS} {
+		for i := range list {
+			re, err := compile(list[i].Pattern)
+			if err != nil {
+				return nil, err
+			}
+			list[i].re = re
+		}
+	}
+	for i := range rules.Devices {
+		re, err := compile(rules.Devices[i].Pattern)
+		if err != nil {
+			return nil, err
+		}
+		rules.Devices[i].re = re
+	}
+
+	return &Parser{rules: rules}, nil
+}
+
+func compile(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, fmt.Errorf("user agent rules: empty pattern")
+	}
+	re, err := regexp.Compile("(?i)" + pattern)
+	if err != nil {
+		return nil, fmt.Errorf("user agent rules: %w", err)
+	}
+	return re, nil
+}
+
+// Version identifies the rules, so stored visits can be re-parsed when it
+// changes.
+func (p *Parser) Version() string {
+	return p.rules.Version
+}
+
+func (p *Parser) Parse(userAgent string) Client {
+	var client Client
+	ua := strings.TrimSpace(userAgent)
+	if ua == "" {
+		return client
+	}
+
+	client.Browser, client.BrowserVersion = match(p.rules.Browsers, ua, 1)
+	client.OS, client.OSVersion = match(p.rules.OS, ua, 2)
+
+	if name, version := match(p.rules.Apps, ua, 1); name != "" {
+		client.Name, client.Version, client.Type, client.InApp = name, version, TypeApp, true
+	} else if name, version := match(p.rules.Tools, ua, 1); name != "" {
+		client.Name, client.Version, client.Type = name, version, TypeTool
+	} else if client.Browser != "" {
+		client.Name, client.Version, client.Type = client.Browser, client.BrowserVersion, TypeBrowser
+	}
+
+	client.DeviceType = guessDeviceType(strings.ToLower(ua), client.OS)
+	for _, rule := range p.rules.Devices {
+		submatches := rule.re.FindStringSubmatchIndex(ua)
+		if submatches == nil {
+			continue
+		}
+		client.DeviceBrand = rule.Brand
+		client.DeviceModel = strings.TrimSpace(string(rule.re.ExpandString(nil, rule.Model, ua, submatches)))
+		if rule.Type != "" {
+			client.DeviceType = rule.Type
+		}
+		break
+	}
+
+	return client
+}
+
+// match returns the first matching rule's name and version, keeping at most
+// parts dot-separated components of the version.
+func match(rules []Rule, ua string, parts int) (string, string) {
+	for _, rule := range rules {
+		submatches := rule.re.FindStringSubmatchIndex(ua)
+		if submatches == nil {
+			continue
+		}
+		var version string
+		if rule.Version != "" {
+			version = string(rule.re.ExpandString(nil, rule.Version, ua, submatches))
+		} else {
+			for i := 2; i+1 < len(submatches); i += 2 {
+				if submatches[i] >= 0 && submatches[i+1] > submatches[i] {
+					version = ua[submatches[i]:submatches[i+1]]
+					break
+				}
+			}
+		}
+		return rule.Name, trimVersion(version, parts)
+	}
+	return "", ""
+}
+
+func trimVersion(version string, parts int) string {
+	components := strings.Split(strings.ReplaceAll(version, "_", "."), ".")
+	if len(components) > parts {
+		components = components[:parts]
+	}
+	return strings.Trim(strings.Join(components, "."), ".")
+}
+
+// guessDeviceType applies before device rules, which may override it. Android
+// tablets are the ones that leave "Mobile" out.
+func guessDeviceType(ua string, osName string) string {
+	switch {
+	case osName == "iPadOS", strings.Contains(ua, "tablet"), strings.Contains(ua, "ipad"):
+		return DeviceTablet
+	case strings.Contains(ua, "android") && !strings.Contains(ua, "mobile"):
+		return DeviceTablet
+	case strings.Contains(ua, "mobile"), strings.Contains(ua, "iphone"), strings.Contains(ua, "phone"), strings.Contains(ua, "android"):
+		return DeviceMobile
+	default:
+		return DeviceDesktop
+	}
+}
@@ -0,0 +1,111 @@
+package useragent
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseDefaultRules(t *testing.T) {
+	tests := []struct {
+		name string
+		ua   string
+		want Client
+	}{
+		{
+			name: "samsung internet",
+			ua:   "Mozilla/5.0 (Linux; Android 14; SM-S918B) AppleWebKit/537.36 (KHTML, like Gecko) SamsungBrowser/25.0 Chrome/121.0.0.0 Mobile Safari/537.36",
+			want: Client{Name: "Samsung Internet", Version: "25", Type: TypeBrowser, Browser: "Samsung Internet", BrowserVersion: "25", OS: "Android", OSVersion: "14", DeviceType: DeviceMobile, DeviceBrand: "Samsung", DeviceModel: "SM-S918B"},
+		},
+		{
+			name: "wechat on iphone",
+			ua:   "Mozilla/5.0 (iPhone; CPU iPhone OS 17_5_1 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Mobile/15E148 MicroMessenger/8.0.49(0x18003129) NetType/WIFI Language/zh_CN",
+			want: Client{Name: "微信", Version: "8", Type: TypeApp, InApp: true, Browser: "iOS WebView", OS: "iOS", OSVersion: "17.5", DeviceType: DeviceMobile, DeviceBrand: "Apple", DeviceModel: "iPhone"},
+		},
+		{
+			name: "wecom is not wechat",
+			ua:   "Mozilla/5.0 (Windows NT 10.0; WOW64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/98.0.4758.102 Safari/537.36 MicroMessenger/7.0.20.1781(0x6700143B) NetType/WIFI MiniProgramEnv/Windows WindowsWechat/WMPF wxwork/4.1.22",
+			want: Client{Name: "企业微信", Version: "4", Type: TypeApp, InApp: true, Browser: "Chrome", BrowserVersion: "98", OS: "Windows", OSVersion: "10", DeviceType: DeviceDesktop},
+		},
+		{
+			name: "harmonyos next",
+			ua:   "Mozilla/5.0 (Phone; OpenHarmony 4.1) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/114.0.0.0 Safari/537.36 ArkWeb/4.1.6.1 Mobile HuaweiBrowser/5.0.4.300",
+			want: Client{Name: "华为浏览器", Version: "5", Type: TypeBrowser, Browser: "华为浏览器", BrowserVersion: "5", OS: "HarmonyOS", OSVersion: "4.1", DeviceType: DeviceMobile},
+		},
+		{
+			name: "harmonyos on android base",
+			ua:   "Mozilla/5.0 (Linux; Android 10; HarmonyOS; ELS-AN00; HMSCore 6.11.0.302) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/99.0.4844.88 HuaweiBrowser/14.0.2.311 Mobile Safari/537.36",
+			want: Client{Name: "华为浏览器", Version: "14", Type: TypeBrowser, Browser: "华为浏览器", BrowserVersion: "14", OS: "HarmonyOS", DeviceType: DeviceMobile, DeviceBrand: "Huawei", DeviceModel: "ELS-AN00"},
+		},
+		{
+			name: "ipados desktop mode",
+			ua:   "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Mobile/15E148 Safari/604.1",
+			want: Client{Name: "Safari", Version: "17", Type: TypeBrowser, Browser: "Safari", BrowserVersion: "17", OS: "iPadOS", DeviceType: DeviceTablet, DeviceBrand: "Apple", DeviceModel: "iPad"},
+		},
+		{
+			name: "mac safari",
+			ua:   "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Safari/605.1.15",
+			want: Client{Name: "Safari", Version: "17", Type: TypeBrowser, Browser: "Safari", BrowserVersion: "17", OS: "macOS", OSVersion: "10.15", DeviceType: DeviceDesktop, DeviceBrand: "Apple", DeviceModel: "Mac"},
+		},
+		{
+			name: "edge on windows",
+			ua:   "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0.0.0 Safari/537.36 Edg/126.0.2592.87",
+			want: Client{Name: "Edge", Version: "126", Type: TypeBrowser, Browser: "Edge", BrowserVersion: "126", OS: "Windows", OSVersion: "10", DeviceType: DeviceDesktop},
+		},
+		{
+			name: "android tablet webview",
+			ua:   "Mozilla/5.0 (Linux; Android 13; SM-X710; wv) AppleWebKit/537.36 (KHTML, like Gecko) Version/4.0 Chrome/120.0.6099.230 Safari/537.36",
+			want: Client{Name: "Android WebView", Version: "120", Type: TypeBrowser, Browser: "Android WebView", BrowserVersion: "120", OS: "Android", OSVersion: "13", DeviceType: DeviceTablet, DeviceBrand: "Samsung", DeviceModel: "SM-X710"},
+		},
+		{
+			name: "xiaomi chrome",
+			ua:   "Mozilla/5.0 (Linux; Android 12; Redmi Note 11 Build/SKQ1.211103.001) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/118.0.0.0 Mobile Safari/537.36",
+			want: Client{Name: "Chrome", Version: "118", Type: TypeBrowser, Browser: "Chrome", BrowserVersion: "118", OS: "Android", OSVersion: "12", DeviceType: DeviceMobile, DeviceBrand: "Xiaomi", DeviceModel: "Redmi Note 11"},
+		},
+		{
+			name: "curl",
+			ua:   "curl/8.4.0",
+			want: Client{Name: "curl", Version: "8", Type: TypeTool, DeviceType: DeviceDesktop},
+		},
+		{
+			name: "empty",
+			ua:   "  ",
+			want: Client{},
+		},
+	}
+
+	parser := Default()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parser.Parse(tt.ua); got != tt.want {
+				t.Fatalf("Parse(%q)\n got %+v\nwant %+v", tt.ua, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadRulesFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.json")
+	rules := `{"version": "custom-1", "apps": [{"name": "Acme", "pattern": "AcmeApp/(\\d+)"}]}`
+	if err := os.WriteFile(path, []byte(rules), 0o600); err != nil {
+		t.Fatalf("write rules: %v", err)
+	}
+
+	parser, err := Load(path)
+	if err != nil {
+		t.Fatalf("load rules: %v", err)
+	}
+	if parser.Version() != "custom-1" {
+		t.Fatalf("expected custom version, got %q", parser.Version())
+	}
+	if got := parser.Parse("Mozilla/5.0 AcmeApp/3.2"); got.Name != "Acme" || got.Version != "3" || !got.InApp {
+		t.Fatalf("unexpected client %+v", got)
+	}
+
+	if _, err := New([]byte(`{"version": "bad", "os": [{"name": "x", "pattern": "("}]}`)); err == nil {
+		t.Fatal("expected invalid pattern to be rejected")
+	}
+	if _, err := New([]byte(`{"apps": []}`)); err == nil {
+		t.Fatal("expected missing version to be rejected")
+	}
+}
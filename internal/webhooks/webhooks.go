@@ -0,0 +1,552 @@
+// Package webhooks delivers link events to subscriber URLs. Events are queued
+// in the database and sent by a background dispatcher, so redirects never wait
+// on a subscriber and deliveries survive restarts.
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mine/shorturl/internal/links"
+)
+
+var (
+	ErrNotFound   = errors.New("webhook not found")
+	ErrValidation = errors.New("validation failed")
+)
+
+// EventTest is only sent by SendTest, whatever the subscription's events.
+const EventTest = "webhook.test"
+
+// EventTypes are the events a subscription can choose from.
+var EventTypes = []string{
+	links.EventLinkCreated,
+	links.EventLinkUpdated,
+	links.EventLinkDeleted,
+	links.EventLinkClicked,
+	links.EventLinkExpired,
+}
+
+// Delivery statuses. Deliveries still queued when their subscription is
+// disabled or stops listening for the event are canceled rather than sent.
+const (
+	StatusPending   = "pending"
+	StatusDelivered = "delivered"
+	StatusFailed    = "failed"
+	StatusCanceled  = "canceled"
+)
+
+// Request headers. The signature is "sha256=" followed by the hex HMAC of
+// "<timestamp>.<body>" keyed with the subscription secret; receivers should
+// reject stale timestamps to stop replays.
+const (
+	HeaderEvent     = "X-Shorturl-Event"
+	HeaderDelivery  = "X-Shorturl-Delivery"
+	HeaderTimestamp = "X-Shorturl-Timestamp"
+	HeaderSignature = "X-Shorturl-Signature"
+)
+
+const (
+	defaultMaxAttempts  = 8
+	defaultBaseBackoff  = 30 * time.Second
+	defaultMaxBackoff   = 6 * time.Hour
+	defaultTimeout      = 10 * time.Second
+	defaultPollInterval = 5 * time.Second
+	deliveryBatchSize   = 20
+	defaultDeliveryPage = 50
+	maxDeliveryPage     = 200
+	maxResponseError    = 500
+	eventBufferSize     = 1024
+)
+
+// Subscription is a receiver URL and the events it wants. The secret is never
+// serialised; it is shown once, when the subscription is created.
+type Subscription struct {
+	ID        int64     `json:"id"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"-"`
+	Events    []string  `json:"events"`
+	Enabled   bool      `json:"enabled"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// SubscriptionInput creates or replaces a subscription. An empty Secret is
+// generated on create and left unchanged on update; Enabled defaults to true.
+type SubscriptionInput struct {
+	URL     string   `json:"url"`
+	Secret  string   `json:"secret"`
+	Events  []string `json:"events"`
+	Enabled *bool    `json:"enabled"`
+}
+
+// Event is the JSON body posted to subscribers.
+type Event struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      any       `json:"data"`
+}
+
+type Delivery struct {
+	ID             int64           `json:"id"`
+	SubscriptionID int64           `json:"webhook_id"`
+	EventID        string          `json:"event_id"`
+	EventType      string          `json:"event_type"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  *time.Time      `json:"next_attempt_at,omitempty"`
+	ResponseStatus int             `json:"response_status,omitempty"`
+	LastError      string          `json:"last_error,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+	DeliveredAt    *time.Time      `json:"delivered_at,omitempty"`
+}
+
+type Repository interface {
+	ListWebhooks(ctx context.Context) ([]Subscription, error)
+	GetWebhook(ctx context.Context, id int64) (Subscription, error)
+	CreateWebhook(ctx context.Context, sub Subscription) (Subscription, error)
+	UpdateWebhook(ctx context.Context, sub Subscription) (Subscription, error)
+	DeleteWebhook(ctx context.Context, id int64) error
+	EnqueueDeliveries(ctx context.Context, deliveries []Delivery) ([]Delivery, error)
+	// ClaimDueDeliveries returns pending deliveries due by now and pushes
+	// their next attempt to leaseUntil, so a crashed send is retried later
+	// instead of being lost or sent twice at once.
+	ClaimDueDeliveries(ctx context.Context, now time.Time, leaseUntil time.Time, limit int) ([]Delivery, error)
+	UpdateDelivery(ctx context.Context, delivery Delivery) error
+	ListDeliveries(ctx context.Context, subscriptionID int64, limit int) ([]Delivery, error)
+}
+
+type Service struct {
+	repo         Repository
+	logger       *slog.Logger
+	client       *http.Client
+	maxAttempts  int
+	baseBackoff  time.Duration
+	maxBackoff   time.Duration
+	pollInterval time.Duration
+	now          func() time.Time
+	wake         chan struct{}
+	events       chan links.LinkEvent
+
+	mu         sync.Mutex
+	subs       []Subscription
+	subsLoaded bool // false until first use and after every change
+}
+
+type Option func(*Service)
+
+func WithHTTPClient(client *http.Client) Option {
+	return func(s *Service) {
+		if client != nil {
+			s.client = client
+		}
+	}
+}
+
+// WithRetryPolicy gives up after maxAttempts sends, waiting base, 2*base,
+// 4*base... between them, capped at maxBackoff. Zero values keep the defaults.
+func WithRetryPolicy(maxAttempts int, base time.Duration, maxBackoff time.Duration) Option {
+	return func(s *Service) {
+		if maxAttempts > 0 {
+			s.maxAttempts = maxAttempts
+		}
+		if base > 0 {
+			s.baseBackoff = base
+		}
+		if maxBackoff > 0 {
+			s.maxBackoff = maxBackoff
+		}
+		s.maxBackoff = max(s.maxBackoff, s.baseBackoff)
+	}
+}
+
+func NewService(repo Repository, logger *slog.Logger, opts ...Option) *Service {
+	s := &Service{
+		repo:         repo,
+		logger:       logger,
+		client:       &http.Client{Timeout: defaultTimeout},
+		maxAttempts:  defaultMaxAttempts,
+		baseBackoff:  defaultBaseBackoff,
+		maxBackoff:   defaultMaxBackoff,
+		pollInterval: defaultPollInterval,
+		now:          time.Now,
+		wake:         make(chan struct{}, 1),
+		events:       make(chan links.LinkEvent, eventBufferSize),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+func (s *Service) List(ctx context.Context) ([]Subscription, error) {
+	return s.repo.ListWebhooks(ctx)
+}
+
+func (s *Service) Get(ctx context.Context, id int64) (Subscription, error) {
+	return s.repo.GetWebhook(ctx, id)
+}
+
+// Create returns the stored subscription including its secret.
+func (s *Service) Create(ctx context.Context, input SubscriptionInput) (Subscription, error) {
+	sub, err := normalizeInput(input)
+	if err != nil {
+		return Subscription{}, err
+	}
+	if sub.Secret == "" {
+		sub.Secret = newSecret()
+	}
+	sub.Enabled = input.Enabled == nil || *input.Enabled
+	defer s.invalidate()
+	return s.repo.CreateWebhook(ctx, sub)
+}
+
+func (s *Service) Update(ctx context.Context, id int64, input SubscriptionInput) (Subscription, error) {
+	current, err := s.repo.GetWebhook(ctx, id)
+	if err != nil {
+		return Subscription{}, err
+	}
+	sub, err := normalizeInput(input)
+	if err != nil {
+		return Subscription{}, err
+	}
+
+	current.URL = sub.URL
+	current.Events = sub.Events
+	if sub.Secret != "" {
+		current.Secret = sub.Secret
+	}
+	if input.Enabled != nil {
+		current.Enabled = *input.Enabled
+	}
+	defer s.invalidate()
+	return s.repo.UpdateWebhook(ctx, current)
+}
+
+func (s *Service) Delete(ctx context.Context, id int64) error {
+	defer s.invalidate()
+	return s.repo.DeleteWebhook(ctx, id)
+}
+
+func (s *Service) invalidate() {
+	s.mu.Lock()
+	s.subs, s.subsLoaded = nil, false
+	s.mu.Unlock()
+}
+
+// subscriptions returns the cached subscription list, loading it if needed.
+func (s *Service) subscriptions(ctx context.Context) ([]Subscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.subsLoaded {
+		subs, err := s.repo.ListWebhooks(ctx)
+		if err != nil {
+			return nil, err
+		}
+		s.subs, s.subsLoaded = subs, true
+	}
+	return s.subs, nil
+}
+
+// Deliveries lists a subscription's most recent deliveries, newest first.
+func (s *Service) Deliveries(ctx context.Context, id int64, limit int) ([]Delivery, error) {
+	if _, err := s.repo.GetWebhook(ctx, id); err != nil {
+		return nil, err
+	}
+	switch {
+	case limit <= 0:
+		limit = defaultDeliveryPage
+	case limit > maxDeliveryPage:
+		limit = maxDeliveryPage
+	}
+	return s.repo.ListDeliveries(ctx, id, limit)
+}
+
+// SendTest queues a webhook.test event for one subscription, even a disabled
+// one, so its receiver can be checked before events are switched on.
+func (s *Service) SendTest(ctx context.Context, id int64) (Delivery, error) {
+	sub, err := s.repo.GetWebhook(ctx, id)
+	if err != nil {
+		return Delivery{}, err
+	}
+	deliveries, err := s.enqueue(ctx, EventTest, map[string]any{"webhook_id": sub.ID}, []Subscription{sub})
+	if err != nil {
+		return Delivery{}, err
+	}
+	return deliveries[0], nil
+}
+
+// Observe implements links.LinkObserver. It runs on the redirect path, so it
+// only buffers the event; the dispatcher turns it into deliveries. Events are
+// dropped if the buffer is full.
+func (s *Service) Observe(_ context.Context, event links.LinkEvent) {
+	select {
+	case s.events <- event:
+		s.notifyDispatcher()
+	default:
+		s.logger.Warn("webhook event buffer full, dropping event", "event", event.Type, "link_id", event.Link.ID)
+	}
+}
+
+// queueObserved queues deliveries for the events buffered by Observe.
+func (s *Service) queueObserved(ctx context.Context) {
+	for {
+		select {
+		case event := <-s.events:
+			if err := s.queueEvent(ctx, event); err != nil {
+				s.logger.Error("queue webhook deliveries failed", "error", err, "event", event.Type)
+			}
+		default:
+			return
+		}
+	}
+}
+
+// queueEvent queues event for every enabled subscription that asked for it.
+func (s *Service) queueEvent(ctx context.Context, event links.LinkEvent) error {
+	subs, err := s.subscriptions(ctx)
+	if err != nil {
+		return err
+	}
+	subs = slices.DeleteFunc(slices.Clone(subs), func(sub Subscription) bool {
+		return !wants(sub, event.Type)
+	})
+	if len(subs) == 0 {
+		return nil
+	}
+
+	data := map[string]any{"link": event.Link}
+	if event.Visit != nil {
+		data["visit"] = event.Visit
+	}
+	_, err = s.enqueue(ctx, event.Type, data, subs)
+	return err
+}
+
+func wants(sub Subscription, eventType string) bool {
+	return sub.Enabled && slices.Contains(sub.Events, eventType)
+}
+
+func (s *Service) enqueue(ctx context.Context, eventType string, data any, subs []Subscription) ([]Delivery, error) {
+	now := s.now().UTC()
+	event := Event{ID: newEventID(), Type: eventType, CreatedAt: now, Data: data}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return nil, fmt.Errorf("marshal webhook event: %w", err)
+	}
+
+	deliveries := make([]Delivery, 0, len(subs))
+	for _, sub := range subs {
+		deliveries = append(deliveries, Delivery{
+			SubscriptionID: sub.ID,
+			EventID:        event.ID,
+			EventType:      eventType,
+			Payload:        payload,
+			Status:         StatusPending,
+			NextAttemptAt:  &now,
+			CreatedAt:      now,
+		})
+	}
+	// Deliveries outlive the request that caused them.
+	deliveries, err = s.repo.EnqueueDeliveries(context.WithoutCancel(ctx), deliveries)
+	if err != nil {
+		return nil, err
+	}
+	s.notifyDispatcher()
+	return deliveries, nil
+}
+
+func (s *Service) notifyDispatcher() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run sends due deliveries until ctx is done, polling for retries and waking
+// early whenever something is queued. Buffered events are still queued on the
+// way out, so they are sent after a restart.
+func (s *Service) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := s.DeliverDue(ctx); err != nil && ctx.Err() == nil {
+			s.logger.Error("deliver webhooks failed", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			s.queueObserved(context.WithoutCancel(ctx))
+			return
+		case <-ticker.C:
+		case <-s.wake:
+		}
+	}
+}
+
+// DeliverDue queues buffered events, then sends every delivery that is due
+// and reports how many were attempted.
+func (s *Service) DeliverDue(ctx context.Context) (int, error) {
+	s.queueObserved(ctx)
+
+	attempted := 0
+	for {
+		now := s.now().UTC()
+		due, err := s.repo.ClaimDueDeliveries(ctx, now, now.Add(2*s.client.Timeout+time.Minute), deliveryBatchSize)
+		if err != nil {
+			return attempted, err
+		}
+		if len(due) == 0 {
+			return attempted, nil
+		}
+
+		subs := map[int64]Subscription{}
+		for _, delivery := range due {
+			sub, ok := subs[delivery.SubscriptionID]
+			if !ok {
+				// A subscription deleted meanwhile takes its deliveries with it.
+				if sub, err = s.repo.GetWebhook(ctx, delivery.SubscriptionID); errors.Is(err, ErrNotFound) {
+					continue
+				} else if err != nil {
+					return attempted, err
+				}
+				subs[sub.ID] = sub
+			}
+			if delivery.EventType != EventTest && !wants(sub, delivery.EventType) {
+				delivery.Status = StatusCanceled
+				delivery.NextAttemptAt = nil
+				delivery.LastError = "webhook disabled or no longer subscribed to this event"
+				if err := s.repo.UpdateDelivery(ctx, delivery); err != nil {
+					return attempted, err
+				}
+				continue
+			}
+			if err := s.repo.UpdateDelivery(ctx, s.attempt(ctx, sub, delivery)); err != nil {
+				return attempted, err
+			}
+			attempted++
+		}
+	}
+}
+
+// attempt sends delivery once and returns it updated with the outcome.
+func (s *Service) attempt(ctx context.Context, sub Subscription, delivery Delivery) Delivery {
+	delivery.Attempts++
+	status, err := s.send(ctx, sub, delivery)
+	now := s.now().UTC()
+	delivery.ResponseStatus = status
+	if err == nil {
+		delivery.Status = StatusDelivered
+		delivery.DeliveredAt = &now
+		delivery.NextAttemptAt = nil
+		delivery.LastError = ""
+		return delivery
+	}
+
+	delivery.LastError = err.Error()
+	if delivery.Attempts >= s.maxAttempts {
+		delivery.Status = StatusFailed
+		delivery.NextAttemptAt = nil
+		s.logger.Warn("webhook delivery failed", "webhook_id", sub.ID, "delivery_id", delivery.ID, "attempts", delivery.Attempts, "error", err)
+		return delivery
+	}
+	next := now.Add(s.backoff(delivery.Attempts))
+	delivery.NextAttemptAt = &next
+	return delivery
+}
+
+func (s *Service) backoff(attempts int) time.Duration {
+	wait := s.baseBackoff
+	for i := 1; i < attempts && wait < s.maxBackoff; i++ {
+		wait *= 2
+	}
+	return min(wait, s.maxBackoff)
+}
+
+func (s *Service) send(ctx context.Context, sub Subscription, delivery Delivery) (int, error) {
+	timestamp := strconv.FormatInt(s.now().Unix(), 10)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "shorturl-webhooks/1")
+	req.Header.Set(HeaderEvent, delivery.EventType)
+	req.Header.Set(HeaderDelivery, strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, Sign(sub.Secret, timestamp, delivery.Payload))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseError))
+		return resp.StatusCode, fmt.Errorf("unexpected status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return resp.StatusCode, nil
+}
+
+// Sign computes the X-Shorturl-Signature value for a request body.
+func Sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func normalizeInput(input SubscriptionInput) (Subscription, error) {
+	rawURL := strings.TrimSpace(input.URL)
+	parsed, err := url.Parse(rawURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return Subscription{}, fmt.Errorf("%w: invalid url", ErrValidation)
+	}
+
+	events := make([]string, 0, len(input.Events))
+	for _, event := range input.Events {
+		event = strings.TrimSpace(event)
+		if !slices.Contains(EventTypes, event) {
+			return Subscription{}, fmt.Errorf("%w: unknown event %q", ErrValidation, event)
+		}
+		if !slices.Contains(events, event) {
+			events = append(events, event)
+		}
+	}
+	if len(events) == 0 {
+		return Subscription{}, fmt.Errorf("%w: at least one event is required", ErrValidation)
+	}
+
+	return Subscription{URL: rawURL, Secret: strings.TrimSpace(input.Secret), Events: events}, nil
+}
+
+func newSecret() string {
+	buf := make([]byte, 24)
+	_, _ = rand.Read(buf)
+	return "whsec_" + hex.EncodeToString(buf)
+}
+
+func newEventID() string {
+	buf := make([]byte, 12)
+	_, _ = rand.Read(buf)
+	return "evt_" + hex.EncodeToString(buf)
+}
@@ -0,0 +1,308 @@
+package webhooks
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mine/shorturl/internal/links"
+)
+
+func TestSign(t *testing.T) {
+	got := Sign("whsec_test", "1700000000", []byte(`{"id":"evt_1"}`))
+	if want := "sha256=c89214b5b5da833daed6f0b8c5bb6bd58cea9022bd80ccc78230f3942d632925"; got != want {
+		t.Fatalf("got %q want %q", got, want)
+	}
+}
+
+func TestBackoffDoublesUpToMax(t *testing.T) {
+	s := NewService(newMemoryRepository(), discardLogger(), WithRetryPolicy(5, time.Second, 5*time.Second))
+
+	for attempts, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 5 * time.Second, 10: 5 * time.Second} {
+		if got := s.backoff(attempts); got != want {
+			t.Errorf("backoff(%d) = %v, want %v", attempts, got, want)
+		}
+	}
+}
+
+func TestObserveQueuesOffTheRequestPath(t *testing.T) {
+	receiver := newReceiver(t, http.StatusOK)
+	repo := newMemoryRepository()
+	s := NewService(repo, discardLogger())
+	ctx := context.Background()
+
+	sub, err := s.Create(ctx, SubscriptionInput{URL: receiver.URL, Events: []string{links.EventLinkClicked}})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	before := repo.calls()
+	s.Observe(ctx, links.LinkEvent{Type: links.EventLinkClicked, Link: links.Link{ID: 1, Code: "a"}})
+	s.Observe(ctx, links.LinkEvent{Type: links.EventLinkCreated, Link: links.Link{ID: 1, Code: "a"}})
+	if calls := repo.calls() - before; calls != 0 {
+		t.Fatalf("expected Observe not to touch the repository, got %d calls", calls)
+	}
+
+	if attempted, err := s.DeliverDue(ctx); err != nil || attempted != 1 {
+		t.Fatalf("expected one delivery, got %d err=%v", attempted, err)
+	}
+	if got := receiver.events(); !slices.Equal(got, []string{links.EventLinkClicked}) {
+		t.Fatalf("unexpected events %v", got)
+	}
+
+	// The subscription list is cached until it changes.
+	s.Observe(ctx, links.LinkEvent{Type: links.EventLinkClicked, Link: links.Link{ID: 1, Code: "a"}})
+	if _, err := s.DeliverDue(ctx); err != nil {
+		t.Fatalf("deliver: %v", err)
+	}
+	if repo.listCalls != 1 {
+		t.Fatalf("expected subscriptions to be listed once, got %d", repo.listCalls)
+	}
+	if _, err := s.Update(ctx, sub.ID, SubscriptionInput{URL: receiver.URL, Events: []string{links.EventLinkCreated}}); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	s.Observe(ctx, links.LinkEvent{Type: links.EventLinkCreated, Link: links.Link{ID: 1, Code: "a"}})
+	if _, err := s.DeliverDue(ctx); err != nil {
+		t.Fatalf("deliver: %v", err)
+	}
+	if got := receiver.events(); !slices.Equal(got, []string{links.EventLinkClicked, links.EventLinkClicked, links.EventLinkCreated}) {
+		t.Fatalf("unexpected events after update %v", got)
+	}
+}
+
+func TestDeliverDueCancelsDeliveriesForDisabledWebhooks(t *testing.T) {
+	receiver := newReceiver(t, http.StatusOK)
+	repo := newMemoryRepository()
+	s := NewService(repo, discardLogger())
+	ctx := context.Background()
+
+	sub, err := s.Create(ctx, SubscriptionInput{URL: receiver.URL, Events: []string{links.EventLinkClicked}})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	s.Observe(ctx, links.LinkEvent{Type: links.EventLinkClicked, Link: links.Link{ID: 1, Code: "a"}})
+	s.queueObserved(ctx)
+
+	disabled := false
+	if _, err := s.Update(ctx, sub.ID, SubscriptionInput{URL: receiver.URL, Events: []string{links.EventLinkClicked}, Enabled: &disabled}); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	// Test events are still sent to disabled subscriptions.
+	if _, err := s.SendTest(ctx, sub.ID); err != nil {
+		t.Fatalf("send test: %v", err)
+	}
+	if _, err := s.DeliverDue(ctx); err != nil {
+		t.Fatalf("deliver: %v", err)
+	}
+
+	if got := receiver.events(); !slices.Equal(got, []string{EventTest}) {
+		t.Fatalf("expected only the test event to be sent, got %v", got)
+	}
+	deliveries, err := s.Deliveries(ctx, sub.ID, 0)
+	if err != nil {
+		t.Fatalf("deliveries: %v", err)
+	}
+	statuses := map[string]string{}
+	for _, delivery := range deliveries {
+		statuses[delivery.EventType] = delivery.Status
+	}
+	if statuses[links.EventLinkClicked] != StatusCanceled || statuses[EventTest] != StatusDelivered {
+		t.Fatalf("unexpected statuses %v", statuses)
+	}
+}
+
+func TestDeliverDueRetriesThenFails(t *testing.T) {
+	receiver := newReceiver(t, http.StatusInternalServerError)
+	repo := newMemoryRepository()
+	s := NewService(repo, discardLogger(), WithRetryPolicy(2, time.Minute, time.Minute))
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	sub, err := s.Create(ctx, SubscriptionInput{URL: receiver.URL, Events: []string{links.EventLinkCreated}})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	s.Observe(ctx, links.LinkEvent{Type: links.EventLinkCreated, Link: links.Link{ID: 1, Code: "a"}})
+
+	if attempted, _ := s.DeliverDue(ctx); attempted != 1 {
+		t.Fatalf("expected first attempt, got %d", attempted)
+	}
+	if attempted, _ := s.DeliverDue(ctx); attempted != 0 {
+		t.Fatalf("expected no attempt before the backoff, got %d", attempted)
+	}
+	now = now.Add(time.Minute)
+	if attempted, _ := s.DeliverDue(ctx); attempted != 1 {
+		t.Fatalf("expected retry after the backoff, got %d", attempted)
+	}
+
+	deliveries, err := s.Deliveries(ctx, sub.ID, 0)
+	if err != nil {
+		t.Fatalf("deliveries: %v", err)
+	}
+	if len(deliveries) != 1 || deliveries[0].Status != StatusFailed || deliveries[0].Attempts != 2 || deliveries[0].ResponseStatus != http.StatusInternalServerError {
+		t.Fatalf("expected a failed delivery after two attempts, got %+v", deliveries)
+	}
+}
+
+type receiver struct {
+	*httptest.Server
+	mu   sync.Mutex
+	seen []string
+}
+
+func newReceiver(t *testing.T, status int) *receiver {
+	r := &receiver{}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		_, _ = io.Copy(io.Discard, req.Body)
+		r.mu.Lock()
+		r.seen = append(r.seen, req.Header.Get(HeaderEvent))
+		r.mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func (r *receiver) events() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.seen)
+}
+
+// memoryRepository keeps subscriptions and deliveries in memory and counts
+// the calls made to it.
+type memoryRepository struct {
+	mu         sync.Mutex
+	subs       []Subscription
+	deliveries []Delivery
+	nextID     int64
+	callCount  int
+	listCalls  int
+}
+
+func newMemoryRepository() *memoryRepository {
+	return &memoryRepository{}
+}
+
+func (r *memoryRepository) calls() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.callCount
+}
+
+func (r *memoryRepository) ListWebhooks(context.Context) ([]Subscription, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.callCount++
+	r.listCalls++
+	return slices.Clone(r.subs), nil
+}
+
+func (r *memoryRepository) GetWebhook(_ context.Context, id int64) (Subscription, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.callCount++
+	for _, sub := range r.subs {
+		if sub.ID == id {
+			return sub, nil
+		}
+	}
+	return Subscription{}, ErrNotFound
+}
+
+func (r *memoryRepository) CreateWebhook(_ context.Context, sub Subscription) (Subscription, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.callCount++
+	r.nextID++
+	sub.ID = r.nextID
+	r.subs = append(r.subs, sub)
+	return sub, nil
+}
+
+func (r *memoryRepository) UpdateWebhook(_ context.Context, sub Subscription) (Subscription, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.callCount++
+	for i := range r.subs {
+		if r.subs[i].ID == sub.ID {
+			r.subs[i] = sub
+			return sub, nil
+		}
+	}
+	return Subscription{}, ErrNotFound
+}
+
+func (r *memoryRepository) DeleteWebhook(_ context.Context, id int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.callCount++
+	r.subs = slices.DeleteFunc(r.subs, func(sub Subscription) bool { return sub.ID == id })
+	r.deliveries = slices.DeleteFunc(r.deliveries, func(delivery Delivery) bool { return delivery.SubscriptionID == id })
+	return nil
+}
+
+func (r *memoryRepository) EnqueueDeliveries(_ context.Context, deliveries []Delivery) ([]Delivery, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.callCount++
+	for i := range deliveries {
+		r.nextID++
+		deliveries[i].ID = r.nextID
+	}
+	r.deliveries = append(r.deliveries, deliveries...)
+	return deliveries, nil
+}
+
+func (r *memoryRepository) ClaimDueDeliveries(_ context.Context, now time.Time, leaseUntil time.Time, limit int) ([]Delivery, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.callCount++
+	due := []Delivery{}
+	for i := range r.deliveries {
+		delivery := &r.deliveries[i]
+		if len(due) == limit || delivery.Status != StatusPending || delivery.NextAttemptAt == nil || delivery.NextAttemptAt.After(now) {
+			continue
+		}
+		due = append(due, *delivery)
+		lease := leaseUntil
+		delivery.NextAttemptAt = &lease
+	}
+	return due, nil
+}
+
+func (r *memoryRepository) UpdateDelivery(_ context.Context, delivery Delivery) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.callCount++
+	for i := range r.deliveries {
+		if r.deliveries[i].ID == delivery.ID {
+			r.deliveries[i] = delivery
+		}
+	}
+	return nil
+}
+
+func (r *memoryRepository) ListDeliveries(_ context.Context, subscriptionID int64, limit int) ([]Delivery, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.callCount++
+	result := []Delivery{}
+	for i := len(r.deliveries) - 1; i >= 0 && len(result) < limit; i-- {
+		if r.deliveries[i].SubscriptionID == subscriptionID {
+			result = append(result, r.deliveries[i])
+		}
+	}
+	return result, nil
+}
+
+func discardLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
@@ -5,6 +5,8 @@ export type Link = {
   remark: string;
   tags: string[];
   enabled: boolean;
+  no_tracking?: boolean;
+  expires_at?: string | null;
   click_count: number;
   created_at?: string;
   updated_at?: string;
@@ -15,6 +17,8 @@ export type CreateLinkInput = {
   target_url: string;
   remark: string;
   tags: string[];
+  no_tracking?: boolean;
+  expires_at?: string | null;
 };
 
 export type UpdateLinkInput = {
@@ -23,6 +27,8 @@ export type UpdateLinkInput = {
   remark: string;
   tags: string[];
   enabled: boolean;
+  no_tracking?: boolean;
+  expires_at?: string | null;
 };
 
 export type AuthSession = {
@@ -33,6 +39,7 @@ export type AuthSession = {
 export type VisitPoint = {
   bucket: string;
   clicks: number;
+  visitors?: number;
 };
 
 export type VisitBreakdown = {
@@ -40,6 +47,21 @@ export type VisitBreakdown = {
   count: number;
 };
 
+export type LanguageBreakdown = VisitBreakdown & {
+  regions: VisitBreakdown[];
+};
+
+export type ConversionSummary = {
+  conversions: number;
+  rate: number;
+  value: number;
+};
+
+export type ConversionBreakdown = ConversionSummary & {
+  name: string;
+  clicks: number;
+};
+
 export type VisitRecord = {
   visited_at: string;
   ip_masked: string;
@@ -47,19 +69,188 @@ export type VisitRecord = {
   referer_host: string;
   user_agent: string;
   client_name: string;
+  client_version?: string;
   client_type: string;
   device_type: string;
+  device_brand?: string;
+  device_model?: string;
   os: string;
+  os_version?: string;
+  language?: string;
+  utm_source?: string;
+  utm_medium?: string;
+  utm_campaign?: string;
+  utm_term?: string;
+  utm_content?: string;
+  traffic_type?: "human" | "bot" | "preview" | "prefetch";
+  country_code?: string;
+  region?: string;
+  city?: string;
+  asn?: number;
+  as_org?: string;
 };
 
 export type LinkAnalytics = {
   link: Link;
   range_days: number;
+  from?: string;
+  to?: string;
+  granularity?: "5m" | "hour" | "day";
+  timezone?: string;
   recent_clicks: number;
   unique_ips: number;
+  unique_visitors?: number;
+  new_visitors?: number;
+  returning_visitors?: number;
   last_visited_at?: string;
   time_series: VisitPoint[];
   top_referrers: VisitBreakdown[];
   top_clients: VisitBreakdown[];
+  top_client_types?: VisitBreakdown[];
+  top_devices?: VisitBreakdown[];
+  top_os?: VisitBreakdown[];
+  top_client_versions?: VisitBreakdown[];
+  top_os_versions?: VisitBreakdown[];
+  top_device_models?: VisitBreakdown[];
+  top_languages?: LanguageBreakdown[];
+  conversions?: ConversionSummary;
+  conversions_by_referrer?: ConversionBreakdown[];
+  campaigns?: Record<string, VisitBreakdown[]>;
+  top_countries?: VisitBreakdown[];
+  top_cities?: VisitBreakdown[];
   recent_visits: VisitRecord[];
+  include_bots?: boolean;
+  bot_traffic?: BotTraffic;
+  comparison?: AnalyticsComparison;
+};
+
+export type Delta = {
+  current: number;
+  previous: number;
+  change: number;
+  percent: number | null;
+};
+
+export type AnalyticsComparison = {
+  from: string;
+  to: string;
+  clicks: Delta;
+  unique_visitors: Delta;
+  time_series: VisitPoint[];
+  top_referrers: (Delta & { name: string })[];
+  top_clients: (Delta & { name: string })[];
+};
+
+export type BotTraffic = {
+  total: number;
+  by_type: VisitBreakdown[];
+  top_agents: VisitBreakdown[];
+};
+
+export type AnalyticsOverview = {
+  range_days: number;
+  from: string;
+  to: string;
+  granularity: "5m" | "hour" | "day";
+  timezone: string;
+  include_bots: boolean;
+  total_clicks: number;
+  unique_visitors: number;
+  new_links: number;
+  time_series: VisitPoint[];
+  top_links: VisitBreakdown[];
+  top_referrers: VisitBreakdown[];
+  top_clients: VisitBreakdown[];
+  top_devices: VisitBreakdown[];
+  top_os: VisitBreakdown[];
+  top_languages: LanguageBreakdown[];
+  conversions: ConversionSummary;
+  conversions_by_link: ConversionBreakdown[];
+};
+
+export type TagAnalytics = {
+  range_days: number;
+  from: string;
+  to: string;
+  granularity: "5m" | "hour" | "day";
+  timezone: string;
+  include_bots: boolean;
+  tag: string;
+  link_count: number;
+  total_clicks: number;
+  unique_visitors: number;
+  time_series: VisitPoint[];
+  top_links: VisitBreakdown[];
+  top_referrers: VisitBreakdown[];
+  top_languages: LanguageBreakdown[];
+};
+
+export type DimensionFilter = {
+  dimension: string;
+  value: string;
+};
+
+export type Breakdown = {
+  range_days: number;
+  from: string;
+  to: string;
+  granularity: "5m" | "hour" | "day";
+  timezone: string;
+  include_bots: boolean;
+  dimension: string;
+  filters: DimensionFilter[];
+  total: number;
+  items: VisitBreakdown[];
+};
+
+export type TagMatrix = {
+  range_days: number;
+  from: string;
+  to: string;
+  granularity: "5m" | "hour" | "day";
+  timezone: string;
+  include_bots: boolean;
+  buckets: string[];
+  rows: { tag: string; total: number; clicks: number[] }[];
+};
+
+export type LiveVisit = {
+  link_id: number;
+  code: string;
+  tags: string[];
+  visited_at: string;
+  ip_masked: string;
+  referer_host: string;
+  client_name: string;
+  device_type: string;
+  os: string;
+  country_code?: string;
+  traffic_type: "human" | "bot" | "preview" | "prefetch";
+};
+
+export type WebhookEvent = "link.created" | "link.updated" | "link.deleted" | "link.clicked" | "link.expired";
+
+export type Webhook = {
+  id: number;
+  url: string;
+  events: WebhookEvent[];
+  enabled: boolean;
+  created_at: string;
+  updated_at: string;
+  secret?: string;
+};
+
+export type WebhookDelivery = {
+  id: number;
+  webhook_id: number;
+  event_id: string;
+  event_type: WebhookEvent | "webhook.test";
+  payload: unknown;
+  status: "pending" | "delivered" | "failed" | "canceled";
+  attempts: number;
+  next_attempt_at?: string;
+  response_status?: number;
+  last_error?: string;
+  created_at: string;
+  delivered_at?: string;
 };